@@ -0,0 +1,18 @@
+package main
+
+import (
+	"log"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/callback"
+	"github.com/UKHomeOffice/snow-forwarder/internal/redact"
+	"github.com/apex/gateway"
+)
+
+func main() {
+
+	if err := redact.Setup(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Fatal(gateway.ListenAndServe("", callback.Handler()))
+}
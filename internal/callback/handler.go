@@ -0,0 +1,45 @@
+package callback
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Auth is a middleware handler that only lets SNOW through
+type Auth struct {
+	handler http.Handler
+}
+
+// NewAuth constructs a new middleware handler
+func NewAuth(handlerToWrap http.Handler) *Auth {
+	return &Auth{handlerToWrap}
+}
+
+// ServeHTTP checks the bearer token SNOW sends against CALLBACK_TOKEN
+func (a *Auth) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+
+	token, ok := os.LookupEnv("CALLBACK_TOKEN")
+	if !ok || token == "" {
+		http.Error(rw, "missing callback token", http.StatusInternalServerError)
+		return
+	}
+
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") ||
+		subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+		http.Error(rw, "unauthorised", http.StatusUnauthorized)
+		return
+	}
+
+	a.handler.ServeHTTP(rw, req)
+}
+
+// Handler serves a wrapped mux
+func Handler() http.Handler {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", ReceiveHandler)
+	return NewAuth(mux)
+}
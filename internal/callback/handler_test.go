@@ -0,0 +1,51 @@
+package callback
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestAuth(t *testing.T) {
+
+	tt := []struct {
+		name   string
+		token  string
+		header string
+		status int
+	}{
+		{name: "good", token: "s3cret", header: "Bearer s3cret", status: http.StatusOK},
+		{name: "wrong", token: "s3cret", header: "Bearer guess", status: http.StatusUnauthorized},
+		{name: "basic", token: "s3cret", header: "Basic s3cret", status: http.StatusUnauthorized},
+		{name: "missing", token: "s3cret", status: http.StatusUnauthorized},
+		{name: "unconfigured", header: "Bearer ", status: http.StatusInternalServerError},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			os.Setenv("CALLBACK_TOKEN", tc.token)
+			defer os.Unsetenv("CALLBACK_TOKEN")
+
+			ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			r, err := http.NewRequest("POST", "/", nil)
+			if err != nil {
+				t.Fatalf("could not make incoming request: %v", err)
+			}
+			if tc.header != "" {
+				r.Header.Set("Authorization", tc.header)
+			}
+
+			rr := httptest.NewRecorder()
+			NewAuth(ok).ServeHTTP(rr, r)
+
+			if rr.Code != tc.status {
+				t.Errorf("expected status %v, got %v", tc.status, rr.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package callback
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/jira"
+)
+
+// Jira passes SNOW updates on to JSD
+type Jira struct {
+	*jira.Client
+	Transitions map[string]string
+}
+
+// NewJira builds a Jira client from JIRA_API_URL, JIRA_USER, JIRA_TOKEN and
+// the optional JSD_TRANSITIONS map of SNOW state to transition ID
+func NewJira() (*Jira, error) {
+
+	c, err := jira.New()
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Jira{Client: c}
+	if v, ok := os.LookupEnv("JSD_TRANSITIONS"); ok {
+		err = json.Unmarshal([]byte(v), &j.Transitions)
+		if err != nil {
+			return nil, errors.New("could not parse JSD_TRANSITIONS: " + err.Error())
+		}
+	}
+	return j, nil
+}
+
+// Sync comments on the JSD issue and moves it on if SNOW's state maps to a
+// transition. Steps in done are skipped, and record is called with the
+// steps done so far after each one succeeds.
+func (j *Jira) Sync(ctx context.Context, u *Update, done []string, record func([]string) error) error {
+
+	steps := append([]string{}, done...)
+	step := func(name string, fn func() error) error {
+		if contains(steps, name) {
+			return nil
+		}
+		err := fn()
+		if err != nil {
+			return err
+		}
+		steps = append(steps, name)
+		return record(steps)
+	}
+
+	err := step(StepComment, func() error {
+		return j.Comment(ctx, u.SupplierRef, comment(u))
+	})
+	if err != nil {
+		return err
+	}
+
+	id, ok := j.Transitions[u.State]
+	if !ok {
+		return nil
+	}
+	return step(StepTransition, func() error {
+		return j.Transition(ctx, u.SupplierRef, id)
+	})
+}
+
+// contains reports whether s is in list
+func contains(list []string, s string) bool {
+
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// comment describes a SNOW update for JSD
+func comment(u *Update) string {
+
+	var parts []string
+	if u.IntIdent != "" {
+		parts = append(parts, "SNOW change "+u.IntIdent+" updated.")
+	} else {
+		parts = append(parts, "SNOW change updated.")
+	}
+	if u.State != "" {
+		parts = append(parts, "State: "+u.State+".")
+	}
+	if u.Approval != "" {
+		parts = append(parts, "Approval: "+u.Approval+".")
+	}
+	if u.UpdatedBy != "" {
+		parts = append(parts, "By: "+u.UpdatedBy+".")
+	}
+	if u.Comment != "" {
+		parts = append(parts, "\n"+u.Comment)
+	}
+	return strings.Join(parts, " ")
+}
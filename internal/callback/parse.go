@@ -0,0 +1,118 @@
+package callback
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/redact"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Update is a change state or approval update sent by SNOW
+type Update struct {
+	SupplierRef string `json:"supplierRef"`
+	IntIdent    string `json:"internal_identifier"`
+	State       string `json:"state"`
+	Approval    string `json:"approval"`
+	Comment     string `json:"comment"`
+	UpdatedBy   string `json:"updatedBy"`
+	// UpdatedAt is when SNOW made the update, sys_updated_on
+	UpdatedAt string `json:"updatedAt"`
+}
+
+// ParseUpdate reads and checks an update from SNOW
+func ParseUpdate(body []byte) (*Update, error) {
+
+	var u Update
+	err := json.Unmarshal(body, &u)
+	if err != nil {
+		return nil, errors.New("could not parse update: " + err.Error())
+	}
+
+	if u.SupplierRef == "" {
+		return nil, errors.New("missing supplierRef")
+	}
+
+	if u.State == "" && u.Approval == "" && u.Comment == "" {
+		return nil, errors.New("update has no state, approval or comment")
+	}
+
+	log.Printf("processing SNOW update: %v, state: %v, approval: %v\n", u.SupplierRef, u.State, u.Approval)
+	return &u, nil
+}
+
+// ReceiveHandler records an update from SNOW and passes it on to JSD
+func ReceiveHandler(w http.ResponseWriter, req *http.Request) {
+
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, redact.String(err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	u, err := ParseUpdate(body)
+	if err != nil {
+		http.Error(w, redact.String(err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	db, err := newDB()
+	if err != nil {
+		http.Error(w, redact.String(err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	jira, err := NewJira()
+	if err != nil {
+		http.Error(w, redact.String(err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	code, err := process(req.Context(), db, jira, u)
+	if err != nil {
+		log.Printf("could not process SNOW update for %v: %v", u.SupplierRef, err)
+		http.Error(w, redact.String(err.Error()), code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// process records the update and syncs it to JSD, returning the HTTP status
+// SNOW should see on failure
+func process(ctx context.Context, db *DB, jira *Jira, u *Update) (int, error) {
+
+	out, err := db.RecordUpdate(ctx, u)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return http.StatusNotFound, errors.New("unknown change " + u.SupplierRef)
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	// SNOW retries when JSD fails part way, so skip what was already done
+	done := synced(out.Attributes, u)
+	if len(done) > 0 {
+		log.Printf("SNOW update for %v seen before, already did %v", u.SupplierRef, strings.Join(done, ", "))
+	}
+
+	err = jira.Sync(ctx, u, done, func(steps []string) error {
+		return db.RecordSynced(ctx, u, steps)
+	})
+	if err != nil {
+		return http.StatusBadGateway, err
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,178 @@
+package callback
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestParseUpdate(t *testing.T) {
+
+	tt := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{name: "good", input: `{"supplierRef":"abc-1","internal_identifier":"CHG001","state":"Implement","approval":"approved"}`},
+		{name: "comment", input: `{"supplierRef":"abc-1","comment":"CAB asked for a rollback plan"}`},
+		{name: "malformed", input: `supplierRef=abc-1`, err: "could not parse update"},
+		{name: "no ref", input: `{"state":"Implement"}`, err: "missing supplierRef"},
+		{name: "empty", input: `{"supplierRef":"abc-1"}`, err: "no state, approval or comment"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			u, err := ParseUpdate([]byte(tc.input))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if u.SupplierRef != "abc-1" {
+				t.Errorf("expected abc-1, got %v", u.SupplierRef)
+			}
+		})
+	}
+}
+
+func TestProcess(t *testing.T) {
+
+	type call struct {
+		path string
+		body map[string]interface{}
+	}
+
+	retried := Update{SupplierRef: "abc-1", State: "Implement", UpdatedAt: "2020-07-01 12:00:00"}
+	seen := func(u Update, steps ...string) map[string]*dynamodb.AttributeValue {
+		return map[string]*dynamodb.AttributeValue{
+			"jiraSyncKey": {S: aws.String(u.Key())},
+			"jiraSynced":  {SS: aws.StringSlice(steps)},
+		}
+	}
+
+	tt := []struct {
+		name   string
+		update Update
+		old    map[string]*dynamodb.AttributeValue
+		dbErr  error
+		jira   int
+		calls  []string
+		synced []string
+		status int
+		err    string
+	}{
+		{name: "comment", update: Update{SupplierRef: "abc-1", IntIdent: "CHG001", Approval: "approved"},
+			jira: 201, calls: []string{"/rest/api/2/issue/abc-1/comment"}, synced: []string{"comment"}, status: http.StatusOK},
+		{name: "transition", update: Update{SupplierRef: "abc-1", State: "Implement"},
+			jira: 204, calls: []string{"/rest/api/2/issue/abc-1/comment", "/rest/api/2/issue/abc-1/transitions"},
+			synced: []string{"comment", "transition"}, status: http.StatusOK},
+		{name: "retried after comment", update: retried, old: seen(retried, "comment"),
+			jira: 204, calls: []string{"/rest/api/2/issue/abc-1/transitions"}, synced: []string{"comment", "transition"}, status: http.StatusOK},
+		{name: "retried after sync", update: retried, old: seen(retried, "comment", "transition"), status: http.StatusOK},
+		{name: "same state later", update: retried, old: seen(Update{SupplierRef: "abc-1", State: "Implement", UpdatedAt: "2020-06-30 09:00:00"}, "comment"),
+			jira: 204, calls: []string{"/rest/api/2/issue/abc-1/comment", "/rest/api/2/issue/abc-1/transitions"},
+			synced: []string{"comment", "transition"}, status: http.StatusOK},
+		{name: "escaped ref", update: Update{SupplierRef: "abc/1?x", Comment: "hi"},
+			jira: 201, calls: []string{"/rest/api/2/issue/abc%2F1%3Fx/comment"}, synced: []string{"comment"}, status: http.StatusOK},
+		{name: "unknown change", update: Update{SupplierRef: "abc-9", State: "Implement"},
+			dbErr: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "failed", nil), status: http.StatusNotFound, err: "unknown change abc-9"},
+		{name: "db down", update: Update{SupplierRef: "abc-1", State: "Implement"},
+			dbErr: errors.New("throttled"), status: http.StatusInternalServerError, err: "throttled"},
+		{name: "jira down", update: Update{SupplierRef: "abc-1", State: "Implement"},
+			jira: 503, calls: []string{"/rest/api/2/issue/abc-1/comment"}, status: http.StatusBadGateway, err: "Jira returned 503"},
+		{name: "jira down for transition", update: retried, old: seen(retried, "comment"),
+			jira: 502, calls: []string{"/rest/api/2/issue/abc-1/transitions"}, status: http.StatusBadGateway, err: "Jira returned 502"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			var calls []call
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if u, p, ok := r.BasicAuth(); !ok || u != "bot" || p != "tok" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				b, _ := ioutil.ReadAll(r.Body)
+				var body map[string]interface{}
+				json.Unmarshal(b, &body)
+				calls = append(calls, call{path: r.URL.EscapedPath(), body: body})
+				w.WriteHeader(tc.jira)
+			}))
+			defer srv.Close()
+
+			os.Setenv("TABLE_NAME", "foo")
+			os.Setenv("JIRA_API_URL", srv.URL+"/")
+			os.Setenv("JIRA_USER", "bot")
+			os.Setenv("JIRA_TOKEN", "tok")
+			os.Setenv("JSD_TRANSITIONS", `{"Implement":"31"}`)
+
+			jira, err := NewJira()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			md := &mockDynamoDB{err: tc.dbErr, old: tc.old}
+			db := &DB{DynamoDB: md}
+
+			status, err := process(context.Background(), db, jira, &tc.update)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if status != tc.status {
+				t.Errorf("expected status %v, got %v", tc.status, status)
+			}
+
+			if len(calls) != len(tc.calls) {
+				t.Fatalf("expected Jira calls %v, got %v", tc.calls, calls)
+			}
+			for i, c := range calls {
+				if c.path != tc.calls[i] {
+					t.Errorf("expected call to %v, got %v", tc.calls[i], c.path)
+				}
+			}
+			if n := len(calls); n > 0 && strings.HasSuffix(calls[n-1].path, "/transitions") {
+				tr, _ := calls[n-1].body["transition"].(map[string]interface{})
+				if tr["id"] != "31" {
+					t.Errorf("expected transition 31, got %v", calls[n-1].body)
+				}
+			}
+
+			var last []string
+			if len(md.synced) > 0 {
+				last = md.synced[len(md.synced)-1]
+			}
+			if !reflect.DeepEqual(last, tc.synced) {
+				t.Errorf("expected %v recorded as synced, got %v", tc.synced, last)
+			}
+		})
+	}
+}
+
+func TestComment(t *testing.T) {
+
+	u := Update{SupplierRef: "abc-1", IntIdent: "CHG001", State: "Implement", Approval: "approved", UpdatedBy: "cab", Comment: "go ahead"}
+	expect := "SNOW change CHG001 updated. State: Implement. Approval: approved. By: cab. \ngo ahead"
+	if got := comment(&u); got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+}
@@ -0,0 +1,154 @@
+package callback
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// DB wraps DynamodDB with iface pkg for easier testing
+type DB struct {
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+func newDB() (*DB, error) {
+
+	var db = new(DB)
+	reg, ok := os.LookupEnv("REGION")
+	if !ok {
+		return nil, errors.New("missing AWS region")
+	}
+
+	awsConfig := aws.Config{
+		Region: aws.String(reg),
+	}
+
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+		Config:            awsConfig,
+	}))
+
+	svc := dynamodb.New(sess, aws.NewConfig())
+	db.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	return db, nil
+}
+
+// Steps of syncing an update to JSD, recorded so a retried update skips
+// the ones already done
+const (
+	StepComment    = "comment"
+	StepTransition = "transition"
+)
+
+// RecordUpdate stores SNOW's view of a change against its record. Only
+// changes the listener already knows about are updated. The record as it
+// was is returned, to tell whether this update was seen before.
+func (d *DB) RecordUpdate(ctx context.Context, u *Update) (*dynamodb.UpdateItemOutput, error) {
+
+	tab, ok := os.LookupEnv("TABLE_NAME")
+	if !ok {
+		return nil, errors.New("missing table name")
+	}
+
+	if u.SupplierRef == "" {
+		return nil, errors.New("missing supplierRef")
+	}
+
+	expr := "SET snowUpdatedAt = :upd"
+	values := map[string]*dynamodb.AttributeValue{
+		":upd": {
+			S: aws.String(time.Now().UTC().Format(time.RFC3339)),
+		},
+	}
+	if u.State != "" {
+		expr += ", snowState = :sst"
+		values[":sst"] = &dynamodb.AttributeValue{S: aws.String(u.State)}
+	}
+	if u.Approval != "" {
+		expr += ", approval = :apr"
+		values[":apr"] = &dynamodb.AttributeValue{S: aws.String(u.Approval)}
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tab),
+		UpdateExpression:          aws.String(expr),
+		ConditionExpression:       aws.String("attribute_exists(supplierRef)"),
+		ExpressionAttributeValues: values,
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllOld),
+		Key: map[string]*dynamodb.AttributeValue{
+			"supplierRef": {
+				S: aws.String(u.SupplierRef),
+			},
+		},
+	}
+
+	out, err := d.DynamoDB.UpdateItemWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("recorded SNOW update for %v on table %v", u.SupplierRef, tab)
+	return out, nil
+}
+
+// RecordSynced stores the steps done syncing an update to JSD
+func (d *DB) RecordSynced(ctx context.Context, u *Update, steps []string) error {
+
+	tab, ok := os.LookupEnv("TABLE_NAME")
+	if !ok {
+		return errors.New("missing table name")
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName:           aws.String(tab),
+		UpdateExpression:    aws.String("SET jiraSyncKey = :key, jiraSynced = :steps"),
+		ConditionExpression: aws.String("attribute_exists(supplierRef)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key":   {S: aws.String(u.Key())},
+			":steps": {SS: aws.StringSlice(steps)},
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"supplierRef": {
+				S: aws.String(u.SupplierRef),
+			},
+		},
+	}
+
+	_, err := d.DynamoDB.UpdateItemWithContext(ctx, input)
+	return err
+}
+
+// synced returns the steps already done for this update, from the record
+// as it was before the update was stored
+func synced(old map[string]*dynamodb.AttributeValue, u *Update) []string {
+
+	k, ok := old["jiraSyncKey"]
+	if !ok || k.S == nil || *k.S != u.Key() {
+		return nil
+	}
+	if s, ok := old["jiraSynced"]; ok {
+		return aws.StringValueSlice(s.SS)
+	}
+	return nil
+}
+
+// Key identifies an update by everything SNOW sent, including when SNOW
+// made it, so a retry has the same key and a later identical update doesn't
+func (u *Update) Key() string {
+
+	h := sha256.New()
+	for _, v := range []string{u.SupplierRef, u.IntIdent, u.State, u.Approval, u.Comment, u.UpdatedBy, u.UpdatedAt} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
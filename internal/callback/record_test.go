@@ -0,0 +1,94 @@
+package callback
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+type mockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	err    error
+	old    map[string]*dynamodb.AttributeValue
+	input  *dynamodb.UpdateItemInput
+	synced [][]string
+}
+
+func (md *mockDynamoDB) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	if s, ok := input.ExpressionAttributeValues[":steps"]; ok {
+		md.synced = append(md.synced, aws.StringValueSlice(s.SS))
+		return new(dynamodb.UpdateItemOutput), nil
+	}
+	md.input = input
+	output := &dynamodb.UpdateItemOutput{Attributes: md.old}
+	return output, md.err
+}
+
+func TestNewDB(t *testing.T) {
+
+	os.Unsetenv("REGION")
+	_, err := newDB()
+	if err == nil || !strings.Contains(err.Error(), "missing AWS region") {
+		t.Errorf("expected missing region error, got: %v", err)
+	}
+
+	os.Setenv("REGION", "eu")
+	_, err = newDB()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRecordUpdate(t *testing.T) {
+
+	tt := []struct {
+		name   string
+		table  string
+		update Update
+		expr   string
+		err    string
+	}{
+		{name: "state", table: "foo", update: Update{SupplierRef: "abc-1", State: "Implement"},
+			expr: "SET snowUpdatedAt = :upd, snowState = :sst"},
+		{name: "both", table: "foo", update: Update{SupplierRef: "abc-1", State: "Assess", Approval: "approved"},
+			expr: "SET snowUpdatedAt = :upd, snowState = :sst, approval = :apr"},
+		{name: "no table", update: Update{SupplierRef: "abc-1"}, err: "missing table name"},
+		{name: "no ref", table: "foo", err: "missing supplierRef"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			os.Unsetenv("TABLE_NAME")
+			if tc.table != "" {
+				os.Setenv("TABLE_NAME", tc.table)
+			}
+
+			md := &mockDynamoDB{}
+			db := &DB{DynamoDB: md}
+
+			_, err := db.RecordUpdate(context.Background(), &tc.update)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *md.input.UpdateExpression != tc.expr {
+				t.Errorf("expected %q, got %q", tc.expr, *md.input.UpdateExpression)
+			}
+			if *md.input.ConditionExpression != "attribute_exists(supplierRef)" {
+				t.Errorf("expected update to require an existing change")
+			}
+		})
+	}
+}
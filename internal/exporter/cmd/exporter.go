@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/exporter"
+)
+
+func main() {
+
+	table := flag.String("table", os.Getenv("TABLE_NAME"), "change table, defaults to TABLE_NAME")
+	format := flag.String("format", exporter.FormatCSV, "output format, csv or jsonl")
+	out := flag.String("o", "", "output file, defaults to stdout")
+	status := flag.String("status", "", "comma separated statuses to include")
+	project := flag.String("project", "", "comma separated JSD project keys to include")
+	from := flag.String("from", "", "include changes starting on or after this date, YYYY-MM-DD")
+	to := flag.String("to", "", "include changes starting before this date, YYYY-MM-DD")
+	flag.Parse()
+
+	err := exporter.CheckFormat(*format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var f exporter.Filter
+	if *status != "" {
+		f.Statuses = strings.Split(*status, ",")
+	}
+	if *project != "" {
+		f.Projects = strings.Split(*project, ",")
+	}
+
+	f.From, err = date(*from)
+	if err != nil {
+		log.Fatalf("invalid -from: %v", err)
+	}
+	f.To, err = date(*to)
+	if err != nil {
+		log.Fatalf("invalid -to: %v", err)
+	}
+
+	db, err := exporter.NewDB()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	changes, err := db.Changes(*table, &f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// a file is only replaced once the whole export is written
+	if *out != "" {
+		err = exporter.WriteFile(*out, changes, *format)
+	} else {
+		err = exporter.Write(os.Stdout, changes, *format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("exported %v changes", len(changes))
+}
+
+// date parses a day in London time, or returns the zero time
+func date(v string) (time.Time, error) {
+
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	loc, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.ParseInLocation("2006-01-02", v, loc)
+}
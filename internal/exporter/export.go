@@ -0,0 +1,173 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/jsd"
+	"github.com/UKHomeOffice/snow-forwarder/internal/snowtime"
+)
+
+// Export formats
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// Change is an exported change record with its delivery state
+type Change struct {
+	SupplierRef      string `dynamodbav:"supplierRef" json:"supplierRef"`
+	Status           string `dynamodbav:"status" json:"status"`
+	Title            string `dynamodbav:"title" json:"title"`
+	StartTime        string `dynamodbav:"startTime" json:"startTime"`
+	EndTime          string `dynamodbav:"endTime" json:"endTime"`
+	IntIdent         string `dynamodbav:"internal_identifier" json:"internal_identifier"`
+	LastStatusSent   string `dynamodbav:"lastStatusSent" json:"lastStatusSent"`
+	LastMessageType  string `dynamodbav:"lastMessageType" json:"lastMessageType"`
+	DeliveryAttempts int    `dynamodbav:"deliveryAttempts" json:"deliveryAttempts"`
+	FailedAttempts   int    `dynamodbav:"failedAttempts" json:"failedAttempts"`
+	LastError        string `dynamodbav:"lastError" json:"lastError,omitempty"`
+	LastAttemptAt    string `dynamodbav:"lastAttemptAt" json:"lastAttemptAt"`
+	LastSuccessAt    string `dynamodbav:"lastSuccessAt" json:"lastSuccessAt"`
+	SnowResponse     string `dynamodbav:"snowResponse" json:"snowResponse,omitempty"`
+}
+
+// columns are the CSV header, in the order written
+var columns = []string{"supplierRef", "status", "title", "startTime", "endTime", "internal_identifier",
+	"lastStatusSent", "lastMessageType", "deliveryAttempts", "failedAttempts", "lastError", "lastAttemptAt", "lastSuccessAt",
+	"snowResponse"}
+
+// row returns a change's CSV fields
+func (c *Change) row() []string {
+	return []string{c.SupplierRef, c.Status, c.Title, c.StartTime, c.EndTime, c.IntIdent,
+		c.LastStatusSent, c.LastMessageType, strconv.Itoa(c.DeliveryAttempts), strconv.Itoa(c.FailedAttempts), c.LastError,
+		c.LastAttemptAt, c.LastSuccessAt, c.SnowResponse}
+}
+
+// Filter selects changes to export. Empty fields match everything. From
+// and To bound the start of the change window, To being exclusive.
+type Filter struct {
+	Statuses []string
+	Projects []string
+	From     time.Time
+	To       time.Time
+}
+
+// Match reports whether a change passes the filter
+func (f *Filter) Match(c *Change) bool {
+
+	if len(f.Statuses) > 0 && !jsd.ContainsFold(f.Statuses, c.Status) {
+		return false
+	}
+
+	if len(f.Projects) > 0 && !jsd.ContainsFold(f.Projects, jsd.Project(c.SupplierRef)) {
+		return false
+	}
+
+	if f.From.IsZero() && f.To.IsZero() {
+		return true
+	}
+
+	start, err := snowtime.Parse(c.StartTime)
+	if err != nil {
+		return false
+	}
+
+	if !f.From.IsZero() && start.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !start.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// CheckFormat fails for anything but csv or jsonl, so a bad format is
+// caught before the table is scanned
+func CheckFormat(format string) error {
+
+	switch format {
+	case FormatCSV, FormatJSONL:
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q, expected csv or jsonl", format)
+	}
+}
+
+// WriteFile writes changes to path through a temporary file in the same
+// directory, renamed into place once written, so a failed export doesn't
+// leave a partial file behind
+func WriteFile(path string, changes []Change, format string) error {
+
+	fh, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fh.Name())
+
+	err = Write(fh, changes, format)
+	if err != nil {
+		fh.Close()
+		return err
+	}
+
+	err = fh.Close()
+	if err != nil {
+		return err
+	}
+
+	// TempFile creates the file private to us, exports are shared as usual
+	err = os.Chmod(fh.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(fh.Name(), path)
+}
+
+// Write writes changes as CSV or JSON Lines, ordered by window start then
+// supplier ref
+func Write(w io.Writer, changes []Change, format string) error {
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].StartTime != changes[j].StartTime {
+			return changes[i].StartTime < changes[j].StartTime
+		}
+		return changes[i].SupplierRef < changes[j].SupplierRef
+	})
+
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		err := cw.Write(columns)
+		if err != nil {
+			return err
+		}
+		for i := range changes {
+			err = cw.Write(changes[i].row())
+			if err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case FormatJSONL:
+		enc := json.NewEncoder(w)
+		for i := range changes {
+			err := enc.Encode(&changes[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return CheckFormat(format)
+	}
+}
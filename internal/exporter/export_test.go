@@ -0,0 +1,151 @@
+package exporter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+
+	loc, _ := time.LoadLocation("Europe/London")
+	july := time.Date(2020, 7, 1, 0, 0, 0, 0, loc)
+	august := time.Date(2020, 8, 1, 0, 0, 0, 0, loc)
+
+	c := Change{SupplierRef: "ACP-12", Status: "Completed", StartTime: "2020-07-31 23:30:00"}
+
+	tt := []struct {
+		name   string
+		filter Filter
+		expect bool
+	}{
+		{name: "everything", expect: true},
+		{name: "status", filter: Filter{Statuses: []string{"scheduled", "completed"}}, expect: true},
+		{name: "other status", filter: Filter{Statuses: []string{"Scheduled"}}},
+		{name: "project", filter: Filter{Projects: []string{"acp"}}, expect: true},
+		{name: "other project", filter: Filter{Projects: []string{"OPS"}}},
+		{name: "in month", filter: Filter{From: july, To: august}, expect: true},
+		{name: "before range", filter: Filter{From: august}},
+		{name: "after range", filter: Filter{To: july}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.filter.Match(&c); got != tc.expect {
+				t.Errorf("expected %v, got %v", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+
+	changes := []Change{
+		{SupplierRef: "ACP-2", Status: "Scheduled", Title: "Patch, then reboot", StartTime: "2020-07-02 10:00:00"},
+		{SupplierRef: "ACP-1", Status: "Completed", StartTime: "2020-07-01 10:00:00", IntIdent: "CHG0000001",
+			LastMessageType: "HO_SIAM_IN_REST_CHG_UPDATE_JSON", DeliveryAttempts: 2, FailedAttempts: 1, LastError: "SNOW returned HTTP 503",
+			SnowResponse: `{"status":"error"}`},
+	}
+
+	tt := []struct {
+		name   string
+		format string
+		expect string
+		err    string
+	}{
+		{name: "csv", format: FormatCSV, expect: "supplierRef,status,title,startTime,endTime,internal_identifier," +
+			"lastStatusSent,lastMessageType,deliveryAttempts,failedAttempts,lastError,lastAttemptAt,lastSuccessAt,snowResponse\n" +
+			"ACP-1,Completed,,2020-07-01 10:00:00,,CHG0000001,,HO_SIAM_IN_REST_CHG_UPDATE_JSON,2,1,SNOW returned HTTP 503,,,\"{\"\"status\"\":\"\"error\"\"}\"\n" +
+			"ACP-2,Scheduled,\"Patch, then reboot\",2020-07-02 10:00:00,,,,,0,0,,,,\n"},
+		{name: "jsonl", format: FormatJSONL, expect: `{"supplierRef":"ACP-1","status":"Completed","title":"",` +
+			`"startTime":"2020-07-01 10:00:00","endTime":"","internal_identifier":"CHG0000001","lastStatusSent":"",` +
+			`"lastMessageType":"HO_SIAM_IN_REST_CHG_UPDATE_JSON","deliveryAttempts":2,"failedAttempts":1,` +
+			`"lastError":"SNOW returned HTTP 503","lastAttemptAt":"","lastSuccessAt":"","snowResponse":"{\"status\":\"error\"}"}` + "\n" +
+			`{"supplierRef":"ACP-2","status":"Scheduled","title":"Patch, then reboot","startTime":"2020-07-02 10:00:00",` +
+			`"endTime":"","internal_identifier":"","lastStatusSent":"","lastMessageType":"","deliveryAttempts":0,"failedAttempts":0,` +
+			`"lastAttemptAt":"","lastSuccessAt":""}` + "\n"},
+		{name: "unknown", format: "xlsx", err: "unknown format"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			var buf bytes.Buffer
+			err := Write(&buf, changes, tc.format)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if buf.String() != tc.expect {
+				t.Errorf("expected:\n%v\ngot:\n%v", tc.expect, buf.String())
+			}
+		})
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatalf("could not make temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "changes.csv")
+	ioutil.WriteFile(path, []byte("last month\n"), 0644)
+	changes := []Change{{SupplierRef: "ACP-1", Status: "Scheduled"}}
+
+	// a failed export leaves the old file alone and nothing else behind
+	err = WriteFile(path, changes, "xlsx")
+	if err == nil || !strings.Contains(err.Error(), "unknown format") {
+		t.Errorf("expected error for unknown format, got: %v", err)
+	}
+	b, _ := ioutil.ReadFile(path)
+	if string(b) != "last month\n" {
+		t.Errorf("expected the old export to be kept, got %q", b)
+	}
+
+	err = WriteFile(path, changes, FormatCSV)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, _ = ioutil.ReadFile(path)
+	if !strings.HasPrefix(string(b), "supplierRef,") || !strings.Contains(string(b), "ACP-1,Scheduled") {
+		t.Errorf("expected the new export, got %q", b)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi.Mode().Perm() != 0644 {
+		t.Errorf("expected the export to be readable by others, got %v", fi.Mode())
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("expected only the export in %v, got %v files", dir, len(files))
+	}
+}
+
+func TestCheckFormat(t *testing.T) {
+
+	for _, f := range []string{FormatCSV, FormatJSONL} {
+		if err := CheckFormat(f); err != nil {
+			t.Errorf("unexpected error for %v: %v", f, err)
+		}
+	}
+	if err := CheckFormat("CSV"); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
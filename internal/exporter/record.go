@@ -0,0 +1,75 @@
+package exporter
+
+import (
+	"errors"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// DB wraps DynamodDB with iface pkg for easier testing
+type DB struct {
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+// NewDB connects to DynamoDB in REGION
+func NewDB() (*DB, error) {
+
+	var db = new(DB)
+	reg, ok := os.LookupEnv("REGION")
+	if !ok {
+		return nil, errors.New("missing AWS region")
+	}
+
+	awsConfig := aws.Config{
+		Region: aws.String(reg),
+	}
+
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+		Config:            awsConfig,
+	}))
+
+	svc := dynamodb.New(sess, aws.NewConfig())
+	db.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	return db, nil
+}
+
+// Changes scans the table for changes that pass the filter
+func (d *DB) Changes(table string, f *Filter) ([]Change, error) {
+
+	if table == "" {
+		return nil, errors.New("missing table name")
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(table),
+	}
+
+	var changes []Change
+	var uerr error
+	err := d.DynamoDB.ScanPages(input, func(page *dynamodb.ScanOutput, last bool) bool {
+		var batch []Change
+		uerr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &batch)
+		if uerr != nil {
+			return false
+		}
+		for _, c := range batch {
+			if f.Match(&c) {
+				changes = append(changes, c)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if uerr != nil {
+		return nil, errors.New("could not read changes: " + uerr.Error())
+	}
+	return changes, nil
+}
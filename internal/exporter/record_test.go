@@ -0,0 +1,54 @@
+package exporter
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+type mockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	pages [][]map[string]*dynamodb.AttributeValue
+}
+
+func (md *mockDynamoDB) ScanPages(input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool) error {
+
+	for i, p := range md.pages {
+		if !fn(&dynamodb.ScanOutput{Items: p}, i == len(md.pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestChanges(t *testing.T) {
+
+	item := func(ref, status, attempts string) map[string]*dynamodb.AttributeValue {
+		return map[string]*dynamodb.AttributeValue{
+			"supplierRef":      {S: aws.String(ref)},
+			"status":           {S: aws.String(status)},
+			"deliveryAttempts": {N: aws.String(attempts)},
+		}
+	}
+
+	db := DB{DynamoDB: &mockDynamoDB{pages: [][]map[string]*dynamodb.AttributeValue{
+		{item("ACP-1", "Completed", "3"), item("ACP-2", "Scheduled", "1")},
+		{item("OPS-1", "Completed", "1")},
+	}}}
+
+	if _, err := db.Changes("", &Filter{}); err == nil {
+		t.Errorf("expected error for missing table")
+	}
+
+	changes, err := db.Changes("changes", &Filter{Statuses: []string{"Completed"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(changes) != 2 || changes[0].SupplierRef != "ACP-1" || changes[0].DeliveryAttempts != 3 ||
+		changes[1].SupplierRef != "OPS-1" {
+		t.Errorf("unexpected changes: %+v", changes)
+	}
+}
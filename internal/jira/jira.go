@@ -0,0 +1,108 @@
+package jira
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/transport"
+)
+
+// Client calls the Jira REST API
+type Client struct {
+	URL   string
+	User  string
+	Token string
+	HTTP  *http.Client
+}
+
+// New builds a Jira client from JIRA_API_URL, JIRA_USER and JIRA_TOKEN
+func New() (*Client, error) {
+
+	u, ok := os.LookupEnv("JIRA_API_URL")
+	if !ok {
+		return nil, errors.New("missing environment variable JIRA_API_URL")
+	}
+
+	user, token := os.Getenv("JIRA_USER"), os.Getenv("JIRA_TOKEN")
+	if user == "" || token == "" {
+		return nil, errors.New("missing environment variables JIRA_USER or JIRA_TOKEN")
+	}
+
+	tr, err := transport.New()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		URL:   strings.TrimSuffix(u, "/"),
+		User:  user,
+		Token: token,
+		HTTP:  &http.Client{Timeout: 10 * time.Second, Transport: tr},
+	}, nil
+}
+
+// Comment adds a comment to an issue
+func (c *Client) Comment(ctx context.Context, key, body string) error {
+
+	err := c.post(ctx, "/rest/api/2/issue/"+url.PathEscape(key)+"/comment", map[string]string{"body": body})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("commented on JSD issue %v", key)
+	return nil
+}
+
+// Transition moves an issue through its workflow
+func (c *Client) Transition(ctx context.Context, key, id string) error {
+
+	body := map[string]interface{}{
+		"transition": map[string]string{"id": id},
+	}
+
+	err := c.post(ctx, "/rest/api/2/issue/"+url.PathEscape(key)+"/transitions", body)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("transitioned JSD issue %v with %v", key, id)
+	return nil
+}
+
+// post sends a JSON body to the Jira API
+func (c *Client) post(ctx context.Context, path string, body interface{}) error {
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.URL+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.User, c.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		reply, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("Jira returned %v: %v", res.StatusCode, string(reply))
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package jira
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+
+	tt := []struct {
+		name string
+		env  map[string]string
+		err  string
+	}{
+		{name: "good", env: map[string]string{"JIRA_API_URL": "https://jira/", "JIRA_USER": "bot", "JIRA_TOKEN": "tok"}},
+		{name: "no url", env: map[string]string{"JIRA_USER": "bot", "JIRA_TOKEN": "tok"}, err: "missing environment variable JIRA_API_URL"},
+		{name: "no token", env: map[string]string{"JIRA_API_URL": "https://jira"}, err: "JIRA_USER or JIRA_TOKEN"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			for k, v := range tc.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			c, err := New()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if c.URL != "https://jira" {
+				t.Errorf("expected the trailing slash trimmed, got %v", c.URL)
+			}
+		})
+	}
+}
+
+func TestComment(t *testing.T) {
+
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "bot" || p != "tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		paths = append(paths, r.URL.EscapedPath())
+		if strings.Contains(r.URL.Path, "ACP-9") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	c := &Client{URL: srv.URL, User: "bot", Token: "tok", HTTP: srv.Client()}
+
+	if err := c.Comment(context.Background(), "ACP/1", "hi"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := c.Comment(context.Background(), "ACP-9", "hi"); err == nil || !strings.Contains(err.Error(), "Jira returned 404") {
+		t.Errorf("expected a 404, got: %v", err)
+	}
+	if len(paths) != 2 || paths[0] != "/rest/api/2/issue/ACP%2F1/comment" {
+		t.Errorf("expected an escaped issue key, got %v", paths)
+	}
+}
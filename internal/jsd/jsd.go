@@ -0,0 +1,24 @@
+package jsd
+
+import "strings"
+
+// Project returns the JSD project key of an issue key, e.g. ACP for ACP-12
+func Project(ref string) string {
+
+	i := strings.LastIndex(ref, "-")
+	if i < 0 {
+		return strings.ToUpper(ref)
+	}
+	return strings.ToUpper(ref[:i])
+}
+
+// ContainsFold reports whether s is in list, ignoring case
+func ContainsFold(list []string, s string) bool {
+
+	for _, v := range list {
+		if strings.EqualFold(strings.TrimSpace(v), s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package jsd
+
+import (
+	"testing"
+)
+
+func TestProject(t *testing.T) {
+
+	tt := []struct {
+		ref    string
+		expect string
+	}{
+		{ref: "ACP-12", expect: "ACP"},
+		{ref: "acp-12", expect: "ACP"},
+		{ref: "MY-PROJ-3", expect: "MY-PROJ"},
+		{ref: "ACP", expect: "ACP"},
+	}
+
+	for _, tc := range tt {
+		if got := Project(tc.ref); got != tc.expect {
+			t.Errorf("expected %v for %v, got %v", tc.expect, tc.ref, got)
+		}
+	}
+}
+
+func TestContainsFold(t *testing.T) {
+
+	tt := []struct {
+		name   string
+		list   []string
+		s      string
+		expect bool
+	}{
+		{name: "same case", list: []string{"ACP", "SRE"}, s: "SRE", expect: true},
+		{name: "other case", list: []string{"acp"}, s: "ACP", expect: true},
+		{name: "padded", list: []string{" ACP "}, s: "ACP", expect: true},
+		{name: "missing", list: []string{"ACP"}, s: "SRE"},
+		{name: "empty"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ContainsFold(tc.list, tc.s); got != tc.expect {
+				t.Errorf("expected %v, got %v", tc.expect, got)
+			}
+		})
+	}
+}
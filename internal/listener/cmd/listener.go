@@ -4,10 +4,15 @@ import (
 	"log"
 
 	"github.com/UKHomeOffice/snow-forwarder/internal/listener"
+	"github.com/UKHomeOffice/snow-forwarder/internal/redact"
 	"github.com/apex/gateway"
 )
 
 func main() {
 
+	if err := redact.Setup(); err != nil {
+		log.Fatal(err)
+	}
+
 	log.Fatal(gateway.ListenAndServe("", listener.Handler()))
 }
@@ -2,6 +2,8 @@ package listener
 
 import (
 	"net/http"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/redact"
 )
 
 var r Record
@@ -16,14 +18,15 @@ func NewWriter(handlerToWrap http.Handler) *Writer {
 	return &Writer{handlerToWrap}
 }
 
-// serveHTTP passes the request from main handler to middleware
+// serveHTTP passes the request from main handler to middleware. The
+// request's context carries the Lambda deadline.
 func (wr *Writer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 
 	wr.handler.ServeHTTP(rw, req)
 
-	err := recorder(&r)
+	err := recorder(req.Context(), &r)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		http.Error(rw, redact.String(err.Error()), http.StatusInternalServerError)
 	}
 }
 
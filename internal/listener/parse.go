@@ -6,8 +6,11 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"text/template"
 	"time"
 
+	"github.com/UKHomeOffice/snow-forwarder/internal/redact"
+	"github.com/UKHomeOffice/snow-forwarder/internal/snowtime"
 	"github.com/tidwall/gjson"
 )
 
@@ -29,6 +32,44 @@ func checkVars(input string) error {
 	return nil
 }
 
+// defaultDescription links back to JSD ahead of the issue description
+const defaultDescription = "\nFor the most up-to-date info, visit {{.JSDURL}}/{{.SupplierRef}}\n{{.Description}}"
+
+// describe renders the description sent to SNOW from DESCRIPTION_TEMPLATE
+func (r *Record) describe() (string, error) {
+
+	text, ok := os.LookupEnv("DESCRIPTION_TEMPLATE")
+	if !ok {
+		text = defaultDescription
+	}
+
+	t, err := template.New("description").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	data := struct {
+		JSDURL      string
+		SupplierRef string
+		Status      string
+		Title       string
+		Description string
+	}{
+		JSDURL:      os.Getenv("JSD_URL"),
+		SupplierRef: r.SupplierRef,
+		Status:      r.Status,
+		Title:       r.Title,
+		Description: r.Description,
+	}
+
+	var buf bytes.Buffer
+	err = t.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
 // ParseRequest gets some values from inbound paylpad
 func (r *Record) ParseRequest(input string) error {
 
@@ -47,9 +88,38 @@ func (r *Record) ParseRequest(input string) error {
 	r.Status = gjson.Get(input, os.Getenv("STATUS_FIELD")).Str
 	r.Title = gjson.Get(input, os.Getenv("SUMMARY_FIELD")).Str
 
+	// optional, they pick the SNOW change model
+	r.IssueType, r.RequestType, r.Labels = "", "", nil
+	r.Components, r.Assets = nil, nil
+	if f, ok := os.LookupEnv("ISSUE_TYPE_FIELD"); ok {
+		r.IssueType = gjson.Get(input, f).Str
+	}
+	if f, ok := os.LookupEnv("REQUEST_TYPE_FIELD"); ok {
+		r.RequestType = gjson.Get(input, f).Str
+	}
+	if f, ok := os.LookupEnv("LABELS_FIELD"); ok {
+		for _, l := range gjson.Get(input, f).Array() {
+			r.Labels = append(r.Labels, l.String())
+		}
+	}
+
+	// optional, they pick the SNOW configuration items
+	if f, ok := os.LookupEnv("COMPONENTS_FIELD"); ok {
+		for _, c := range gjson.Get(input, f).Array() {
+			r.Components = append(r.Components, c.String())
+		}
+	}
+	if f, ok := os.LookupEnv("ASSETS_FIELD"); ok {
+		for _, a := range gjson.Get(input, f).Array() {
+			r.Assets = append(r.Assets, a.String())
+		}
+	}
+
 	// prefix description with link
-	desc := "\nFor the most up-to-date info, visit " +
-		os.Getenv("JSD_URL") + "/" + r.SupplierRef + "\n" + r.Description
+	desc, err := r.describe()
+	if err != nil {
+		return err
+	}
 	r.Description = desc
 
 	log.Printf("processing JSD event: %v, status: %v\n", r.SupplierRef, r.Status)
@@ -67,10 +137,7 @@ func (r *Record) ParseTime(input string) error {
 	r.Ends = gjson.Get(input, os.Getenv("FINISH_TIME_FIELD")).Str
 	r.Starts = gjson.Get(input, os.Getenv("START_TIME_FIELD")).Str
 
-	const (
-		layout     = "2006-01-02T15:04:05.000+0000"
-		layoutSNOW = "2006-01-02 15:04:05"
-	)
+	const layout = "2006-01-02T15:04:05.000+0000"
 
 	loc, err := time.LoadLocation("Europe/London")
 	if err != nil {
@@ -87,8 +154,8 @@ func (r *Record) ParseTime(input string) error {
 		return err
 	}
 
-	r.Starts = stt.In(loc).Format(layoutSNOW)
-	r.Ends = ett.In(loc).Format(layoutSNOW)
+	r.Starts = stt.In(loc).Format(snowtime.Layout)
+	r.Ends = ett.In(loc).Format(snowtime.Layout)
 
 	return nil
 }
@@ -100,18 +167,18 @@ func (r *Record) ParseHandler(w http.ResponseWriter, req *http.Request) {
 	buf := new(bytes.Buffer)
 	_, err := buf.ReadFrom(req.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, redact.String(err.Error()), http.StatusBadRequest)
 	}
 	input := buf.String()
 
 	err = r.ParseRequest(input)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, redact.String(err.Error()), http.StatusBadRequest)
 	}
 
 	err = r.ParseTime(input)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, redact.String(err.Error()), http.StatusBadRequest)
 	}
 
 	w.WriteHeader(http.StatusOK)
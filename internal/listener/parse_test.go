@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -24,9 +25,14 @@ func setEnv() {
 	os.Setenv("DESCRIPTION_FIELD", "issue.fields.description")
 	os.Setenv("START_TIME_FIELD", "issue.fields.customfield_10109")
 	os.Setenv("FINISH_TIME_FIELD", "issue.fields.customfield_10110")
+	os.Setenv("ISSUE_TYPE_FIELD", "issue.fields.issuetype.name")
+	os.Setenv("REQUEST_TYPE_FIELD", "issue.fields.customfield_10010.requestType.name")
+	os.Setenv("LABELS_FIELD", "issue.fields.labels")
+	os.Setenv("COMPONENTS_FIELD", "issue.fields.components.#.name")
+	os.Setenv("ASSETS_FIELD", "issue.fields.customfield_10200.#.objectId")
 }
 
-//  getMsg gets some test input
+// getMsg gets some test input
 func getMsg(p int) (string, error) {
 
 	body, err := ioutil.ReadFile("payloads.json")
@@ -51,10 +57,17 @@ func TestParseHandler(t *testing.T) {
 		description string
 		starts      string
 		ends        string
+		issueType   string
+		requestType string
+		labels      []string
+		components  []string
+		assets      []string
 		err         string
 	}{
 		{name: "good", input: 0, supplierRef: "abc-1", status: "scheduled", title: "foo change",
-			description: "\nFor the most up-to-date info, visit /abc-1\nlorem impsum", starts: "2020-09-01 18:30:00", ends: "2020-09-01 19:30:00"},
+			description: "\nFor the most up-to-date info, visit /abc-1\nlorem impsum", starts: "2020-09-01 18:30:00", ends: "2020-09-01 19:30:00",
+			issueType: "Emergency Change", requestType: "Hotfix", labels: []string{"prod", "security"},
+			components: []string{"Platform", "Logging"}, assets: []string{"1234"}},
 		{name: "missing", input: 1, err: "missing value in payload"},
 		{name: "time", input: 2, err: "cannot parse"},
 	}
@@ -121,6 +134,13 @@ func TestParseHandler(t *testing.T) {
 				if rec.Ends != tc.ends {
 					t.Errorf("expected %v, got %v", tc.ends, rec.Ends)
 				}
+				if rec.IssueType != tc.issueType || rec.RequestType != tc.requestType || !reflect.DeepEqual(rec.Labels, tc.labels) {
+					t.Errorf("expected %v/%v/%v, got %v/%v/%v", tc.issueType, tc.requestType, tc.labels,
+						rec.IssueType, rec.RequestType, rec.Labels)
+				}
+				if !reflect.DeepEqual(rec.Components, tc.components) || !reflect.DeepEqual(rec.Assets, tc.assets) {
+					t.Errorf("expected %v and %v, got %v and %v", tc.components, tc.assets, rec.Components, rec.Assets)
+				}
 			}
 
 			if msg := string(bytes.TrimSpace(b)); !strings.Contains(msg, tc.err) {
@@ -130,3 +150,45 @@ func TestParseHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestDescribe(t *testing.T) {
+
+	tt := []struct {
+		name     string
+		template string
+		expect   string
+		err      string
+	}{
+		{name: "default", expect: "\nFor the most up-to-date info, visit https://jsd/abc-1\nlorem ipsum"},
+		{name: "custom", template: "[{{.Status}}] {{.Title}}: {{.Description}} ({{.JSDURL}}/{{.SupplierRef}})",
+			expect: "[scheduled] foo change: lorem ipsum (https://jsd/abc-1)"},
+		{name: "bad", template: "{{.Description", err: "unclosed action"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			os.Setenv("JSD_URL", "https://jsd")
+			defer os.Unsetenv("JSD_URL")
+			if tc.template != "" {
+				os.Setenv("DESCRIPTION_TEMPLATE", tc.template)
+				defer os.Unsetenv("DESCRIPTION_TEMPLATE")
+			}
+
+			rec := Record{SupplierRef: "abc-1", Status: "scheduled", Title: "foo change", Description: "lorem ipsum"}
+			desc, err := rec.describe()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if desc != tc.expect {
+				t.Errorf("expected %q, got %q", tc.expect, desc)
+			}
+		})
+	}
+}
@@ -1,9 +1,11 @@
 package listener
 
 import (
+	"context"
 	"errors"
 	"log"
 	"os"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -15,12 +17,18 @@ import (
 
 // Record represents a change event
 type Record struct {
-	SupplierRef string `json:"supplierRef"`
-	Status      string `json:"status"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Starts      string `json:"startTime"`
-	Ends        string `json:"endTime"`
+	SupplierRef string   `json:"supplierRef"`
+	Status      string   `json:"status"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Starts      string   `json:"startTime"`
+	Ends        string   `json:"endTime"`
+	IssueType   string   `json:"issueType,omitempty"`
+	RequestType string   `json:"requestType,omitempty"`
+	Labels      []string `json:"labels,omitempty"`
+	Components  []string `json:"components,omitempty"`
+	Assets      []string `json:"assets,omitempty"`
+	CreatedAt   string   `json:"createdAt,omitempty"`
 	Table       string
 }
 
@@ -52,7 +60,10 @@ func newDB() (*DB, error) {
 }
 
 // PutRec puts an item in DynamoDB
-func (d *DB) PutRec(r *Record) (*dynamodb.PutItemOutput, error) {
+func (d *DB) PutRec(ctx context.Context, r *Record) (*dynamodb.PutItemOutput, error) {
+
+	// lets the sweeper tell how long a change has waited for SNOW
+	r.CreatedAt = time.Now().UTC().Format(time.RFC3339)
 
 	av, err := dynamodbattribute.MarshalMap(r)
 	if err != nil {
@@ -69,7 +80,7 @@ func (d *DB) PutRec(r *Record) (*dynamodb.PutItemOutput, error) {
 		ConditionExpression: aws.String("attribute_not_exists(supplierRef)"),
 	}
 
-	out, err := d.DynamoDB.PutItem(input)
+	out, err := d.DynamoDB.PutItemWithContext(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -79,22 +90,67 @@ func (d *DB) PutRec(r *Record) (*dynamodb.PutItemOutput, error) {
 }
 
 // UpdateRec updates an item in DynamoDB
-func (d *DB) UpdateRec(r *Record) (*dynamodb.UpdateItemOutput, error) {
+func (d *DB) UpdateRec(ctx context.Context, r *Record) (*dynamodb.UpdateItemOutput, error) {
 
 	if r.SupplierRef == "" {
 		return nil, errors.New("missing supplierRef")
 	}
 
+	labels, err := dynamodbattribute.Marshal(r.Labels)
+	if err != nil {
+		return nil, err
+	}
+	components, err := dynamodbattribute.Marshal(r.Components)
+	if err != nil {
+		return nil, err
+	}
+	assets, err := dynamodbattribute.Marshal(r.Assets)
+	if err != nil {
+		return nil, err
+	}
+
+	// update everything the notifier may forward, so reschedules and
+	// renames are picked up as well as status changes
 	input := &dynamodb.UpdateItemInput{
 		TableName:        aws.String(r.Table),
-		UpdateExpression: aws.String("SET #S = :cst"),
+		UpdateExpression: aws.String("SET #S = :cst, #T = :ttl, #D = :dsc, #ST = :stt, #ET = :ett, #IT = :ity, #RT = :rty, #L = :lbl, #C = :cmp, #A = :ast"),
 		ExpressionAttributeNames: map[string]*string{
-			"#S": aws.String("status"),
+			"#S":  aws.String("status"),
+			"#T":  aws.String("title"),
+			"#D":  aws.String("description"),
+			"#ST": aws.String("startTime"),
+			"#ET": aws.String("endTime"),
+			"#IT": aws.String("issueType"),
+			"#RT": aws.String("requestType"),
+			"#L":  aws.String("labels"),
+			"#C":  aws.String("components"),
+			"#A":  aws.String("assets"),
 		},
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":cst": {
 				S: aws.String(r.Status),
 			},
+			":ttl": {
+				S: aws.String(r.Title),
+			},
+			":dsc": {
+				S: aws.String(r.Description),
+			},
+			":stt": {
+				S: aws.String(r.Starts),
+			},
+			":ett": {
+				S: aws.String(r.Ends),
+			},
+			":ity": {
+				S: aws.String(r.IssueType),
+			},
+			":rty": {
+				S: aws.String(r.RequestType),
+			},
+			":lbl": labels,
+			":cmp": components,
+			":ast": assets,
 		},
 		Key: map[string]*dynamodb.AttributeValue{
 			"supplierRef": {
@@ -102,7 +158,7 @@ func (d *DB) UpdateRec(r *Record) (*dynamodb.UpdateItemOutput, error) {
 			},
 		},
 	}
-	out, err := d.DynamoDB.UpdateItem(input)
+	out, err := d.DynamoDB.UpdateItemWithContext(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -111,20 +167,20 @@ func (d *DB) UpdateRec(r *Record) (*dynamodb.UpdateItemOutput, error) {
 	return out, nil
 }
 
-// recorder handles DynamoDB ops
-func recorder(r *Record) error {
+// recorder handles DynamoDB ops, giving up when ctx is done
+func recorder(ctx context.Context, r *Record) error {
 
 	db, err := newDB()
 	if err != nil {
 		return err
 	}
-	_, err = db.PutRec(r)
+	_, err = db.PutRec(ctx, r)
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
 			case dynamodb.ErrCodeConditionalCheckFailedException:
 				log.Println("item exists, will try to update instead")
-				_, err = db.UpdateRec(r)
+				_, err = db.UpdateRec(ctx, r)
 				if err != nil {
 					return err
 				}
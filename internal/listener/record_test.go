@@ -1,10 +1,13 @@
 package listener
 
 import (
+	"context"
 	"os"
 	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
@@ -14,12 +17,12 @@ type mockDynamoDB struct {
 	err error
 }
 
-func (md *mockDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+func (md *mockDynamoDB) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
 	output := new(dynamodb.PutItemOutput)
 	return output, md.err
 }
 
-func (md *mockDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+func (md *mockDynamoDB) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
 	output := new(dynamodb.UpdateItemOutput)
 	return output, md.err
 }
@@ -86,13 +89,13 @@ func TestPutRec(t *testing.T) {
 					Ends:        tc.ends,
 					Table:       tc.table,
 				}
-				_, err := putter.PutRec(&rec)
+				_, err := putter.PutRec(context.Background(), &rec)
 				if err != nil {
 					t.Errorf("unexpected error: %v", err)
 				}
 			}
 			rec := Record{}
-			_, err := putter.PutRec(&rec)
+			_, err := putter.PutRec(context.Background(), &rec)
 			if msg := err.Error(); !strings.Contains(msg, tc.err) {
 				t.Errorf("expected error %q, got: %q", tc.err, msg)
 			}
@@ -123,13 +126,13 @@ func TestUpdateRec(t *testing.T) {
 					SupplierRef: tc.supplierRef,
 					Status:      tc.status,
 				}
-				_, err := updater.UpdateRec(&rec)
+				_, err := updater.UpdateRec(context.Background(), &rec)
 				if err != nil {
 					t.Errorf("unexpected error: %v", err)
 				}
 			}
 			rec := Record{}
-			_, err := updater.UpdateRec(&rec)
+			_, err := updater.UpdateRec(context.Background(), &rec)
 			if msg := err.Error(); !strings.Contains(msg, tc.err) {
 				t.Errorf("expected error %q, got: %q", tc.err, msg)
 			}
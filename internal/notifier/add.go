@@ -1,9 +1,11 @@
 package notifier
 
 import (
+	"context"
 	"errors"
 	"log"
 	"os"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -39,7 +41,7 @@ func newDB() (*DB, error) {
 }
 
 // AddID adds internal_identifier to existing db record
-func (d *DB) AddID(ur *Response) (*dynamodb.UpdateItemOutput, error) {
+func (d *DB) AddID(ctx context.Context, ur *Response) (*dynamodb.UpdateItemOutput, error) {
 
 	tab, ok := os.LookupEnv("TABLE_NAME")
 	if !ok {
@@ -66,7 +68,7 @@ func (d *DB) AddID(ur *Response) (*dynamodb.UpdateItemOutput, error) {
 		},
 	}
 
-	out, err := d.DynamoDB.UpdateItem(input)
+	out, err := d.DynamoDB.UpdateItemWithContext(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -75,3 +77,106 @@ func (d *DB) AddID(ur *Response) (*dynamodb.UpdateItemOutput, error) {
 
 	return out, nil
 }
+
+// StoredID reads a change's internal_identifier from the table with a
+// consistent read, so an ID written after the stream record was captured
+// is seen. An empty ID means SNOW doesn't have the change yet.
+func (d *DB) StoredID(ctx context.Context, ref string) (string, error) {
+
+	tab, ok := os.LookupEnv("TABLE_NAME")
+	if !ok {
+		return "", errors.New("missing table name")
+	}
+
+	input := &dynamodb.GetItemInput{
+		TableName:            aws.String(tab),
+		ConsistentRead:       aws.Bool(true),
+		ProjectionExpression: aws.String("internal_identifier"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"supplierRef": {S: aws.String(ref)},
+		},
+	}
+
+	out, err := d.DynamoDB.GetItemWithContext(ctx, input)
+	if err != nil {
+		return "", err
+	}
+
+	if v, ok := out.Item["internal_identifier"]; ok && v.S != nil {
+		return *v.S, nil
+	}
+	return "", nil
+}
+
+// Delivery outcome attributes kept on each change item
+const (
+	attrLastStatus      = "lastStatusSent"
+	attrLastMessageType = "lastMessageType"
+	attrAttempts        = "deliveryAttempts"
+	attrFailed          = "failedAttempts"
+	attrLastError       = "lastError"
+	attrLastAttempt     = "lastAttemptAt"
+	attrLastSuccess     = "lastSuccessAt"
+	attrResponse        = "snowResponse"
+)
+
+// DeliveryState is the result of one attempt to send a change to SNOW
+type DeliveryState struct {
+	SupplierRef string
+	Status      string
+	MessageType string
+	Response    string
+	Err         error
+	At          time.Time
+}
+
+// RecordDelivery stores the latest delivery attempt on the change item, so
+// failedAttempts above zero means the change is out of sync with SNOW
+func (d *DB) RecordDelivery(ctx context.Context, ds *DeliveryState) (*dynamodb.UpdateItemOutput, error) {
+
+	tab, ok := os.LookupEnv("TABLE_NAME")
+	if !ok {
+		return nil, errors.New("missing table name")
+	}
+
+	if ds.SupplierRef == "" {
+		return nil, errors.New("missing supplierRef")
+	}
+
+	at := ds.At.UTC().Format(time.RFC3339)
+	values := map[string]*dynamodb.AttributeValue{
+		":one": {N: aws.String("1")},
+		":at":  {S: aws.String(at)},
+		":mt":  {S: aws.String(ds.MessageType)},
+		":rsp": {S: aws.String(ds.Response)},
+	}
+
+	var expr string
+	if ds.Err == nil {
+		expr = "SET " + attrLastStatus + " = :st, " + attrLastMessageType + " = :mt, " + attrLastAttempt + " = :at, " +
+			attrLastSuccess + " = :at, " + attrResponse + " = :rsp, " + attrFailed + " = :zero " +
+			"REMOVE " + attrLastError + " " +
+			"ADD " + attrAttempts + " :one"
+		values[":st"] = &dynamodb.AttributeValue{S: aws.String(ds.Status)}
+		values[":zero"] = &dynamodb.AttributeValue{N: aws.String("0")}
+	} else {
+		expr = "SET " + attrLastMessageType + " = :mt, " + attrLastAttempt + " = :at, " +
+			attrLastError + " = :err, " + attrResponse + " = :rsp " +
+			"ADD " + attrAttempts + " :one, " + attrFailed + " :one"
+		values[":err"] = &dynamodb.AttributeValue{S: aws.String(ds.Err.Error())}
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tab),
+		UpdateExpression:          aws.String(expr),
+		ConditionExpression:       aws.String("attribute_exists(supplierRef)"),
+		ExpressionAttributeValues: values,
+		Key: map[string]*dynamodb.AttributeValue{
+			"supplierRef": {
+				S: aws.String(ds.SupplierRef),
+			},
+		},
+	}
+
+	return d.DynamoDB.UpdateItemWithContext(ctx, input)
+}
@@ -1,20 +1,36 @@
 package notifier
 
 import (
+	"context"
+	"errors"
 	"os"
 	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
 type mockDynamoDB struct {
 	dynamodbiface.DynamoDBAPI
-	err error
+	err     error
+	updates []*dynamodb.UpdateItemInput
+	// ids are the stored internal_identifiers by supplierRef
+	ids map[string]string
 }
 
-func (md *mockDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+func (md *mockDynamoDB) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	out := new(dynamodb.GetItemOutput)
+	if id, ok := md.ids[*input.Key["supplierRef"].S]; ok {
+		out.Item = map[string]*dynamodb.AttributeValue{"internal_identifier": {S: aws.String(id)}}
+	}
+	return out, md.err
+}
+
+func (md *mockDynamoDB) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	md.updates = append(md.updates, input)
 	output := new(dynamodb.UpdateItemOutput)
 	return output, md.err
 }
@@ -73,23 +89,72 @@ func TestAddID(t *testing.T) {
 					SupplierRef: tc.supplierRef,
 					IntIdent:    tc.internalID,
 				}
-				_, err := updater.AddID(&rec)
+				_, err := updater.AddID(context.Background(), &rec)
 				if err != nil {
 					t.Errorf("unexpected error: %v", err)
 				}
 			}
 			os.Unsetenv("TABLE_NAME")
 			rec := Response{}
-			_, err := updater.AddID(&rec)
+			_, err := updater.AddID(context.Background(), &rec)
 			if msg := err.Error(); !strings.Contains(msg, tc.err0) {
 				t.Errorf("expected error %q, got: %q", tc.err0, msg)
 			}
 
 			os.Setenv("TABLE_NAME", "bar")
-			_, err = updater.AddID(&rec)
+			_, err = updater.AddID(context.Background(), &rec)
 			if msg := err.Error(); !strings.Contains(msg, tc.err1) {
 				t.Errorf("expected error %q, got: %q", tc.err1, msg)
 			}
 		})
 	}
 }
+
+func TestRecordDelivery(t *testing.T) {
+
+	tt := []struct {
+		name   string
+		state  DeliveryState
+		expect []string
+		absent []string
+		err    string
+	}{
+		{name: "success", state: DeliveryState{SupplierRef: "abc-123", Status: "In Progress", MessageType: updateMsgID, Response: "updated CHG001"},
+			expect: []string{"lastStatusSent = :st", "lastSuccessAt = :at", "failedAttempts = :zero", "REMOVE lastError", "ADD deliveryAttempts :one"}},
+		{name: "failure", state: DeliveryState{SupplierRef: "abc-123", Status: "In Progress", MessageType: updateMsgID, Err: errors.New("SNOW returned HTTP 503")},
+			expect: []string{"lastError = :err", "ADD deliveryAttempts :one, failedAttempts :one"}, absent: []string{"lastStatusSent", "lastSuccessAt"}},
+		{name: "bad", err: "missing supplierRef"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			os.Setenv("TABLE_NAME", "bar")
+			md := &mockDynamoDB{}
+			db := &DB{DynamoDB: md}
+
+			_, err := db.RecordDelivery(context.Background(), &tc.state)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expr := *md.updates[0].UpdateExpression
+			for _, e := range tc.expect {
+				if !strings.Contains(expr, e) {
+					t.Errorf("expected %q in %q", e, expr)
+				}
+			}
+			for _, e := range tc.absent {
+				if strings.Contains(expr, e) {
+					t.Errorf("unexpected %q in %q", e, expr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+// Alert tells operators about a change that needs attention
+type Alert struct {
+	SupplierRef string `json:"supplierRef"`
+	IntIdent    string `json:"internal_identifier,omitempty"`
+	Status      string `json:"status"`
+	Approval    string `json:"approval"`
+	Policy      string `json:"policy"`
+	Reason      string `json:"reason"`
+}
+
+// Alerter wraps SNS with iface pkg for easier testing
+type Alerter struct {
+	SNS   snsiface.SNSAPI
+	Topic string
+}
+
+// newAlerter returns nil when no ALERT_TOPIC_ARN is configured, in which
+// case alerts are only logged
+func newAlerter() (*Alerter, error) {
+
+	topic, ok := os.LookupEnv("ALERT_TOPIC_ARN")
+	if !ok || topic == "" {
+		return nil, nil
+	}
+
+	sess, err := newSession()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Alerter{SNS: sns.New(sess), Topic: topic}, nil
+}
+
+// raise logs an alert and publishes it if there's a topic
+func raise(ctx context.Context, a *Alert) error {
+
+	log.Printf("ALERT %v: %v (status: %v, approval: %q)", a.SupplierRef, a.Reason, a.Status, a.Approval)
+
+	al, err := newAlerter()
+	if err != nil {
+		return err
+	}
+
+	if al == nil {
+		return nil
+	}
+	return al.Publish(ctx, a)
+}
+
+// Publish sends an alert to the SNS topic
+func (al *Alerter) Publish(ctx context.Context, a *Alert) error {
+
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	input := &sns.PublishInput{
+		TopicArn: aws.String(al.Topic),
+		Subject:  aws.String("snow-forwarder: " + a.SupplierRef),
+		Message:  aws.String(string(b)),
+	}
+
+	_, err = al.SNS.PublishWithContext(ctx, input)
+	return err
+}
@@ -0,0 +1,148 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Approval policies for changes going In Progress
+const (
+	PolicyOff  = "off"
+	PolicyFlag = "flag"
+	PolicyHold = "hold"
+)
+
+// approvalPolicy reads APPROVAL_POLICY, off by default
+func approvalPolicy() (string, error) {
+
+	p := strings.ToLower(os.Getenv("APPROVAL_POLICY"))
+	switch p {
+	case "", PolicyOff:
+		return PolicyOff, nil
+	case PolicyFlag, PolicyHold:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown APPROVAL_POLICY %q", p)
+	}
+}
+
+// approved reports whether SNOW's approval state is one of APPROVED_STATES
+func approved(state string) bool {
+
+	v, ok := os.LookupEnv("APPROVED_STATES")
+	if !ok || strings.TrimSpace(v) == "" {
+		v = "approved"
+	}
+
+	for _, s := range strings.Split(v, ",") {
+		if strings.EqualFold(strings.TrimSpace(s), state) {
+			return true
+		}
+	}
+	return false
+}
+
+// started reports whether a change has gone In Progress or beyond
+func started(status string) bool {
+	return status == "In Progress" || status == "Completed"
+}
+
+// idStore reads the internal_identifier stored against a change
+type idStore interface {
+	StoredID(ctx context.Context, ref string) (string, error)
+}
+
+// storeOf returns the SNOW sink's table, if it is configured
+func storeOf(sinks []Sink) idStore {
+
+	for _, s := range sinks {
+		if ss, ok := s.(*snowSink); ok && ss.db != nil {
+			return ss.db
+		}
+	}
+	return nil
+}
+
+// gate checks a change has been approved in SNOW before it starts. Under
+// the hold policy every event for an unapproved change that has started,
+// including its completion, is alerted on and not forwarded; under flag
+// they are alerted on and forwarded anyway.
+//
+// A change SNOW doesn't hold yet can't have been approved there, so under
+// hold it is raised as Scheduled for SNOW to approve, and released once it
+// is. The stream image may predate the ID being stored, so ids is checked
+// before raising it.
+func gate(ctx context.Context, m *Message, ids idStore) (bool, error) {
+
+	if m.Event == EventCancelled || !started(m.Status) || approved(m.Approval) {
+		return true, nil
+	}
+
+	policy, err := approvalPolicy()
+	if err != nil {
+		return false, err
+	}
+
+	if policy == PolicyOff {
+		return true, nil
+	}
+
+	a := Alert{
+		SupplierRef: m.SupplierRef,
+		IntIdent:    m.IntID,
+		Status:      m.Status,
+		Approval:    m.Approval,
+		Policy:      policy,
+		Reason:      "change started without SNOW approval",
+	}
+
+	err = raise(ctx, &a)
+	if err != nil {
+		return false, err
+	}
+
+	if policy != PolicyHold {
+		return true, nil
+	}
+
+	if m.IntID == "" && ids != nil {
+		id, err := ids.StoredID(ctx, m.SupplierRef)
+		if err != nil {
+			return false, errors.New("could not read internal identifier: " + err.Error())
+		}
+		m.IntID = id
+	}
+
+	if m.IntID == "" {
+		log.Printf("raising %v as Scheduled for SNOW to approve, holding it %v", m.SupplierRef, m.Status)
+		m.MessageID = createMsgID
+		m.Event = EventScheduled
+		m.Status = "Scheduled"
+		m.Success = ""
+		m.SkipScheduled = false
+		return true, nil
+	}
+
+	log.Printf("holding %v until SNOW approves it, approval: %q", m.SupplierRef, m.Approval)
+	return false, nil
+}
+
+// released reports whether SNOW has just approved a change that is already
+// In Progress or Completed, which the hold policy will have kept back
+func released(record *events.DynamoDBEventRecord) bool {
+
+	policy, err := approvalPolicy()
+	if err != nil || policy != PolicyHold {
+		return false
+	}
+
+	old := attr(record.Change.OldImage, "approval")
+	new := attr(record.Change.NewImage, "approval")
+	return started(attr(record.Change.NewImage, "status")) && old != new && approved(new) && !approved(old)
+}
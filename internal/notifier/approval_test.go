@@ -0,0 +1,174 @@
+package notifier
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+type mockSNS struct {
+	snsiface.SNSAPI
+	input *sns.PublishInput
+}
+
+func (ms *mockSNS) PublishWithContext(ctx aws.Context, input *sns.PublishInput, opts ...request.Option) (*sns.PublishOutput, error) {
+	ms.input = input
+	return new(sns.PublishOutput), nil
+}
+
+// fakeIDs holds stored internal identifiers by supplierRef
+type fakeIDs map[string]string
+
+func (fi fakeIDs) StoredID(ctx context.Context, ref string) (string, error) {
+	return fi[ref], nil
+}
+
+func TestGate(t *testing.T) {
+
+	tt := []struct {
+		name     string
+		policy   string
+		states   string
+		event    string
+		status   string
+		approval string
+		intID    string
+		stored   string
+		expect   bool
+		create   bool
+		err      string
+	}{
+		{name: "off", event: EventStarted, expect: true},
+		{name: "flag", policy: "flag", event: EventStarted, approval: "requested", expect: true},
+		{name: "flag not in SNOW", policy: "flag", event: EventStarted, approval: "requested", intID: "-", expect: true},
+		{name: "hold", policy: "hold", event: EventStarted, approval: "requested", expect: false},
+		{name: "hold approved", policy: "hold", event: EventStarted, approval: "Approved", expect: true},
+		{name: "hold custom state", policy: "hold", states: "approved, not required", event: EventStarted, approval: "not required", expect: true},
+		{name: "hold scheduled", policy: "hold", event: EventScheduled, status: "Scheduled", expect: true},
+		{name: "hold update", policy: "hold", event: EventUpdated, expect: false},
+		{name: "hold completed", policy: "hold", event: EventCompleted, status: "Completed", expect: false},
+		{name: "hold approved completed", policy: "hold", event: EventCompleted, status: "Completed", approval: "approved", expect: true},
+		{name: "hold cancelled", policy: "hold", event: EventCancelled, status: "Cancelled", expect: true},
+		{name: "hold not in SNOW", policy: "hold", event: EventStarted, approval: "requested", intID: "-", expect: true, create: true},
+		{name: "hold completed not in SNOW", policy: "hold", event: EventCompleted, status: "Completed", intID: "-", expect: true, create: true},
+		{name: "hold stored after image", policy: "hold", event: EventStarted, approval: "requested", intID: "-", stored: "CHG009", expect: false},
+		{name: "flag stored after image", policy: "flag", event: EventStarted, approval: "requested", intID: "-", stored: "CHG009", expect: true},
+		{name: "unknown", policy: "block", event: EventStarted, err: "unknown APPROVAL_POLICY"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			os.Unsetenv("ALERT_TOPIC_ARN")
+			os.Setenv("APPROVAL_POLICY", tc.policy)
+			os.Setenv("APPROVED_STATES", tc.states)
+			defer os.Unsetenv("APPROVAL_POLICY")
+			defer os.Unsetenv("APPROVED_STATES")
+
+			status, intID := tc.status, "CHG001"
+			if status == "" {
+				status = "In Progress"
+			}
+			if tc.intID == "-" {
+				intID = ""
+			}
+
+			m := Message{MessageID: updateMsgID, IntID: intID, Event: tc.event, Approval: tc.approval,
+				Payload: Payload{SupplierRef: "abc-1", Status: status, Success: "true"}}
+			ok, err := gate(context.Background(), &m, fakeIDs{"abc-1": tc.stored})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ok != tc.expect {
+				t.Errorf("expected forward %v, got %v", tc.expect, ok)
+			}
+
+			// a change SNOW doesn't hold is raised for approval, not started
+			create := m.MessageID == createMsgID && m.Event == EventScheduled && m.Status == "Scheduled" && m.Success == ""
+			if create != tc.create {
+				t.Errorf("expected raised as Scheduled %v, got %+v", tc.create, m)
+			}
+			if tc.stored != "" && tc.policy == PolicyHold && m.IntID != tc.stored {
+				t.Errorf("expected stored ID %v, got %+v", tc.stored, m)
+			}
+		})
+	}
+}
+
+func TestReleased(t *testing.T) {
+
+	os.Setenv("APPROVAL_POLICY", "hold")
+	defer os.Unsetenv("APPROVAL_POLICY")
+
+	tt := []struct {
+		name   string
+		old    map[string]string
+		new    map[string]string
+		expect string
+	}{
+		{name: "approved", old: map[string]string{"status": "In Progress", "approval": "requested"},
+			new: map[string]string{"status": "In Progress", "approval": "approved", "internal_identifier": "CHG001"}, expect: EventStarted},
+		{name: "approved with edits", old: map[string]string{"status": "In Progress", "approval": "requested", "title": "old"},
+			new: map[string]string{"status": "In Progress", "approval": "approved", "title": "new", "internal_identifier": "CHG001"}, expect: EventStarted},
+		{name: "approved after completion", old: map[string]string{"status": "In Progress", "approval": "requested"},
+			new: map[string]string{"status": "Completed", "approval": "approved", "internal_identifier": "CHG001"}, expect: EventCompleted},
+		{name: "approved early", old: map[string]string{"status": "Scheduled", "approval": "requested"},
+			new: map[string]string{"status": "Scheduled", "approval": "approved"}},
+		{name: "rejected", old: map[string]string{"status": "In Progress", "approval": "requested"},
+			new: map[string]string{"status": "In Progress", "approval": "rejected"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			event := &events.DynamoDBEventRecord{
+				EventName: "MODIFY",
+				Change: events.DynamoDBStreamRecord{
+					OldImage: image(tc.old),
+					NewImage: image(tc.new),
+				},
+			}
+
+			p := Payload{}
+			msg, err := p.SetMsg(event)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if msg.Event != tc.expect {
+				t.Errorf("expected event %q, got %q", tc.expect, msg.Event)
+			}
+			if tc.expect != "" && (msg.MessageID != updateMsgID || msg.IntID != "CHG001" || msg.Success != "true") {
+				t.Errorf("expected In Progress update for released change, got %+v", msg)
+			}
+		})
+	}
+}
+
+func TestPublish(t *testing.T) {
+
+	ms := &mockSNS{}
+	al := &Alerter{SNS: ms, Topic: "arn:aws:sns:eu-west-2:123:alerts"}
+
+	a := Alert{SupplierRef: "abc-1", Status: "In Progress", Approval: "requested", Policy: PolicyHold, Reason: "not approved"}
+	err := al.Publish(context.Background(), &a)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if *ms.input.TopicArn != al.Topic || !strings.Contains(*ms.input.Message, `"policy":"hold"`) {
+		t.Errorf("unexpected publish input: %v", ms.input)
+	}
+}
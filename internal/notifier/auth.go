@@ -0,0 +1,281 @@
+package notifier
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenSkew renews OAuth tokens shortly before SNOW expires them
+const tokenSkew = time.Minute
+
+// defaultTokenLifetime is assumed when SNOW doesn't say when a token expires
+const defaultTokenLifetime = 30 * time.Minute
+
+// Authenticator adds credentials to outbound SNOW requests
+type Authenticator interface {
+	// Authorize sets credentials on the request
+	Authorize(req *http.Request) error
+	// Reset drops cached credentials after SNOW rejects them
+	Reset()
+}
+
+// newAuthenticator selects an authenticator from SNOW_AUTH, setting up the
+// client transport when the mode needs one
+func newAuthenticator(hc *http.Client) (Authenticator, error) {
+
+	mode := os.Getenv("SNOW_AUTH")
+	switch mode {
+	case "", "basic":
+		creds, err := newProvider(basicNames)
+		if err != nil {
+			return nil, err
+		}
+		return &basicAuth{creds: creds}, nil
+	case "oauth":
+		return newOAuth(hc)
+	case "mtls":
+		tr, err := mtlsTransport(hc.Transport)
+		if err != nil {
+			return nil, err
+		}
+		hc.Transport = tr
+		return noAuth{}, nil
+	default:
+		return nil, fmt.Errorf("unknown SNOW_AUTH mode %q", mode)
+	}
+}
+
+// basicAuth uses the SNOW integration user's password
+type basicAuth struct {
+	creds *Provider
+}
+
+// Authorize sets basic auth on the request
+func (a *basicAuth) Authorize(req *http.Request) error {
+
+	c, err := a.creds.Get(req.Context())
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.ID, c.Secret)
+	return nil
+}
+
+// Reset forces the password to be reloaded, e.g. after a rotation
+func (a *basicAuth) Reset() {
+	a.creds.Invalidate()
+}
+
+// noAuth is used when the transport authenticates, e.g. with mTLS
+type noAuth struct{}
+
+// Authorize leaves the request untouched
+func (noAuth) Authorize(req *http.Request) error {
+	return nil
+}
+
+// Reset has nothing to drop
+func (noAuth) Reset() {}
+
+// oauthAuth uses the OAuth2 client credentials grant
+type oauthAuth struct {
+	tokenURL string
+	scope    string
+	client   *http.Client
+	creds    *Provider
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// tokenResponse is returned from the SNOW token endpoint
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func newOAuth(hc *http.Client) (*oauthAuth, error) {
+
+	tu, ok := os.LookupEnv("SNOW_TOKEN_URL")
+	if !ok {
+		return nil, errors.New("missing environment variable SNOW_TOKEN_URL")
+	}
+
+	if _, err := url.Parse(tu); err != nil {
+		return nil, err
+	}
+
+	creds, err := newProvider(oauthNames)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oauthAuth{
+		tokenURL: tu,
+		scope:    os.Getenv("SNOW_TOKEN_SCOPE"),
+		client:   hc,
+		creds:    creds,
+	}, nil
+}
+
+// Authorize sets a bearer token on the request, fetching one if needed
+func (a *oauthAuth) Authorize(req *http.Request) error {
+
+	tok, err := a.getToken(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+	return nil
+}
+
+// Reset drops the cached token so the next request fetches a new one
+func (a *oauthAuth) Reset() {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.token = ""
+}
+
+// getToken returns the cached token or requests a new one
+func (a *oauthAuth) getToken(ctx context.Context) (string, error) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && time.Now().Before(a.expiry) {
+		return a.token, nil
+	}
+
+	code, body, err := a.requestToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// the client secret may have been rotated, reload it and try once more
+	if code == http.StatusUnauthorized {
+		log.Println("SNOW rejected OAuth client credentials, reloading and retrying")
+		a.creds.Invalidate()
+		code, body, err = a.requestToken(ctx)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if code != http.StatusOK {
+		return "", fmt.Errorf("SNOW token request failed with status %v", code)
+	}
+
+	var tr tokenResponse
+	err = json.Unmarshal(body, &tr)
+	if err != nil {
+		return "", err
+	}
+
+	if tr.AccessToken == "" {
+		return "", errors.New("SNOW token response has no access_token")
+	}
+
+	a.token = tr.AccessToken
+	a.expiry = time.Now().Add(tokenLifetime(tr.ExpiresIn))
+
+	log.Printf("fetched SNOW OAuth token, expires in %vs", tr.ExpiresIn)
+	return a.token, nil
+}
+
+// tokenLifetime is how long to use a token SNOW says expires in the given
+// number of seconds. Short-lived tokens are renewed halfway through rather
+// than a full tokenSkew early, and a missing expires_in gets a default.
+func tokenLifetime(expiresIn int64) time.Duration {
+
+	life := time.Duration(expiresIn) * time.Second
+	if life <= 0 {
+		life = defaultTokenLifetime
+	}
+
+	skew := tokenSkew
+	if life/2 < skew {
+		skew = life / 2
+	}
+	return life - skew
+}
+
+// requestToken calls the token endpoint with the client credentials
+func (a *oauthAuth) requestToken(ctx context.Context) (int, []byte, error) {
+
+	c, err := a.creds.Get(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", c.ID)
+	form.Set("client_secret", c.Secret)
+	if a.scope != "" {
+		form.Set("scope", a.scope)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", a.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return res.StatusCode, body, nil
+}
+
+// mtlsTransport presents a client certificate to SNOW, keeping any proxy
+// and TLS settings from the base transport
+func mtlsTransport(base http.RoundTripper) (*http.Transport, error) {
+
+	cert, ok := os.LookupEnv("SNOW_CLIENT_CERT")
+	if !ok {
+		return nil, errors.New("missing environment variable SNOW_CLIENT_CERT")
+	}
+
+	key, ok := os.LookupEnv("SNOW_CLIENT_KEY")
+	if !ok {
+		return nil, errors.New("missing environment variable SNOW_CLIENT_KEY")
+	}
+
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+
+	bt, ok := base.(*http.Transport)
+	if !ok {
+		bt = http.DefaultTransport.(*http.Transport)
+	}
+
+	tr := bt.Clone()
+	if tr.TLSClientConfig == nil {
+		tr.TLSClientConfig = &tls.Config{}
+	}
+	tr.TLSClientConfig.Certificates = []tls.Certificate{pair}
+	return tr, nil
+}
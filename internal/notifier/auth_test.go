@@ -0,0 +1,228 @@
+package notifier
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewAuthenticator(t *testing.T) {
+
+	tt := []struct {
+		name   string
+		mode   string
+		env    map[string]string
+		expect string
+		err    string
+	}{
+		{name: "default", env: map[string]string{"SNOW_CREDENTIALS_SOURCE": "env"}, expect: "*notifier.basicAuth"},
+		{name: "basic", mode: "basic", env: map[string]string{"SNOW_CREDENTIALS_SOURCE": "env"}, expect: "*notifier.basicAuth"},
+		{name: "basic no ssm", mode: "basic", err: "missing SSM parameter path"},
+		{name: "oauth", mode: "oauth", env: map[string]string{"SNOW_TOKEN_URL": "http://snow/oauth_token.do", "SNOW_CREDENTIALS_SOURCE": "env"},
+			expect: "*notifier.oauthAuth"},
+		{name: "oauth missing", mode: "oauth", err: "missing environment variable SNOW_TOKEN_URL"},
+		{name: "mtls missing", mode: "mtls", err: "missing environment variable SNOW_CLIENT_CERT"},
+		{name: "unknown", mode: "kerberos", err: "unknown SNOW_AUTH mode"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			os.Unsetenv("SNOW_CREDENTIALS_SOURCE")
+			os.Setenv("SNOW_AUTH", tc.mode)
+			defer os.Unsetenv("SNOW_AUTH")
+			for k, v := range tc.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			a, err := newAuthenticator(&http.Client{})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := fmt.Sprintf("%T", a); got != tc.expect {
+				t.Errorf("expected %v, got %v", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestOAuth(t *testing.T) {
+
+	var tokens, calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth_token.do" {
+			r.ParseForm()
+			if r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("client_id") != "id" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			tokens++
+			w.Write([]byte(`{"access_token":"tok` + strconv.Itoa(tokens) + `","expires_in":1800}`))
+			return
+		}
+		calls++
+		// reject the first token as if it had been revoked
+		if r.Header.Get("Authorization") != "Bearer tok2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	os.Setenv("SNOW_TOKEN_URL", srv.URL+"/oauth_token.do")
+	os.Setenv("SNOW_CREDENTIALS_SOURCE", "env")
+	os.Setenv("SNOW_CLIENT_ID", "id")
+	os.Setenv("SNOW_CLIENT_SECRET", "secret")
+	defer os.Unsetenv("SNOW_TOKEN_URL")
+	defer os.Unsetenv("SNOW_CREDENTIALS_SOURCE")
+	defer os.Unsetenv("SNOW_CLIENT_ID")
+	defer os.Unsetenv("SNOW_CLIENT_SECRET")
+
+	a, err := newOAuth(srv.Client())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := &Client{URL: srv.URL, HTTP: srv.Client(), Auth: a}
+
+	code, _, err := c.post(context.Background(), []byte(`{}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code != http.StatusOK {
+		t.Errorf("expected status 200 after refresh, got %v", code)
+	}
+	if tokens != 2 || calls != 2 {
+		t.Errorf("expected 2 tokens and 2 calls, got %v and %v", tokens, calls)
+	}
+
+	// cached token is reused
+	_, _, err = c.post(context.Background(), []byte(`{}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tokens != 2 {
+		t.Errorf("expected cached token to be reused, got %v token requests", tokens)
+	}
+}
+
+func TestTokenLifetime(t *testing.T) {
+
+	tt := []struct {
+		expiresIn int64
+		expect    time.Duration
+	}{
+		{expiresIn: 1800, expect: 29 * time.Minute},
+		{expiresIn: 60, expect: 30 * time.Second},
+		{expiresIn: 10, expect: 5 * time.Second},
+		{expiresIn: 0, expect: defaultTokenLifetime - tokenSkew},
+		{expiresIn: -5, expect: defaultTokenLifetime - tokenSkew},
+	}
+
+	for _, tc := range tt {
+		if got := tokenLifetime(tc.expiresIn); got != tc.expect {
+			t.Errorf("expires_in %v: expected %v, got %v", tc.expiresIn, tc.expect, got)
+		}
+	}
+}
+
+func TestMTLSTransport(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "mtls")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, key := writeCert(t, dir)
+
+	tt := []struct {
+		name string
+		cert string
+		key  string
+		err  string
+	}{
+		{name: "good", cert: cert, key: key},
+		{name: "no key", cert: cert, err: "missing environment variable SNOW_CLIENT_KEY"},
+		{name: "bad files", cert: key, key: cert, err: "PEM"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			os.Setenv("SNOW_CLIENT_CERT", tc.cert)
+			defer os.Unsetenv("SNOW_CLIENT_CERT")
+			if tc.key != "" {
+				os.Setenv("SNOW_CLIENT_KEY", tc.key)
+				defer os.Unsetenv("SNOW_CLIENT_KEY")
+			}
+
+			tr, err := mtlsTransport(nil)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(tr.TLSClientConfig.Certificates) != 1 {
+				t.Errorf("expected client certificate on transport")
+			}
+		})
+	}
+}
+
+// writeCert writes a self-signed client certificate and key to dir
+func writeCert(t *testing.T, dir string) (string, string) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "snow-forwarder"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("could not create certificate: %v", err)
+	}
+
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("could not marshal key: %v", err)
+	}
+
+	cp := filepath.Join(dir, "client.crt")
+	kp := filepath.Join(dir, "client.key")
+	ioutil.WriteFile(cp, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(kp, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600)
+	return cp, kp
+}
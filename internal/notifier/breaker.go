@@ -0,0 +1,203 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned while SNOW calls are being short-circuited
+var ErrCircuitOpen = errors.New("SNOW circuit breaker is open")
+
+// breaker defaults, overridden by SNOW_BREAKER_* variables
+const (
+	defaultBreakerThreshold = 0.5
+	defaultBreakerWindow    = 10
+	defaultBreakerCooldown  = 30 * time.Second
+	defaultBreakerTrials    = 1
+)
+
+type breakerState int
+
+const (
+	stateClosed breakerState = iota
+	stateOpen
+	stateHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case stateOpen:
+		return "open"
+	case stateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// Breaker stops calling SNOW once too many recent calls have failed. It
+// lives as long as the Lambda container, so warm invocations share it.
+type Breaker struct {
+	threshold float64
+	window    int
+	cooldown  time.Duration
+	trials    int
+
+	mu       sync.Mutex
+	state    breakerState
+	results  []bool
+	openedAt time.Time
+	inFlight int
+	passed   int
+	// gen counts state changes, so results of calls allowed in an earlier
+	// state are ignored
+	gen uint64
+	now func() time.Time
+}
+
+// NewBreaker opens after threshold of the last window calls failed, and
+// lets trials calls through once cooldown has passed
+func NewBreaker(threshold float64, window int, cooldown time.Duration, trials int) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		window:    window,
+		cooldown:  cooldown,
+		trials:    trials,
+		now:       time.Now,
+	}
+}
+
+// newBreaker builds a breaker from the environment
+func newBreaker() (*Breaker, error) {
+
+	threshold := defaultBreakerThreshold
+	if v, ok := os.LookupEnv("SNOW_BREAKER_THRESHOLD"); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 || f > 1 {
+			return nil, fmt.Errorf("invalid SNOW_BREAKER_THRESHOLD %q", v)
+		}
+		threshold = f
+	}
+
+	window := defaultBreakerWindow
+	if v, ok := os.LookupEnv("SNOW_BREAKER_WINDOW"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid SNOW_BREAKER_WINDOW %q", v)
+		}
+		window = n
+	}
+
+	cooldown := defaultBreakerCooldown
+	if v, ok := os.LookupEnv("SNOW_BREAKER_COOLDOWN"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SNOW_BREAKER_COOLDOWN %q", v)
+		}
+		cooldown = d
+	}
+
+	trials := defaultBreakerTrials
+	if v, ok := os.LookupEnv("SNOW_BREAKER_TRIALS"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid SNOW_BREAKER_TRIALS %q", v)
+		}
+		trials = n
+	}
+
+	return NewBreaker(threshold, window, cooldown, trials), nil
+}
+
+// Allow returns ErrCircuitOpen if the call should not be made, otherwise
+// the generation to record its result against
+func (b *Breaker) Allow() (uint64, error) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == stateOpen {
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return 0, ErrCircuitOpen
+		}
+		b.setState(stateHalfOpen)
+	}
+
+	if b.state == stateHalfOpen {
+		if b.inFlight >= b.trials {
+			return 0, ErrCircuitOpen
+		}
+		b.inFlight++
+	}
+	return b.gen, nil
+}
+
+// Record reports the result of a call allowed in generation gen. Calls
+// that outlived the state they were allowed in don't count, so a call
+// started while closed can't pass for a half-open trial.
+func (b *Breaker) Record(gen uint64, success bool) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if gen != b.gen {
+		return
+	}
+
+	if b.state == stateHalfOpen {
+		b.inFlight--
+		if !success {
+			b.trip()
+			return
+		}
+		b.passed++
+		if b.passed >= b.trials {
+			b.setState(stateClosed)
+		}
+		return
+	}
+
+	b.results = append(b.results, success)
+	if len(b.results) > b.window {
+		b.results = b.results[1:]
+	}
+
+	if len(b.results) < b.window {
+		return
+	}
+
+	var failed int
+	for _, ok := range b.results {
+		if !ok {
+			failed++
+		}
+	}
+
+	if float64(failed)/float64(len(b.results)) >= b.threshold {
+		b.trip()
+	}
+}
+
+// trip opens the circuit
+func (b *Breaker) trip() {
+	b.openedAt = b.now()
+	b.setState(stateOpen)
+}
+
+// setState moves to a new state and resets its counters
+func (b *Breaker) setState(s breakerState) {
+
+	if s != b.state {
+		log.Printf("SNOW circuit breaker %v -> %v", b.state, s)
+	}
+	b.state = s
+	b.gen++
+	b.results = nil
+	b.inFlight = 0
+	b.passed = 0
+}
@@ -0,0 +1,160 @@
+package notifier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+
+	now := time.Now()
+	b := NewBreaker(0.5, 4, time.Minute, 1)
+	b.now = func() time.Time { return now }
+
+	call := func(success bool) {
+		gen, err := b.Allow()
+		if err != nil {
+			t.Fatalf("expected call to be allowed, got: %v", err)
+		}
+		b.Record(gen, success)
+	}
+
+	// below the window nothing trips
+	call(false)
+	call(false)
+	call(false)
+	if _, err := b.Allow(); err != nil {
+		t.Fatalf("expected closed breaker, got: %v", err)
+	}
+
+	// 3 of the last 4 failed
+	call(true)
+	if _, err := b.Allow(); err != ErrCircuitOpen {
+		t.Fatalf("expected open breaker, got: %v", err)
+	}
+
+	// one trial is let through after the cooldown
+	now = now.Add(time.Minute)
+	trial, err := b.Allow()
+	if err != nil {
+		t.Fatalf("expected trial request, got: %v", err)
+	}
+	if _, err := b.Allow(); err != ErrCircuitOpen {
+		t.Fatalf("expected second trial to be refused, got: %v", err)
+	}
+
+	// failed trial reopens
+	b.Record(trial, false)
+	if b.state != stateOpen {
+		t.Fatalf("expected open after failed trial, got %v", b.state)
+	}
+
+	// successful trial closes
+	now = now.Add(time.Minute)
+	call(true)
+	if b.state != stateClosed {
+		t.Fatalf("expected closed after successful trial, got %v", b.state)
+	}
+	if _, err := b.Allow(); err != nil {
+		t.Errorf("expected closed breaker, got: %v", err)
+	}
+}
+
+func TestBreakerLateResult(t *testing.T) {
+
+	now := time.Now()
+	b := NewBreaker(1, 1, time.Minute, 1)
+	b.now = func() time.Time { return now }
+
+	// a slow call starts while closed, then another trips the breaker
+	slow, _ := b.Allow()
+	gen, _ := b.Allow()
+	b.Record(gen, false)
+
+	now = now.Add(time.Minute)
+	trial, err := b.Allow()
+	if err != nil {
+		t.Fatalf("expected trial request, got: %v", err)
+	}
+
+	// the slow call finishing doesn't count as the trial
+	b.Record(slow, true)
+	if b.state != stateHalfOpen || b.inFlight != 1 || b.passed != 0 {
+		t.Fatalf("expected the trial still pending, got %v with %v in flight, %v passed", b.state, b.inFlight, b.passed)
+	}
+
+	b.Record(trial, true)
+	if b.state != stateClosed {
+		t.Errorf("expected closed after the trial, got %v", b.state)
+	}
+}
+
+func TestNewBreaker(t *testing.T) {
+
+	tt := []struct {
+		name string
+		env  map[string]string
+		err  string
+	}{
+		{name: "default"},
+		{name: "custom", env: map[string]string{"SNOW_BREAKER_THRESHOLD": "0.25", "SNOW_BREAKER_WINDOW": "20",
+			"SNOW_BREAKER_COOLDOWN": "1m", "SNOW_BREAKER_TRIALS": "2"}},
+		{name: "threshold", env: map[string]string{"SNOW_BREAKER_THRESHOLD": "2"}, err: "invalid SNOW_BREAKER_THRESHOLD"},
+		{name: "window", env: map[string]string{"SNOW_BREAKER_WINDOW": "0"}, err: "invalid SNOW_BREAKER_WINDOW"},
+		{name: "cooldown", env: map[string]string{"SNOW_BREAKER_COOLDOWN": "30"}, err: "invalid SNOW_BREAKER_COOLDOWN"},
+		{name: "trials", env: map[string]string{"SNOW_BREAKER_TRIALS": "none"}, err: "invalid SNOW_BREAKER_TRIALS"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			for k, v := range tc.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			_, err := newBreaker()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestClientBreaker(t *testing.T) {
+
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := &Client{URL: srv.URL, HTTP: srv.Client(), Auth: noAuth{}, Breaker: NewBreaker(1, 2, time.Minute, 1)}
+
+	for i := 0; i < 2; i++ {
+		code, _, err := c.post(context.Background(), []byte(`{}`))
+		if err != nil || code != http.StatusServiceUnavailable {
+			t.Fatalf("expected 503, got %v: %v", code, err)
+		}
+	}
+
+	_, _, err := c.post(context.Background(), []byte(`{}`))
+	if err != ErrCircuitOpen {
+		t.Errorf("expected ErrCircuitOpen, got: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected SNOW to be called twice, got %v", calls)
+	}
+}
@@ -0,0 +1,272 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/jsd"
+	"github.com/UKHomeOffice/snow-forwarder/internal/transport"
+)
+
+// defaultChatEvents are posted unless a route lists its own
+var defaultChatEvents = []string{EventScheduled, EventRescheduled, EventStarted, EventCompleted, EventCancelled, EventOverdue}
+
+// Webhook is a Slack or Microsoft Teams incoming webhook
+type Webhook struct {
+	Type   string   `json:"type"`
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+}
+
+// NewChatSink returns the chat sink, for jobs that post outside the stream
+func NewChatSink() (Sink, error) {
+
+	s, err := newChatSink()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// chatSink posts change updates to chat channels. Routes map a JSD project
+// key to its webhooks, with "*" used for projects without their own.
+type chatSink struct {
+	routes map[string][]Webhook
+	client *http.Client
+}
+
+// newChatSink reads routes from CHAT_ROUTES, or the file in CHAT_ROUTES_FILE
+func newChatSink() (*chatSink, error) {
+
+	var b []byte
+	if v, ok := os.LookupEnv("CHAT_ROUTES"); ok {
+		b = []byte(v)
+	} else if f, ok := os.LookupEnv("CHAT_ROUTES_FILE"); ok {
+		var err error
+		b, err = ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, errors.New("missing environment variable CHAT_ROUTES or CHAT_ROUTES_FILE")
+	}
+
+	var routes map[string][]Webhook
+	err := json.Unmarshal(b, &routes)
+	if err != nil {
+		return nil, errors.New("could not parse chat routes: " + err.Error())
+	}
+
+	for project, hooks := range routes {
+		for _, h := range hooks {
+			if h.Type != "slack" && h.Type != "teams" {
+				return nil, fmt.Errorf("unknown webhook type %q for %v", h.Type, project)
+			}
+			if h.URL == "" {
+				return nil, fmt.Errorf("missing webhook url for %v", project)
+			}
+		}
+	}
+
+	tr, err := transport.New()
+	if err != nil {
+		return nil, err
+	}
+
+	return &chatSink{
+		routes: routes,
+		client: &http.Client{Timeout: 10 * time.Second, Transport: tr},
+	}, nil
+}
+
+// Name identifies the sink
+func (s *chatSink) Name() string {
+	return "chat"
+}
+
+// Targets returns a sink for each webhook routed for the change's project
+// and subscribed to its event, so each is delivered and retried on its own
+func (s *chatSink) Targets(m *Message) []Sink {
+
+	hooks, ok := s.routes[jsd.Project(m.SupplierRef)]
+	if !ok {
+		hooks = s.routes["*"]
+	}
+
+	var targets []Sink
+	for _, h := range hooks {
+		events := h.Events
+		if len(events) == 0 {
+			events = defaultChatEvents
+		}
+		if contains(events, m.Event) {
+			targets = append(targets, &webhookSink{chat: s, hook: h})
+		}
+	}
+	return targets
+}
+
+// Deliver posts the change to every webhook routed for its project, for
+// callers outside the stream
+func (s *chatSink) Deliver(ctx context.Context, m *Message) (*Result, error) {
+
+	targets := s.Targets(m)
+	if len(targets) == 0 {
+		return &Result{Outcome: OutcomeSkipped, Message: "no chat route for " + m.Event}, nil
+	}
+
+	for _, t := range targets {
+		_, err := t.Deliver(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Result{Outcome: OutcomeInserted, Message: fmt.Sprintf("posted to %v webhooks", len(targets))}, nil
+}
+
+// webhookSink posts to a single chat webhook
+type webhookSink struct {
+	chat *chatSink
+	hook Webhook
+}
+
+// Name identifies the webhook without giving away its URL, which is a
+// credential
+func (w *webhookSink) Name() string {
+
+	sum := sha256.Sum256([]byte(w.hook.URL))
+	return "chat:" + w.hook.Type + ":" + hex.EncodeToString(sum[:])[:8]
+}
+
+// Deliver posts the change to the webhook
+func (w *webhookSink) Deliver(ctx context.Context, m *Message) (*Result, error) {
+
+	var body interface{}
+	if w.hook.Type == "teams" {
+		body = teamsCard(m)
+	} else {
+		body = slackMessage(m)
+	}
+
+	err := w.chat.post(ctx, w.hook.URL, body)
+	if err != nil {
+		return nil, fmt.Errorf("could not post to %v webhook: %v", w.hook.Type, err)
+	}
+	return &Result{Outcome: OutcomeInserted, Message: "posted to " + w.hook.Type + " webhook"}, nil
+}
+
+// post sends a JSON body to a webhook
+func (s *chatSink) post(ctx context.Context, u string, body interface{}) error {
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		reply, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("webhook returned %v: %v", res.StatusCode, string(reply))
+	}
+	return nil
+}
+
+// headline summarises the change for chat
+func headline(m *Message) string {
+	return fmt.Sprintf("Change %v %v: %v", m.SupplierRef, m.Event, m.Title)
+}
+
+// window describes when the change happens
+func window(m *Message) string {
+	return m.StartTime + " to " + m.EndTime
+}
+
+// links returns the JSD and SNOW links for a change, where known
+func links(m *Message) [][2]string {
+
+	var l [][2]string
+	if u := os.Getenv("JSD_URL"); u != "" {
+		l = append(l, [2]string{"View in JSD", u + "/" + m.SupplierRef})
+	}
+	if u := os.Getenv("SNOW_CHANGE_URL"); u != "" && m.IntID != "" {
+		l = append(l, [2]string{"View in SNOW", u + m.IntID})
+	}
+	return l
+}
+
+// slackEscaper escapes the characters Slack treats as control sequences, so
+// text from Jira can't mention channels or render links of its own
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackMessage formats a change with Slack blocks
+func slackMessage(m *Message) map[string]interface{} {
+
+	title := slackEscaper.Replace(headline(m))
+	text := fmt.Sprintf("*%v*\n*Status:* %v\n*Window:* %v", title, slackEscaper.Replace(m.Status), slackEscaper.Replace(window(m)))
+	for _, l := range links(m) {
+		text += fmt.Sprintf("\n<%v|%v>", l[1], l[0])
+	}
+
+	return map[string]interface{}{
+		"text": title,
+		"blocks": []interface{}{
+			map[string]interface{}{
+				"type": "section",
+				"text": map[string]string{"type": "mrkdwn", "text": text},
+			},
+		},
+	}
+}
+
+// teamsCard formats a change as a Teams message card
+func teamsCard(m *Message) map[string]interface{} {
+
+	var actions []interface{}
+	for _, l := range links(m) {
+		actions = append(actions, map[string]interface{}{
+			"@type":   "OpenUri",
+			"name":    l[0],
+			"targets": []map[string]string{{"os": "default", "uri": l[1]}},
+		})
+	}
+
+	card := map[string]interface{}{
+		"@type":    "MessageCard",
+		"@context": "http://schema.org/extensions",
+		"summary":  headline(m),
+		"title":    headline(m),
+		"sections": []interface{}{
+			map[string]interface{}{
+				"facts": []map[string]string{
+					{"name": "Status", "value": m.Status},
+					{"name": "Window", "value": window(m)},
+				},
+			},
+		},
+	}
+	if len(actions) > 0 {
+		card["potentialAction"] = actions
+	}
+	return card
+}
@@ -0,0 +1,230 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// webhooks stands in for Slack and Teams, recording posts by path
+type webhooks struct {
+	mu    sync.Mutex
+	posts map[string][]map[string]interface{}
+}
+
+func (wh *webhooks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if strings.HasSuffix(r.URL.Path, "/broken") {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+		return
+	}
+
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	wh.posts[r.URL.Path] = append(wh.posts[r.URL.Path], body)
+	w.Write([]byte("ok"))
+}
+
+func TestChatSink(t *testing.T) {
+
+	wh := &webhooks{}
+	srv := httptest.NewServer(wh)
+	defer srv.Close()
+
+	routes := `{
+		"ACP": [{"type":"slack","url":"` + srv.URL + `/slack/acp"},{"type":"teams","url":"` + srv.URL + `/teams/acp","events":["completed"]}],
+		"BAD": [{"type":"slack","url":"` + srv.URL + `/slack/broken"}],
+		"*": [{"type":"teams","url":"` + srv.URL + `/teams/default"}]
+	}`
+	os.Setenv("CHAT_ROUTES", routes)
+	os.Setenv("JSD_URL", "https://jsd/browse")
+	os.Setenv("SNOW_CHANGE_URL", "https://snow/change_request.do?sysparm_query=number=")
+	defer os.Unsetenv("CHAT_ROUTES")
+	defer os.Unsetenv("JSD_URL")
+	defer os.Unsetenv("SNOW_CHANGE_URL")
+
+	s, err := newChatSink()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tt := []struct {
+		name    string
+		msg     Message
+		outcome Outcome
+		paths   []string
+		err     string
+	}{
+		{name: "slack only", msg: Message{Event: EventStarted, IntID: "CHG001", Payload: Payload{SupplierRef: "ACP-1", Title: "upgrade", Status: "In Progress"}},
+			outcome: OutcomeInserted, paths: []string{"/slack/acp"}},
+		{name: "both", msg: Message{Event: EventCompleted, IntID: "CHG001", Payload: Payload{SupplierRef: "acp-2", Status: "Completed"}},
+			outcome: OutcomeInserted, paths: []string{"/slack/acp", "/teams/acp"}},
+		{name: "fallback", msg: Message{Event: EventCancelled, Payload: Payload{SupplierRef: "OPS-3", Status: "Cancelled"}},
+			outcome: OutcomeInserted, paths: []string{"/teams/default"}},
+		{name: "not subscribed", msg: Message{Event: EventUpdated, Payload: Payload{SupplierRef: "OPS-4"}}, outcome: OutcomeSkipped},
+		{name: "webhook error", msg: Message{Event: EventScheduled, Payload: Payload{SupplierRef: "BAD-5"}}, err: "webhook returned 403"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			wh.posts = make(map[string][]map[string]interface{})
+
+			res, err := s.Deliver(context.Background(), &tc.msg)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res.Outcome != tc.outcome {
+				t.Errorf("expected outcome %v, got %v", tc.outcome, res.Outcome)
+			}
+			if len(wh.posts) != len(tc.paths) {
+				t.Errorf("expected posts to %v, got %v", tc.paths, wh.posts)
+			}
+
+			for _, p := range tc.paths {
+				posts := wh.posts[p]
+				if len(posts) != 1 {
+					t.Fatalf("expected one post to %v, got %v", p, len(posts))
+				}
+				b, _ := json.Marshal(posts[0])
+				body := string(b)
+				if !strings.Contains(body, tc.msg.SupplierRef) || !strings.Contains(body, "https://jsd/browse/"+tc.msg.SupplierRef) {
+					t.Errorf("expected post to mention and link %v, got %v", tc.msg.SupplierRef, body)
+				}
+				if tc.msg.IntID != "" && !strings.Contains(body, "number="+tc.msg.IntID) {
+					t.Errorf("expected post to link SNOW change, got %v", body)
+				}
+				if strings.HasPrefix(p, "/teams") && posts[0]["@type"] != "MessageCard" {
+					t.Errorf("expected Teams message card, got %v", body)
+				}
+				if strings.HasPrefix(p, "/slack") && posts[0]["blocks"] == nil {
+					t.Errorf("expected Slack blocks, got %v", body)
+				}
+			}
+		})
+	}
+}
+
+func TestSlackMessageEscaped(t *testing.T) {
+
+	os.Setenv("JSD_URL", "https://jsd/browse")
+	defer os.Unsetenv("JSD_URL")
+
+	m := &Message{Event: EventScheduled, Payload: Payload{SupplierRef: "ACP-1", Title: "<!channel> R&D <https://evil|login>", Status: "Scheduled"}}
+	msg := slackMessage(m)
+
+	expect := "Change ACP-1 scheduled: &lt;!channel&gt; R&amp;D &lt;https://evil|login&gt;"
+	if msg["text"] != expect {
+		t.Errorf("expected %q, got %q", expect, msg["text"])
+	}
+
+	block := msg["blocks"].([]interface{})[0].(map[string]interface{})
+	text := block["text"].(map[string]string)["text"]
+	if strings.Contains(text, "<!channel>") || strings.Contains(text, "<https://evil") || !strings.Contains(text, expect) {
+		t.Errorf("expected the title escaped, got %q", text)
+	}
+	if !strings.Contains(text, "<https://jsd/browse/ACP-1|View in JSD>") {
+		t.Errorf("expected our own link to be kept, got %q", text)
+	}
+}
+
+func TestChatWebhooksRetried(t *testing.T) {
+
+	wh := &webhooks{posts: make(map[string][]map[string]interface{})}
+	srv := httptest.NewServer(wh)
+	defer srv.Close()
+
+	os.Setenv("CHAT_ROUTES", `{"ACP": [{"type":"slack","url":"`+srv.URL+`/slack/acp"},{"type":"teams","url":"`+srv.URL+`/teams/broken"}]}`)
+	os.Setenv("SNOW_CHANGE_URL", "https://snow/change_request.do?sysparm_query=number=")
+	defer os.Unsetenv("CHAT_ROUTES")
+	defer os.Unsetenv("SNOW_CHANGE_URL")
+	os.Unsetenv("DEAD_LETTER_QUEUE_URL")
+
+	cs, err := newChatSink()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	l := &Ledger{
+		DynamoDB: &mockLedgerDB{claims: make(map[string]map[string]*dynamodb.AttributeValue)},
+		Table:    "idempotency",
+		TTL:      time.Hour,
+		Lock:     time.Minute,
+		now:      time.Now,
+	}
+	snow := &fakeSink{name: "snow"}
+
+	// the SNOW sink raises the change, and the chat post links to it
+	m := Message{MessageID: createMsgID, Event: EventScheduled, Payload: Payload{SupplierRef: "ACP-1", Status: "Scheduled"}}
+	for i := 0; i < 2; i++ {
+		ds, err := deliver(context.Background(), []Sink{snow, cs}, l, &m)
+		if err == nil || !strings.Contains(err.Error(), "webhook returned 403") {
+			t.Errorf("expected the broken webhook to fail, got: %v", err)
+		}
+		if len(ds) != 3 {
+			t.Errorf("expected a delivery per sink and webhook, got %v", len(ds))
+		}
+	}
+
+	// a retry goes only to the webhook that failed
+	posts := wh.posts["/slack/acp"]
+	if len(posts) != 1 {
+		t.Fatalf("expected one post to the working webhook, got %v", len(posts))
+	}
+	if b, _ := json.Marshal(posts[0]); !strings.Contains(string(b), "number=snow") {
+		t.Errorf("expected the post to link the SNOW change, got %v", string(b))
+	}
+}
+
+func TestNewChatSink(t *testing.T) {
+
+	tt := []struct {
+		name   string
+		routes string
+		err    string
+	}{
+		{name: "good", routes: `{"*":[{"type":"slack","url":"http://hook"}]}`},
+		{name: "missing", err: "missing environment variable CHAT_ROUTES"},
+		{name: "malformed", routes: `[]`, err: "could not parse chat routes"},
+		{name: "type", routes: `{"*":[{"type":"irc","url":"http://hook"}]}`, err: "unknown webhook type \"irc\""},
+		{name: "url", routes: `{"ACP":[{"type":"teams"}]}`, err: "missing webhook url for ACP"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			os.Unsetenv("CHAT_ROUTES")
+			if tc.routes != "" {
+				os.Setenv("CHAT_ROUTES", tc.routes)
+				defer os.Unsetenv("CHAT_ROUTES")
+			}
+
+			_, err := newChatSink()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
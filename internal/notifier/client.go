@@ -0,0 +1,276 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/transport"
+)
+
+// defaultTimeout bounds each HTTP request to SNOW, and how long a request
+// may queue for the rate limit. Fetching an OAuth token is a request of its
+// own, so a send can take longer than this.
+const defaultTimeout = 30 * time.Second
+
+// snow is the SNOW client, created once per cold start
+var (
+	snow   *Client
+	snowMu sync.Mutex
+)
+
+// Client sends messages to the SNOW endpoint
+type Client struct {
+	URL     string
+	HTTP    *http.Client
+	Auth    Authenticator
+	Breaker *Breaker
+	Limiter *Limiter
+
+	// sleep waits out Retry-After when there's no limiter to pause
+	sleep func(context.Context, time.Duration) error
+}
+
+// snowClient returns the cached SNOW client, creating it on first use
+func snowClient() (*Client, error) {
+
+	snowMu.Lock()
+	defer snowMu.Unlock()
+
+	if snow != nil {
+		return snow, nil
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	snow = c
+	return snow, nil
+}
+
+// newClient builds a SNOW client from the environment
+func newClient() (*Client, error) {
+
+	su, ok := os.LookupEnv("SNOW_URL")
+	if !ok {
+		return nil, errors.New("missing environment variable SNOW_URL")
+	}
+
+	u, err := url.Parse(su)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := defaultTimeout
+	if v, ok := os.LookupEnv("SNOW_TIMEOUT"); ok {
+		timeout, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, errors.New("invalid SNOW_TIMEOUT: " + err.Error())
+		}
+	}
+
+	tr, err := transport.New()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		URL:  u.String(),
+		HTTP: &http.Client{Timeout: timeout, Transport: tr},
+	}
+
+	c.Auth, err = newAuthenticator(c.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Breaker, err = newBreaker()
+	if err != nil {
+		return nil, err
+	}
+
+	c.Limiter, err = limiterFor(endpoint(c.URL))
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// post sends a JSON body to SNOW, renewing credentials and retrying once
+// if SNOW rejects them
+func (c *Client) post(ctx context.Context, body []byte) (int, []byte, error) {
+	return c.request(ctx, "POST", c.URL, body)
+}
+
+// get reads from another SNOW API on the same instance, e.g. the table API
+func (c *Client) get(ctx context.Context, u string) (int, []byte, error) {
+	return c.request(ctx, "GET", u, nil)
+}
+
+// request calls SNOW, renewing credentials and retrying once if SNOW
+// rejects them, and retrying once after Retry-After if SNOW is over quota
+// and there's time left
+func (c *Client) request(ctx context.Context, method, u string, body []byte) (int, []byte, error) {
+
+	code, reply, after, err := c.attempt(ctx, method, u, body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if code == http.StatusUnauthorized {
+		log.Println("SNOW rejected credentials, renewing and retrying")
+		c.Auth.Reset()
+		code, reply, after, err = c.attempt(ctx, method, u, body)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if code != http.StatusTooManyRequests {
+		return code, reply, nil
+	}
+
+	if after > c.budget(ctx) {
+		log.Printf("SNOW is over quota for %v, longer than the request may wait", after)
+		return code, reply, nil
+	}
+
+	log.Printf("SNOW is over quota, retrying in %v", after)
+
+	// a limiter was paused for Retry-After, otherwise wait it out here
+	if l, err := c.limiter(u); err == nil && l == nil {
+		sleep := c.sleep
+		if sleep == nil {
+			sleep = sleepCtx
+		}
+		err = sleep(ctx, after)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	code, reply, _, err = c.attempt(ctx, method, u, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return code, reply, nil
+}
+
+// budget is how long a request may wait before it is sent, the client
+// timeout or the time left before ctx's deadline if that is sooner. Zero
+// means no limit.
+func (c *Client) budget(ctx context.Context) time.Duration {
+
+	wait := c.HTTP.Timeout
+	if dl, ok := ctx.Deadline(); ok {
+		left := time.Until(dl)
+		if left <= 0 {
+			return -1
+		}
+		if wait == 0 || left < wait {
+			wait = left
+		}
+	}
+	return wait
+}
+
+// attempt makes a request once the rate limit allows, through the circuit
+// breaker, counting transport errors and SNOW being unavailable or
+// overloaded as failures. Every attempt, retries included, takes a token.
+// SNOW's Retry-After is returned with a 429.
+func (c *Client) attempt(ctx context.Context, method, u string, body []byte) (int, []byte, time.Duration, error) {
+
+	// don't queue for longer than the request or the invocation may take
+	wait := c.budget(ctx)
+	if wait < 0 {
+		if ctx.Err() != nil {
+			return 0, nil, 0, ctx.Err()
+		}
+		return 0, nil, 0, context.DeadlineExceeded
+	}
+
+	l, err := c.limiter(u)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	err = l.Wait(ctx, wait)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	if c.Breaker == nil {
+		return c.do(ctx, l, method, u, body)
+	}
+
+	gen, err := c.Breaker.Allow()
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	code, reply, after, err := c.do(ctx, l, method, u, body)
+	c.Breaker.Record(gen, err == nil && code != http.StatusTooManyRequests && code < 500)
+	return code, reply, after, err
+}
+
+// limiter returns the bucket for the endpoint a request goes to, so reads
+// from the table API don't use up the import set API's limit
+func (c *Client) limiter(u string) (*Limiter, error) {
+
+	e := endpoint(u)
+	if e == endpoint(c.URL) {
+		return c.Limiter, nil
+	}
+	return limiterFor(e)
+}
+
+// do makes a single authorised request, pausing l if SNOW is over quota
+func (c *Client) do(ctx context.Context, l *Limiter, method, u string, body []byte) (int, []byte, time.Duration, error) {
+
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, rd)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	err = c.Auth.Authorize(req)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	defer res.Body.Close()
+
+	reply, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	// SNOW is over quota, hold back everything going to this endpoint
+	var after time.Duration
+	if res.StatusCode == http.StatusTooManyRequests {
+		after = retryAfter(res.Header)
+		l.Pause(after)
+	}
+	return res.StatusCode, reply, after, nil
+}
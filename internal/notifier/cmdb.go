@@ -0,0 +1,263 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// CMDB defaults, overridden by CMDB_CACHE_TTL and CMDB_ASSET_FIELD
+const (
+	defaultCITTL      = 15 * time.Minute
+	defaultAssetField = "correlation_id"
+)
+
+// JSD sources of configuration items, prefixing keys in the mapping table
+const (
+	SourceComponent = "component"
+	SourceAsset     = "asset"
+)
+
+// ErrNoCI is returned when a change's components or Assets objects can't be
+// matched to SNOW configuration items. Retrying won't help until the
+// mapping is added.
+var ErrNoCI = errors.New("no SNOW configuration item")
+
+// CI is a SNOW configuration item
+type CI struct {
+	SysID string `json:"sys_id"`
+	Name  string `json:"name,omitempty"`
+}
+
+// cachedCI is a resolved CI and when it was resolved
+type cachedCI struct {
+	ci CI
+	at time.Time
+}
+
+// ciCache keeps resolved CIs across warm invocations. Misses aren't
+// cached, so a newly added mapping is used straight away.
+var ciCache = struct {
+	sync.Mutex
+	m map[string]cachedCI
+}{m: make(map[string]cachedCI)}
+
+// CMDB resolves JSD components and Assets object IDs to SNOW configuration
+// items, from a mapping table keyed by source and ID, e.g. component:Platform,
+// then optionally by querying the SNOW CMDB
+type CMDB struct {
+	DynamoDB dynamodbiface.DynamoDBAPI
+	Table    string
+	// URL is the SNOW table API for CIs, e.g. .../api/now/table/cmdb_ci.
+	// Components are matched on name and Assets objects on AssetField.
+	URL        string
+	AssetField string
+	// Default is used for changes with no components or Assets objects
+	Default string
+	TTL     time.Duration
+	now     func() time.Time
+}
+
+// newCMDB returns nil when neither CMDB_TABLE_NAME nor CMDB_URL is set,
+// in which case changes are sent without CIs
+func newCMDB() (*CMDB, error) {
+
+	c := &CMDB{
+		Table:      os.Getenv("CMDB_TABLE_NAME"),
+		URL:        os.Getenv("CMDB_URL"),
+		AssetField: defaultAssetField,
+		Default:    os.Getenv("CMDB_DEFAULT_CI"),
+		TTL:        defaultCITTL,
+		now:        time.Now,
+	}
+	if c.Table == "" && c.URL == "" {
+		return nil, nil
+	}
+
+	if v, ok := os.LookupEnv("CMDB_ASSET_FIELD"); ok && v != "" {
+		c.AssetField = v
+	}
+
+	if v, ok := os.LookupEnv("CMDB_CACHE_TTL"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid CMDB_CACHE_TTL %q", v)
+		}
+		c.TTL = d
+	}
+
+	if c.Table != "" {
+		db, err := newDB()
+		if err != nil {
+			return nil, err
+		}
+		c.DynamoDB = db.DynamoDB
+	}
+	return c, nil
+}
+
+// Attach resolves the message's components and Assets objects and adds
+// the CIs to the payload, the first as the primary CI. Every missing
+// mapping is listed in the error.
+func (c *CMDB) Attach(ctx context.Context, m *Message) error {
+
+	var keys []string
+	for _, v := range m.Components {
+		keys = append(keys, SourceComponent+":"+v)
+	}
+	for _, v := range m.Assets {
+		keys = append(keys, SourceAsset+":"+v)
+	}
+
+	if len(keys) == 0 {
+		if c.Default == "" {
+			return fmt.Errorf("%w for %v: it has no components or Assets objects and CMDB_DEFAULT_CI is not set",
+				ErrNoCI, m.SupplierRef)
+		}
+		m.ConfigItem = c.Default
+		m.ConfigItems = []string{c.Default}
+		return nil
+	}
+
+	var ids, missing []string
+	for _, k := range keys {
+		ci, err := c.Resolve(ctx, k)
+		if errors.Is(err, ErrNoCI) {
+			log.Println(err)
+			missing = append(missing, k)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !contains(ids, ci.SysID) {
+			ids = append(ids, ci.SysID)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w for %v: %v not mapped in %v", ErrNoCI, m.SupplierRef, strings.Join(missing, ", "), c.where())
+	}
+
+	m.ConfigItem = ids[0]
+	m.ConfigItems = ids
+	return nil
+}
+
+// Resolve finds the CI for a source:ID key, from the cache, the mapping
+// table, then the SNOW CMDB
+func (c *CMDB) Resolve(ctx context.Context, key string) (CI, error) {
+
+	ciCache.Lock()
+	e, ok := ciCache.m[key]
+	ciCache.Unlock()
+	if ok && c.now().Sub(e.at) < c.TTL {
+		return e.ci, nil
+	}
+
+	ci, err := c.resolve(ctx, key)
+	if err != nil {
+		return CI{}, err
+	}
+
+	ciCache.Lock()
+	ciCache.m[key] = cachedCI{ci: ci, at: c.now()}
+	ciCache.Unlock()
+	return ci, nil
+}
+
+// resolve looks a key up without the cache
+func (c *CMDB) resolve(ctx context.Context, key string) (CI, error) {
+
+	if c.Table != "" {
+		ci, ok, err := c.mapped(ctx, key)
+		if err != nil {
+			return CI{}, err
+		}
+		if ok {
+			return ci, nil
+		}
+	}
+
+	if c.URL != "" {
+		return c.query(ctx, key)
+	}
+	return CI{}, fmt.Errorf("%w for %v", ErrNoCI, key)
+}
+
+// mapped reads a key from the mapping table
+func (c *CMDB) mapped(ctx context.Context, key string) (CI, bool, error) {
+
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(c.Table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"key": {S: aws.String(key)},
+		},
+	}
+
+	out, err := c.DynamoDB.GetItemWithContext(ctx, input)
+	if err != nil {
+		return CI{}, false, err
+	}
+
+	v, ok := out.Item["sysId"]
+	if !ok || v.S == nil || *v.S == "" {
+		return CI{}, false, nil
+	}
+
+	ci := CI{SysID: *v.S}
+	if n, ok := out.Item["name"]; ok && n.S != nil {
+		ci.Name = *n.S
+	}
+	return ci, true, nil
+}
+
+// query looks a key up in the SNOW CMDB, which must hold exactly one match
+func (c *CMDB) query(ctx context.Context, key string) (CI, error) {
+
+	i := strings.Index(key, ":")
+	source, value := key[:i], key[i+1:]
+
+	field := "name"
+	if source == SourceAsset {
+		field = c.AssetField
+	}
+
+	rows, err := queryTable(ctx, c.URL, field, value, []string{"sys_id", "name"})
+	if err != nil {
+		return CI{}, err
+	}
+
+	switch len(rows) {
+	case 0:
+		return CI{}, fmt.Errorf("%w for %v", ErrNoCI, key)
+	case 1:
+		log.Printf("found %v in the SNOW CMDB as %v", key, rows[0]["sys_id"])
+		return CI{SysID: rows[0]["sys_id"], Name: rows[0]["name"]}, nil
+	default:
+		// picking one could put the change against the wrong service
+		return CI{}, fmt.Errorf("%w for %v: %v CIs in SNOW match", ErrNoCI, key, len(rows))
+	}
+}
+
+// where names the places a CI was looked for, for errors
+func (c *CMDB) where() string {
+
+	switch {
+	case c.Table != "" && c.URL != "":
+		return c.Table + " or the SNOW CMDB"
+	case c.Table != "":
+		return c.Table
+	default:
+		return "the SNOW CMDB"
+	}
+}
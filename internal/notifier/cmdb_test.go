@@ -0,0 +1,183 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// mockCMDBTable holds mappings of key to sys_id
+type mockCMDBTable struct {
+	dynamodbiface.DynamoDBAPI
+	items map[string]string
+	gets  int
+}
+
+func (mt *mockCMDBTable) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	mt.gets++
+	id, ok := mt.items[*input.Key["key"].S]
+	if !ok {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	return &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+		"key":   input.Key["key"],
+		"sysId": {S: aws.String(id)},
+	}}, nil
+}
+
+func TestCMDBAttach(t *testing.T) {
+
+	// the SNOW CMDB knows one service by name and two by the same asset ID
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("sysparm_query") {
+		case "name=Logging":
+			fmt.Fprint(w, `{"result":[{"sys_id":"ci-logging","name":"Logging"}]}`)
+		case "u_assets_id=OBJ-2":
+			fmt.Fprint(w, `{"result":[{"sys_id":"ci-a","name":"A"},{"sys_id":"ci-b","name":"B"}]}`)
+		default:
+			fmt.Fprint(w, `{"result":[]}`)
+		}
+	}))
+	defer srv.Close()
+
+	os.Setenv("SNOW_URL", srv.URL)
+	os.Setenv("SNOW_CREDENTIALS_SOURCE", "env")
+	os.Setenv("SNOW_USERNAME", "user")
+	os.Setenv("SNOW_PASSWORD", "pass")
+	defer os.Unsetenv("SNOW_URL")
+	snow = nil
+
+	table := map[string]string{"component:Platform": "ci-platform", "asset:OBJ-1": "ci-cluster"}
+
+	tt := []struct {
+		name       string
+		live       bool
+		def        string
+		components []string
+		assets     []string
+		expect     []string
+		err        string
+	}{
+		{name: "mapped", components: []string{"Platform"}, assets: []string{"OBJ-1"}, expect: []string{"ci-platform", "ci-cluster"}},
+		{name: "same CI twice", components: []string{"Platform"}, assets: []string{"OBJ-1", "OBJ-1"}, expect: []string{"ci-platform", "ci-cluster"}},
+		{name: "live", live: true, components: []string{"Platform", "Logging"}, expect: []string{"ci-platform", "ci-logging"}},
+		{name: "missing", components: []string{"Platform", "Logging"}, assets: []string{"OBJ-9"},
+			err: "component:Logging, asset:OBJ-9 not mapped in cmdb"},
+		{name: "missing live", live: true, components: []string{"Billing"}, err: "component:Billing not mapped in cmdb or the SNOW CMDB"},
+		{name: "ambiguous", live: true, assets: []string{"OBJ-2"}, err: "asset:OBJ-2 not mapped"},
+		{name: "default", def: "ci-default", expect: []string{"ci-default"}},
+		{name: "none", err: "no components or Assets objects"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			ciCache.m = make(map[string]cachedCI)
+			c := &CMDB{DynamoDB: &mockCMDBTable{items: table}, Table: "cmdb", AssetField: "u_assets_id",
+				Default: tc.def, TTL: time.Minute, now: time.Now}
+			if tc.live {
+				c.URL = srv.URL + "/api/now/table/cmdb_ci"
+			}
+
+			m := &Message{Payload: Payload{SupplierRef: "ACP-1"}, Components: tc.components, Assets: tc.assets}
+			err := c.Attach(context.Background(), m)
+
+			if tc.err != "" {
+				if !errors.Is(err, ErrNoCI) || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(m.ConfigItems, tc.expect) || m.ConfigItem != tc.expect[0] {
+				t.Errorf("expected %v, got %v and %v", tc.expect, m.ConfigItem, m.ConfigItems)
+			}
+		})
+	}
+}
+
+func TestCMDBCache(t *testing.T) {
+
+	ciCache.m = make(map[string]cachedCI)
+	now := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	mt := &mockCMDBTable{items: map[string]string{"component:Platform": "ci-platform"}}
+	c := &CMDB{DynamoDB: mt, Table: "cmdb", TTL: time.Minute, now: func() time.Time { return now }}
+
+	for i := 0; i < 3; i++ {
+		_, err := c.Resolve(context.Background(), "component:Platform")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if mt.gets != 1 {
+		t.Errorf("expected one table read while cached, got %v", mt.gets)
+	}
+
+	now = now.Add(2 * time.Minute)
+	c.Resolve(context.Background(), "component:Platform")
+	if mt.gets != 2 {
+		t.Errorf("expected the table to be read again once expired, got %v reads", mt.gets)
+	}
+
+	// misses aren't cached, so a new mapping is picked up straight away
+	c.Resolve(context.Background(), "component:Logging")
+	mt.items["component:Logging"] = "ci-logging"
+	ci, err := c.Resolve(context.Background(), "component:Logging")
+	if err != nil || ci.SysID != "ci-logging" {
+		t.Errorf("expected the new mapping, got %v, %v", ci, err)
+	}
+}
+
+func TestNewCMDB(t *testing.T) {
+
+	tt := []struct {
+		name string
+		env  map[string]string
+		nil  bool
+		err  string
+	}{
+		{name: "off", nil: true},
+		{name: "live only", env: map[string]string{"CMDB_URL": "https://snow/api/now/table/cmdb_ci"}},
+		{name: "table", env: map[string]string{"CMDB_TABLE_NAME": "cmdb", "REGION": "eu-west-2", "CMDB_CACHE_TTL": "1h"}},
+		{name: "bad ttl", env: map[string]string{"CMDB_URL": "https://snow", "CMDB_CACHE_TTL": "soon"}, err: "invalid CMDB_CACHE_TTL"},
+		{name: "no region", env: map[string]string{"CMDB_TABLE_NAME": "cmdb"}, err: "missing AWS region"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			for k, v := range tc.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			c, err := newCMDB()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (c == nil) != tc.nil {
+				t.Errorf("expected nil %v, got %+v", tc.nil, c)
+			}
+		})
+	}
+}
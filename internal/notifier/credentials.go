@@ -0,0 +1,317 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// defaultCredentialsTTL is how long credentials are trusted before reloading
+const defaultCredentialsTTL = 15 * time.Minute
+
+// Credentials are a username/password or OAuth client ID/secret pair
+type Credentials struct {
+	ID     string
+	Secret string
+}
+
+// Source fetches credentials from a secret store
+type Source interface {
+	Fetch(ctx context.Context) (Credentials, error)
+}
+
+// Provider caches credentials from a source until they expire or SNOW
+// rejects them. It is safe for concurrent use.
+type Provider struct {
+	source Source
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	creds   Credentials
+	expires time.Time
+}
+
+// NewProvider wraps a source with a credential cache
+func NewProvider(src Source, ttl time.Duration) *Provider {
+	return &Provider{source: src, ttl: ttl}
+}
+
+// Get returns cached credentials, fetching them when missing or expired
+func (p *Provider) Get(ctx context.Context) (Credentials, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.creds.ID != "" && time.Now().Before(p.expires) {
+		return p.creds, nil
+	}
+
+	c, err := p.source.Fetch(ctx)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	if c.ID == "" || c.Secret == "" {
+		return Credentials{}, errors.New("credential source returned empty credentials")
+	}
+
+	p.creds = c
+	p.expires = time.Now().Add(p.ttl)
+	return p.creds, nil
+}
+
+// Invalidate drops cached credentials, e.g. after a password rotation
+func (p *Provider) Invalidate() {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.creds = Credentials{}
+}
+
+// credentialNames are where each source finds a credential pair
+type credentialNames struct {
+	ssmID      string
+	ssmSecret  string
+	envID      string
+	envSecret  string
+	jsonID     string
+	jsonSecret string
+}
+
+var (
+	basicNames = credentialNames{
+		ssmID: "SSM_SNOW_USERNAME", ssmSecret: "SSM_SNOW_PASSWORD",
+		envID: "SNOW_USERNAME", envSecret: "SNOW_PASSWORD",
+		jsonID: "username", jsonSecret: "password",
+	}
+	oauthNames = credentialNames{
+		ssmID: "SSM_SNOW_CLIENT_ID", ssmSecret: "SSM_SNOW_CLIENT_SECRET",
+		envID: "SNOW_CLIENT_ID", envSecret: "SNOW_CLIENT_SECRET",
+		jsonID: "client_id", jsonSecret: "client_secret",
+	}
+)
+
+// newProvider builds a provider from SNOW_CREDENTIALS_SOURCE and
+// SNOW_CREDENTIALS_TTL
+func newProvider(names credentialNames) (*Provider, error) {
+
+	ttl := defaultCredentialsTTL
+	if v, ok := os.LookupEnv("SNOW_CREDENTIALS_TTL"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SNOW_CREDENTIALS_TTL: %v", err)
+		}
+		ttl = d
+	}
+
+	var src Source
+	kind := os.Getenv("SNOW_CREDENTIALS_SOURCE")
+	switch kind {
+	case "", "ssm":
+		idParam := os.Getenv(names.ssmID)
+		secretParam := os.Getenv(names.ssmSecret)
+		if idParam == "" || secretParam == "" {
+			return nil, errors.New("missing SSM parameter path environment variables")
+		}
+		svc, err := newSSM()
+		if err != nil {
+			return nil, err
+		}
+		src = &ssmSource{svc: svc, idParam: idParam, secretParam: secretParam}
+	case "secretsmanager":
+		id, ok := os.LookupEnv("SNOW_SECRET_ID")
+		if !ok {
+			return nil, errors.New("missing environment variable SNOW_SECRET_ID")
+		}
+		svc, err := newSecretsManager()
+		if err != nil {
+			return nil, err
+		}
+		src = &secretsManagerSource{svc: svc, secretID: id, names: names}
+	case "env":
+		src = &envSource{names: names}
+	case "file":
+		path, ok := os.LookupEnv("SNOW_CREDENTIALS_FILE")
+		if !ok {
+			return nil, errors.New("missing environment variable SNOW_CREDENTIALS_FILE")
+		}
+		src = &fileSource{path: path, names: names}
+	default:
+		return nil, fmt.Errorf("unknown SNOW_CREDENTIALS_SOURCE %q", kind)
+	}
+
+	return NewProvider(src, ttl), nil
+}
+
+func newSession() (*session.Session, error) {
+
+	region := os.Getenv("REGION")
+	if region == "" {
+		region = "eu-west-2"
+	}
+
+	return session.NewSession(&aws.Config{
+		Region: aws.String(region),
+	})
+}
+
+func newSSM() (ssmiface.SSMAPI, error) {
+
+	sess, err := newSession()
+	if err != nil {
+		return nil, err
+	}
+	return ssm.New(sess), nil
+}
+
+func newSecretsManager() (secretsmanageriface.SecretsManagerAPI, error) {
+
+	sess, err := newSession()
+	if err != nil {
+		return nil, err
+	}
+	return secretsmanager.New(sess), nil
+}
+
+// ssmSource reads a pair of SSM parameters
+type ssmSource struct {
+	svc         ssmiface.SSMAPI
+	idParam     string
+	secretParam string
+}
+
+func getSSMParameter(ctx context.Context, svc ssmiface.SSMAPI, name string) (string, error) {
+	input := &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	}
+	result, err := svc.GetParameterWithContext(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return *result.Parameter.Value, nil
+}
+
+// Fetch gets credentials from SSM Parameter Store
+func (s *ssmSource) Fetch(ctx context.Context) (Credentials, error) {
+
+	var c Credentials
+	var err error
+
+	c.ID, err = getSSMParameter(ctx, s.svc, s.idParam)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	c.Secret, err = getSSMParameter(ctx, s.svc, s.secretParam)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	log.Println("SNOW credentials loaded from SSM Parameter Store")
+	return c, nil
+}
+
+// secretsManagerSource reads a JSON secret from Secrets Manager
+type secretsManagerSource struct {
+	svc      secretsmanageriface.SecretsManagerAPI
+	secretID string
+	names    credentialNames
+}
+
+// Fetch gets credentials from Secrets Manager
+func (s *secretsManagerSource) Fetch(ctx context.Context) (Credentials, error) {
+
+	input := &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(s.secretID),
+	}
+	out, err := s.svc.GetSecretValueWithContext(ctx, input)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	if out.SecretString == nil {
+		return Credentials{}, errors.New("secret " + s.secretID + " has no string value")
+	}
+
+	c, err := parseCredentials([]byte(*out.SecretString), s.names)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	log.Println("SNOW credentials loaded from Secrets Manager")
+	return c, nil
+}
+
+// envSource reads credentials straight from the environment
+type envSource struct {
+	names credentialNames
+}
+
+// Fetch gets credentials from environment variables
+func (s *envSource) Fetch(ctx context.Context) (Credentials, error) {
+
+	c := Credentials{
+		ID:     os.Getenv(s.names.envID),
+		Secret: os.Getenv(s.names.envSecret),
+	}
+	if c.ID == "" || c.Secret == "" {
+		return Credentials{}, fmt.Errorf("missing environment variables %v or %v", s.names.envID, s.names.envSecret)
+	}
+	return c, nil
+}
+
+// fileSource reads a JSON credentials file, e.g. one mounted from a secret
+type fileSource struct {
+	path  string
+	names credentialNames
+}
+
+// Fetch gets credentials from a file
+func (s *fileSource) Fetch(ctx context.Context) (Credentials, error) {
+
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	c, err := parseCredentials(b, s.names)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	log.Printf("SNOW credentials loaded from %v", s.path)
+	return c, nil
+}
+
+// parseCredentials reads a credential pair from a JSON object
+func parseCredentials(b []byte, names credentialNames) (Credentials, error) {
+
+	var dat map[string]string
+	err := json.Unmarshal(b, &dat)
+	if err != nil {
+		return Credentials{}, errors.New("could not parse credentials: " + err.Error())
+	}
+
+	c := Credentials{
+		ID:     dat[names.jsonID],
+		Secret: dat[names.jsonSecret],
+	}
+	if c.ID == "" || c.Secret == "" {
+		return Credentials{}, fmt.Errorf("credentials missing %v or %v", names.jsonID, names.jsonSecret)
+	}
+	return c, nil
+}
@@ -0,0 +1,193 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+type mockSSM struct {
+	ssmiface.SSMAPI
+	params map[string]string
+}
+
+func (ms *mockSSM) GetParameterWithContext(ctx aws.Context, input *ssm.GetParameterInput, opts ...request.Option) (*ssm.GetParameterOutput, error) {
+	v, ok := ms.params[*input.Name]
+	if !ok {
+		return nil, errors.New("parameter not found")
+	}
+	return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(v)}}, nil
+}
+
+type mockSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+	secret string
+}
+
+func (ms *mockSecretsManager) GetSecretValueWithContext(ctx aws.Context, input *secretsmanager.GetSecretValueInput, opts ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(ms.secret)}, nil
+}
+
+// countingSource returns a new password on every fetch
+type countingSource struct {
+	mu      sync.Mutex
+	fetches int
+}
+
+func (cs *countingSource) Fetch(ctx context.Context) (Credentials, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.fetches++
+	return Credentials{ID: "user", Secret: strings.Repeat("x", cs.fetches)}, nil
+}
+
+func TestProvider(t *testing.T) {
+
+	src := &countingSource{}
+	p := NewProvider(src, time.Hour)
+
+	// concurrent callers share one fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := p.Get(context.Background()); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if src.fetches != 1 {
+		t.Errorf("expected 1 fetch, got %v", src.fetches)
+	}
+
+	// rotated password is picked up after invalidation
+	p.Invalidate()
+	c, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Secret != "xx" {
+		t.Errorf("expected rotated secret, got %q", c.Secret)
+	}
+
+	// expired credentials are fetched again
+	p.ttl = 0
+	p.Invalidate()
+	p.Get(context.Background())
+	p.Get(context.Background())
+	if src.fetches != 4 {
+		t.Errorf("expected 4 fetches with no TTL, got %v", src.fetches)
+	}
+}
+
+func TestSources(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "creds")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	good := filepath.Join(dir, "good.json")
+	ioutil.WriteFile(good, []byte(`{"username":"user","password":"pass"}`), 0600)
+	bad := filepath.Join(dir, "bad.json")
+	ioutil.WriteFile(bad, []byte(`{"username":"user"}`), 0600)
+
+	os.Setenv("SNOW_USERNAME", "user")
+	os.Setenv("SNOW_PASSWORD", "pass")
+	defer os.Unsetenv("SNOW_USERNAME")
+	defer os.Unsetenv("SNOW_PASSWORD")
+
+	tt := []struct {
+		name string
+		src  Source
+		err  string
+	}{
+		{name: "ssm", src: &ssmSource{svc: &mockSSM{params: map[string]string{"/u": "user", "/p": "pass"}}, idParam: "/u", secretParam: "/p"}},
+		{name: "ssm missing", src: &ssmSource{svc: &mockSSM{}, idParam: "/u", secretParam: "/p"}, err: "parameter not found"},
+		{name: "secrets manager", src: &secretsManagerSource{svc: &mockSecretsManager{secret: `{"username":"user","password":"pass"}`}, names: basicNames}},
+		{name: "secrets manager oauth", src: &secretsManagerSource{svc: &mockSecretsManager{secret: `{"username":"user","password":"pass"}`}, names: oauthNames},
+			err: "credentials missing client_id or client_secret"},
+		{name: "secrets manager bad", src: &secretsManagerSource{svc: &mockSecretsManager{secret: `pass`}, names: basicNames}, err: "could not parse credentials"},
+		{name: "env", src: &envSource{names: basicNames}},
+		{name: "env missing", src: &envSource{names: oauthNames}, err: "missing environment variables SNOW_CLIENT_ID"},
+		{name: "file", src: &fileSource{path: good, names: basicNames}},
+		{name: "file incomplete", src: &fileSource{path: bad, names: basicNames}, err: "credentials missing username or password"},
+		{name: "file missing", src: &fileSource{path: filepath.Join(dir, "none"), names: basicNames}, err: "no such file"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			c, err := tc.src.Fetch(context.Background())
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if c.ID != "user" || c.Secret != "pass" {
+				t.Errorf("expected user/pass, got %v/%v", c.ID, c.Secret)
+			}
+		})
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+
+	tt := []struct {
+		name string
+		env  map[string]string
+		err  string
+	}{
+		{name: "env", env: map[string]string{"SNOW_CREDENTIALS_SOURCE": "env"}},
+		{name: "ssm", env: map[string]string{"SSM_SNOW_USERNAME": "/u", "SSM_SNOW_PASSWORD": "/p"}},
+		{name: "ssm missing", err: "missing SSM parameter path"},
+		{name: "secrets manager", env: map[string]string{"SNOW_CREDENTIALS_SOURCE": "secretsmanager", "SNOW_SECRET_ID": "snow"}},
+		{name: "secrets manager missing", env: map[string]string{"SNOW_CREDENTIALS_SOURCE": "secretsmanager"}, err: "missing environment variable SNOW_SECRET_ID"},
+		{name: "file missing", env: map[string]string{"SNOW_CREDENTIALS_SOURCE": "file"}, err: "missing environment variable SNOW_CREDENTIALS_FILE"},
+		{name: "ttl", env: map[string]string{"SNOW_CREDENTIALS_SOURCE": "env", "SNOW_CREDENTIALS_TTL": "soon"}, err: "invalid SNOW_CREDENTIALS_TTL"},
+		{name: "unknown", env: map[string]string{"SNOW_CREDENTIALS_SOURCE": "vault"}, err: "unknown SNOW_CREDENTIALS_SOURCE"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			os.Unsetenv("SNOW_CREDENTIALS_SOURCE")
+			for k, v := range tc.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			_, err := newProvider(basicNames)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// DeadLetter is a message SNOW could not be sent. The message fields SNOW
+// never sees are kept alongside it so it can be redriven as it was.
+type DeadLetter struct {
+	Reason        string   `json:"reason"`
+	Message       Message  `json:"message"`
+	Event         string   `json:"event"`
+	Approval      string   `json:"approval,omitempty"`
+	SkipScheduled bool     `json:"skipScheduled,omitempty"`
+	Components    []string `json:"components,omitempty"`
+	Assets        []string `json:"assets,omitempty"`
+	Sequence      string   `json:"sequence,omitempty"`
+
+	// receipt is SQS's handle for deleting a received message
+	receipt string
+}
+
+// newDeadLetter wraps a message with the reason it was parked
+func newDeadLetter(m *Message, reason error) DeadLetter {
+	return DeadLetter{
+		Reason:        reason.Error(),
+		Message:       *m,
+		Event:         m.Event,
+		Approval:      m.Approval,
+		SkipScheduled: m.SkipScheduled,
+		Components:    m.Components,
+		Assets:        m.Assets,
+		Sequence:      m.Sequence,
+	}
+}
+
+// Restore returns the parked message, ready to deliver again
+func (dl *DeadLetter) Restore() *Message {
+
+	m := dl.Message
+	m.Event = dl.Event
+	m.Approval = dl.Approval
+	m.SkipScheduled = dl.SkipScheduled
+	m.Components = dl.Components
+	m.Assets = dl.Assets
+	m.Sequence = dl.Sequence
+	return &m
+}
+
+// DLQ wraps SQS with iface pkg for easier testing
+type DLQ struct {
+	SQS sqsiface.SQSAPI
+	URL string
+}
+
+// the dead-letter queue client is built once per cold start
+var (
+	dlq   *DLQ
+	dlqMu sync.Mutex
+)
+
+// newDLQ returns nil when no dead-letter queue is configured, in which case
+// failed records are left for the stream to retry
+func newDLQ() (*DLQ, error) {
+
+	u, ok := os.LookupEnv("DEAD_LETTER_QUEUE_URL")
+	if !ok || u == "" {
+		return nil, nil
+	}
+
+	dlqMu.Lock()
+	defer dlqMu.Unlock()
+
+	if dlq != nil && dlq.URL == u {
+		return dlq, nil
+	}
+
+	sess, err := newSession()
+	if err != nil {
+		return nil, err
+	}
+
+	dlq = &DLQ{SQS: sqs.New(sess), URL: u}
+	return dlq, nil
+}
+
+// NewDLQ returns the dead-letter queue for jobs that redrive it
+func NewDLQ() (*DLQ, error) {
+
+	q, err := newDLQ()
+	if err != nil {
+		return nil, err
+	}
+	if q == nil {
+		return nil, errors.New("missing environment variable DEAD_LETTER_QUEUE_URL")
+	}
+	return q, nil
+}
+
+// park sends a message to the dead-letter queue if there is one, otherwise
+// returns the reason so the stream retries the batch
+func park(ctx context.Context, m *Message, reason error) error {
+
+	q, err := newDLQ()
+	if err != nil {
+		return err
+	}
+
+	if q == nil {
+		return reason
+	}
+	return q.Send(ctx, m, reason)
+}
+
+// Send parks a message on the dead-letter queue
+func (q *DLQ) Send(ctx context.Context, m *Message, reason error) error {
+
+	b, err := json.Marshal(newDeadLetter(m, reason))
+	if err != nil {
+		return err
+	}
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.URL),
+		MessageBody: aws.String(string(b)),
+	}
+
+	_, err = q.SQS.SendMessageWithContext(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("sent %v to dead-letter queue: %v", m.SupplierRef, reason)
+	return nil
+}
+
+// Receive reads up to max parked messages. They stay on the queue, hidden
+// for its visibility timeout, until deleted.
+func (q *DLQ) Receive(ctx context.Context, max int) ([]*DeadLetter, error) {
+
+	var dls []*DeadLetter
+	for len(dls) < max {
+
+		n := max - len(dls)
+		if n > 10 {
+			n = 10
+		}
+
+		input := &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(q.URL),
+			MaxNumberOfMessages: aws.Int64(int64(n)),
+		}
+
+		out, err := q.SQS.ReceiveMessageWithContext(ctx, input)
+		if err != nil {
+			return dls, err
+		}
+
+		if len(out.Messages) == 0 {
+			break
+		}
+
+		for _, msg := range out.Messages {
+			var dl DeadLetter
+			err := json.Unmarshal([]byte(aws.StringValue(msg.Body)), &dl)
+			if err != nil {
+				log.Printf("could not parse dead letter %v: %v", aws.StringValue(msg.MessageId), err)
+				continue
+			}
+			dl.receipt = aws.StringValue(msg.ReceiptHandle)
+			dls = append(dls, &dl)
+		}
+	}
+	return dls, nil
+}
+
+// Delete removes a received message from the queue
+func (q *DLQ) Delete(ctx context.Context, dl *DeadLetter) error {
+
+	input := &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(q.URL),
+		ReceiptHandle: aws.String(dl.receipt),
+	}
+
+	_, err := q.SQS.DeleteMessageWithContext(ctx, input)
+	return err
+}
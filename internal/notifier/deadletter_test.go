@@ -0,0 +1,152 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+type mockSQS struct {
+	sqsiface.SQSAPI
+	body    string
+	err     error
+	queue   []*sqs.Message
+	deleted []string
+}
+
+func (ms *mockSQS) SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	ms.body = *input.MessageBody
+	return new(sqs.SendMessageOutput), ms.err
+}
+
+func (ms *mockSQS) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	n := int(*input.MaxNumberOfMessages)
+	if n > len(ms.queue) {
+		n = len(ms.queue)
+	}
+	out := &sqs.ReceiveMessageOutput{Messages: ms.queue[:n]}
+	ms.queue = ms.queue[n:]
+	return out, ms.err
+}
+
+func (ms *mockSQS) DeleteMessageWithContext(ctx aws.Context, input *sqs.DeleteMessageInput, opts ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	ms.deleted = append(ms.deleted, *input.ReceiptHandle)
+	return new(sqs.DeleteMessageOutput), ms.err
+}
+
+func TestDLQReceive(t *testing.T) {
+
+	ms := &mockSQS{}
+	for i := 0; i < 12; i++ {
+		body := fmt.Sprintf(`{"reason":"boom","message":{"payload":{"supplierRef":"abc-%v"}},"event":"started","sequence":"%v"}`, i, i)
+		ms.queue = append(ms.queue, &sqs.Message{Body: aws.String(body), ReceiptHandle: aws.String(fmt.Sprint("r", i))})
+	}
+	ms.queue = append(ms.queue, &sqs.Message{Body: aws.String("not json"), ReceiptHandle: aws.String("bad")})
+
+	q := &DLQ{SQS: ms, URL: "https://sqs/dlq"}
+
+	// reads in batches of ten up to the limit asked for
+	dls, err := q.Receive(context.Background(), 11)
+	if err != nil || len(dls) != 11 {
+		t.Fatalf("expected 11 dead letters, got %v: %v", len(dls), err)
+	}
+	if m := dls[3].Restore(); m.SupplierRef != "abc-3" || m.Event != EventStarted || m.Sequence != "3" {
+		t.Errorf("unexpected message %+v", m)
+	}
+
+	// unparsable messages are left on the queue
+	rest, err := q.Receive(context.Background(), 10)
+	if err != nil || len(rest) != 1 {
+		t.Fatalf("expected the last dead letter, got %v: %v", len(rest), err)
+	}
+
+	if err := q.Delete(context.Background(), dls[3]); err != nil || !reflect.DeepEqual(ms.deleted, []string{"r3"}) {
+		t.Errorf("expected r3 deleted, got %v: %v", ms.deleted, err)
+	}
+}
+
+func TestDLQSend(t *testing.T) {
+
+	tt := []struct {
+		name string
+		err  error
+	}{
+		{name: "good"},
+		{name: "bad", err: errors.New("queue does not exist")},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			ms := &mockSQS{err: tc.err}
+			q := &DLQ{SQS: ms, URL: "https://sqs/dlq"}
+			m := Message{MessageID: updateMsgID, IntID: "CHG001", Event: EventStarted, SkipScheduled: true,
+				Components: []string{"Platform"}, Assets: []string{"1234"}, Sequence: "100", Payload: Payload{SupplierRef: "abc-123"}}
+
+			err := q.Send(context.Background(), &m, ErrCircuitOpen)
+			if tc.err != nil {
+				if err != tc.err {
+					t.Errorf("expected error %v, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var dl DeadLetter
+			json.Unmarshal([]byte(ms.body), &dl)
+			if dl.Reason != ErrCircuitOpen.Error() || dl.Message.SupplierRef != "abc-123" || dl.Message.IntID != "CHG001" {
+				t.Errorf("unexpected dead letter: %v", ms.body)
+			}
+
+			// the message comes back as it was parked
+			if got := dl.Restore(); !reflect.DeepEqual(*got, m) {
+				t.Errorf("expected %+v restored, got %+v", m, *got)
+			}
+		})
+	}
+}
+
+func TestPark(t *testing.T) {
+
+	os.Unsetenv("DEAD_LETTER_QUEUE_URL")
+	m := Message{Payload: Payload{SupplierRef: "abc-123"}}
+
+	// without a queue the error is returned so the stream retries
+	err := park(context.Background(), &m, ErrCircuitOpen)
+	if err == nil || !strings.Contains(err.Error(), "circuit breaker is open") {
+		t.Errorf("expected circuit breaker error, got: %v", err)
+	}
+}
+
+func TestNewDLQ(t *testing.T) {
+
+	os.Setenv("DEAD_LETTER_QUEUE_URL", "https://sqs/dlq")
+	defer os.Unsetenv("DEAD_LETTER_QUEUE_URL")
+
+	a, err := newDLQ()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, _ := newDLQ()
+	if a == nil || a != b {
+		t.Errorf("expected the queue client to be reused, got %p and %p", a, b)
+	}
+
+	os.Setenv("DEAD_LETTER_QUEUE_URL", "https://sqs/other")
+	c, _ := newDLQ()
+	if c == a || c.URL != "https://sqs/other" {
+		t.Errorf("expected a new client for a new queue, got %+v", c)
+	}
+}
@@ -0,0 +1,87 @@
+package notifier
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// defaultTracked are the record fields SNOW cares about
+const defaultTracked = "status,title,description,startTime,endTime"
+
+// bookkeeping are attributes the forwarder and its jobs write about a change,
+// rather than the change itself. They never trigger a notification, even
+// when listed in TRACKED_FIELDS.
+var bookkeeping = []string{"internal_identifier", attrLastStatus, attrLastMessageType, attrAttempts, attrFailed,
+	attrLastError, attrLastAttempt, attrLastSuccess, attrResponse, "snowState", "snowUpdatedAt", "jiraSyncKey", "jiraSynced"}
+
+// bookkept reports whether a field is one of our own, including the
+// sweeper's overdue* marks
+func bookkept(field string) bool {
+	return contains(bookkeeping, field) || strings.HasPrefix(field, "overdue")
+}
+
+// checkStreamView fails for streams without old images, which can't tell
+// the change apart from our own writes to it
+func checkStreamView(records []events.DynamoDBEventRecord) error {
+
+	for _, r := range records {
+		v := r.Change.StreamViewType
+		if v != "" && v != string(events.DynamoDBStreamViewTypeNewAndOldImages) {
+			return fmt.Errorf("stream view type is %v, the notifier needs %v", v, events.DynamoDBStreamViewTypeNewAndOldImages)
+		}
+	}
+	return nil
+}
+
+// trackedFields returns the fields that trigger a notification when changed
+func trackedFields() []string {
+
+	v, ok := os.LookupEnv("TRACKED_FIELDS")
+	if !ok || strings.TrimSpace(v) == "" {
+		v = defaultTracked
+	}
+
+	var fields []string
+	for _, f := range strings.Split(v, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if bookkept(f) {
+			log.Printf("ignoring %v in TRACKED_FIELDS, it is written by the forwarder", f)
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// changed lists the tracked fields that differ between two stream images
+func changed(old, new map[string]events.DynamoDBAttributeValue) []string {
+
+	var diff []string
+	for _, f := range trackedFields() {
+		ov, oldOK := old[f]
+		nv, newOK := new[f]
+		if oldOK != newOK || !reflect.DeepEqual(ov, nv) {
+			diff = append(diff, f)
+		}
+	}
+	return diff
+}
+
+// contains reports whether field is in fields
+func contains(fields []string, field string) bool {
+
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
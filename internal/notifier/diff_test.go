@@ -0,0 +1,87 @@
+package notifier
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestChanged(t *testing.T) {
+
+	tt := []struct {
+		name    string
+		tracked string
+		old     map[string]string
+		new     map[string]string
+		expect  []string
+	}{
+		{name: "none", old: map[string]string{"status": "Scheduled"}, new: map[string]string{"status": "Scheduled"}},
+		{name: "own write", old: map[string]string{"status": "Scheduled"},
+			new: map[string]string{"status": "Scheduled", "internal_identifier": "CHG001"}},
+		{name: "status", old: map[string]string{"status": "Scheduled"}, new: map[string]string{"status": "In Progress"},
+			expect: []string{"status"}},
+		{name: "window", old: map[string]string{"startTime": "a", "endTime": "b"}, new: map[string]string{"startTime": "c", "endTime": "d"},
+			expect: []string{"startTime", "endTime"}},
+		{name: "added", old: map[string]string{}, new: map[string]string{"title": "foo"}, expect: []string{"title"}},
+		{name: "custom", tracked: "status, endTime", old: map[string]string{"title": "foo", "endTime": "a"},
+			new: map[string]string{"title": "bar", "endTime": "b"}, expect: []string{"endTime"}},
+		{name: "bookkeeping tracked", tracked: "status, lastAttemptAt, overdueNotifiedAt", old: map[string]string{"status": "a", "lastAttemptAt": "a"},
+			new: map[string]string{"status": "a", "lastAttemptAt": "b", "overdueNotifiedAt": "b"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			if tc.tracked != "" {
+				os.Setenv("TRACKED_FIELDS", tc.tracked)
+				defer os.Unsetenv("TRACKED_FIELDS")
+			}
+
+			diff := changed(image(tc.old), image(tc.new))
+			if !reflect.DeepEqual(diff, tc.expect) {
+				t.Errorf("expected %v, got %v", tc.expect, diff)
+			}
+		})
+	}
+}
+
+func TestCheckStreamView(t *testing.T) {
+
+	tt := []struct {
+		view string
+		ok   bool
+	}{
+		{view: "NEW_AND_OLD_IMAGES", ok: true},
+		{view: "NEW_IMAGE"},
+		{view: "KEYS_ONLY"},
+	}
+
+	for _, tc := range tt {
+		rs := []events.DynamoDBEventRecord{{Change: events.DynamoDBStreamRecord{StreamViewType: tc.view}}}
+		err := checkStreamView(rs)
+		if (err == nil) != tc.ok {
+			t.Errorf("%v: expected ok %v, got %v", tc.view, tc.ok, err)
+		}
+	}
+
+	_, err := Handler(context.Background(), events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+		{EventName: "MODIFY", Change: events.DynamoDBStreamRecord{StreamViewType: "NEW_IMAGE"}},
+	}})
+	if err == nil || !strings.Contains(err.Error(), "needs NEW_AND_OLD_IMAGES") {
+		t.Errorf("expected the handler to refuse a NEW_IMAGE stream, got: %v", err)
+	}
+}
+
+// image builds a stream image from string attributes
+func image(attrs map[string]string) map[string]events.DynamoDBAttributeValue {
+
+	av := make(map[string]events.DynamoDBAttributeValue)
+	for k, v := range attrs {
+		av[k] = events.NewStringAttribute(v)
+	}
+	return av
+}
@@ -1,26 +1,65 @@
 package notifier
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"strings"
 
+	"github.com/UKHomeOffice/snow-forwarder/internal/redact"
 	"github.com/aws/aws-lambda-go/events"
 )
 
+// SNOW message types
+const (
+	createMsgID = "HO_SIAM_IN_REST_CHG_POST_JSON"
+	updateMsgID = "HO_SIAM_IN_REST_CHG_UPDATE_JSON"
+)
+
+// Change lifecycle events, used by sinks that don't speak SNOW messages
+const (
+	EventScheduled   = "scheduled"
+	EventRescheduled = "rescheduled"
+	EventUpdated     = "updated"
+	EventStarted     = "started"
+	EventCompleted   = "completed"
+	EventCancelled   = "cancelled"
+	EventOverdue     = "overdue"
+)
+
 // Payload is the message body
 type Payload struct {
-	SupplierRef string `json:"supplierRef"`
-	Status      string `json:"status"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	StartTime   string `json:"startTime"`
-	EndTime     string `json:"endTime"`
-	Success     string `json:"success,omitempty"`
+	SupplierRef string   `json:"supplierRef"`
+	Status      string   `json:"status"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	StartTime   string   `json:"startTime"`
+	EndTime     string   `json:"endTime"`
+	Success     string   `json:"success,omitempty"`
+	ChangeType  string   `json:"changeType,omitempty"`
+	Category    string   `json:"category,omitempty"`
+	Template    string   `json:"standardTemplate,omitempty"`
+	ConfigItem  string   `json:"configurationItem,omitempty"`
+	ConfigItems []string `json:"configurationItems,omitempty"`
 }
 
 // Message represents a change event
 type Message struct {
 	MessageID string `json:"messageid"`
 	IntID     string `json:"internal_identifier,omitempty"`
+	Event     string `json:"-"`
+	Approval  string `json:"-"`
+	// SkipScheduled lets a change new to SNOW be raised past Scheduled
+	SkipScheduled bool `json:"-"`
+	// Components and Assets are resolved to SNOW CIs by the SNOW sink
+	Components []string `json:"-"`
+	Assets     []string `json:"-"`
+	// Sequence is the stream record's sequence number, so a change that
+	// returns to an earlier state is delivered again
+	Sequence string `json:"-"`
 
 	Payload `json:"payload"`
 }
@@ -31,6 +70,77 @@ type Response struct {
 	IntIdent    string `json:"internal_identifier"`
 }
 
+// NewCreate builds a create for a change SNOW doesn't hold yet
+func NewCreate(p Payload) *Message {
+	return &Message{
+		MessageID: createMsgID,
+		Event:     EventScheduled,
+		Payload:   p,
+	}
+}
+
+// NewUpdate builds an update for a change SNOW already holds, for jobs that
+// re-send a change outside the stream
+func NewUpdate(intID string, p Payload) *Message {
+
+	if p.Status == "In Progress" || p.Status == "Completed" {
+		p.Success = "true"
+	}
+	return &Message{
+		MessageID: updateMsgID,
+		IntID:     intID,
+		Event:     EventUpdated,
+		Payload:   p,
+	}
+}
+
+// NewClose builds an update completing a change in SNOW, successfully or not
+func NewClose(intID string, p Payload, success bool) *Message {
+
+	p.Status = "Completed"
+	p.Success = strconv.FormatBool(success)
+	return &Message{
+		MessageID: updateMsgID,
+		IntID:     intID,
+		Event:     EventCompleted,
+		Payload:   p,
+	}
+}
+
+// attr returns a string attribute from a stream image, or an empty string
+// when the attribute is missing
+func attr(image map[string]events.DynamoDBAttributeValue, name string) string {
+
+	v, ok := image[name]
+	if !ok || v.DataType() != events.DataTypeString {
+		return ""
+	}
+	return v.String()
+}
+
+// list returns a list or string set attribute from a stream image
+func list(image map[string]events.DynamoDBAttributeValue, name string) []string {
+
+	v, ok := image[name]
+	if !ok {
+		return nil
+	}
+
+	switch v.DataType() {
+	case events.DataTypeStringSet:
+		return v.StringSet()
+	case events.DataTypeList:
+		var l []string
+		for _, e := range v.List() {
+			if e.DataType() == events.DataTypeString {
+				l = append(l, e.String())
+			}
+		}
+		return l
+	}
+	return nil
+}
+
 // SetMsg adds a message header
 func (p *Payload) SetMsg(record *events.DynamoDBEventRecord) (*Message, error) {
 
@@ -43,81 +153,244 @@ func (p *Payload) SetMsg(record *events.DynamoDBEventRecord) (*Message, error) {
 	}
 	log.Printf("processing DynamoDB event ID %s, type %s.\n", record.EventID, record.EventName)
 
+	status := attr(record.Change.NewImage, "status")
+
+	// a change held for approval is released once SNOW approves it, as it
+	// now stands, whatever else changed with it
+	if record.EventName == "MODIFY" && released(record) {
+		log.Printf("releasing %s, approved in SNOW\n", attr(record.Change.NewImage, "supplierRef"))
+		p.Success = "true"
+		m = Message{
+			MessageID: updateMsgID,
+			IntID:     attr(record.Change.NewImage, "internal_identifier"),
+			Event:     EventStarted,
+			Approval:  attr(record.Change.NewImage, "approval"),
+			Payload:   *p,
+		}
+		if status == "Completed" {
+			m.Event = EventCompleted
+		}
+		return &m, nil
+	}
+
+	// skip modifications that SNOW doesn't care about, including our own
+	// bookkeeping writes, which needs the old image
+	var diff []string
+	if record.EventName == "MODIFY" {
+		if len(record.Change.OldImage) == 0 {
+			return nil, fmt.Errorf("no old image for %s, the stream must be NEW_AND_OLD_IMAGES", attr(record.Change.NewImage, "supplierRef"))
+		}
+		diff = changed(record.Change.OldImage, record.Change.NewImage)
+		if len(diff) == 0 {
+			log.Printf("ignoring event for %s, no tracked fields changed\n", attr(record.Change.NewImage, "supplierRef"))
+			return &m, nil
+		}
+		log.Printf("tracked fields changed for %s: %s\n", attr(record.Change.NewImage, "supplierRef"), strings.Join(diff, ", "))
+	}
+
+	// status is new unless the old image shows otherwise
+	moved := diff == nil || contains(diff, "status")
+
 	// construct payloads
-	if record.Change.NewImage["status"].String() == "In Progress" || record.Change.NewImage["status"].String() == "Completed" {
+	if status == "In Progress" || status == "Completed" {
 		p.Success = "true"
 		m = Message{
-			MessageID: "HO_SIAM_IN_REST_CHG_UPDATE_JSON",
-			IntID:     record.Change.NewImage["internal_identifier"].String(),
+			MessageID: updateMsgID,
+			IntID:     attr(record.Change.NewImage, "internal_identifier"),
+			Event:     EventStarted,
+			Payload:   *p,
+		}
+		if status == "Completed" {
+			m.Event = EventCompleted
+		}
+		if !moved {
+			m.Event = EventUpdated
+		}
+		m.Approval = attr(record.Change.NewImage, "approval")
+		return &m, nil
+	} else if record.EventName == "INSERT" && status == "Scheduled" {
+		m = Message{
+			MessageID: createMsgID,
+			Event:     EventScheduled,
 			Payload:   *p,
 		}
 		return &m, nil
-	} else if record.EventName == "INSERT" && record.Change.NewImage["status"].String() == "Scheduled" {
+	} else if record.EventName == "MODIFY" && status == "Scheduled" {
+		// rescheduled window or new title on a change that hasn't started
 		m = Message{
-			MessageID: "HO_SIAM_IN_REST_CHG_POST_JSON",
+			MessageID: updateMsgID,
+			IntID:     attr(record.Change.NewImage, "internal_identifier"),
+			Event:     EventUpdated,
 			Payload:   *p,
 		}
+		if moved {
+			m.Event = EventScheduled
+		} else if contains(diff, "startTime") || contains(diff, "endTime") {
+			m.Event = EventRescheduled
+		}
+		return &m, nil
+	} else if moved && (status == "Cancelled" || status == "Canceled") {
+		// SNOW isn't told about cancellations, other sinks may be
+		m = Message{
+			IntID:   attr(record.Change.NewImage, "internal_identifier"),
+			Event:   EventCancelled,
+			Payload: *p,
+		}
 		return &m, nil
 	} else {
-		log.Printf("ignoring event for %s, status: %s\n", record.Change.NewImage["supplierRef"].String(), record.Change.NewImage["status"].String())
+		log.Printf("ignoring event for %s, status: %s\n", attr(record.Change.NewImage, "supplierRef"), status)
 		return &m, nil
 	}
 }
 
-// Handler receives a DynamoDB stream and forwards the message on to SNOW
-func Handler(e events.DynamoDBEvent) error {
+// Handler receives a DynamoDB stream and forwards the message on to the
+// configured sinks. Any failure retries the whole batch unless
+// REPORT_BATCH_ITEM_FAILURES=true, which the event source mapping must also
+// enable. Lambda then retries from the earliest failed or unstarted record,
+// re-delivering every record after it, so the ledger is required to skip
+// those already sent.
+func Handler(ctx context.Context, e events.DynamoDBEvent) (events.DynamoDBEventResponse, error) {
 
-	for _, record := range e.Records {
+	var resp events.DynamoDBEventResponse
 
-		// get relevant values from stream event
-		p := Payload{
-			SupplierRef: record.Change.NewImage["supplierRef"].String(),
-			Status:      record.Change.NewImage["status"].String(),
-			Title:       record.Change.NewImage["title"].String(),
-			Description: record.Change.NewImage["description"].String(),
-			StartTime:   record.Change.NewImage["startTime"].String(),
-			EndTime:     record.Change.NewImage["endTime"].String(),
-		}
+	err := checkStreamView(e.Records)
+	if err != nil {
+		log.Println(err)
+		return resp, err
+	}
 
-		m, err := p.SetMsg(&record)
-		if err != nil {
-			return err
-		}
+	sinks, err := newSinks()
+	if err != nil {
+		log.Printf("could not set up sinks: %v", err)
+		return resp, err
+	}
 
-		if m.MessageID == "" {
-			log.Println("event ignored, exiting")
-			return nil
-		}
+	ledger, err := newLedger()
+	if err != nil {
+		log.Printf("could not set up idempotency ledger: %v", err)
+		return resp, err
+	}
 
-		// call SNOW and expect internal_identifer in return
-		intid, err := m.Notify()
-		if err != nil {
-			log.Printf("could not call notify: %v", err)
-			return err
-		}
+	if ledger == nil && fansOut(sinks) {
+		err = errors.New("sending to more than one sink or webhook needs IDEMPOTENCY_TABLE, or a failure repeats the deliveries that succeeded")
+		log.Println(err)
+		return resp, err
+	}
 
-		if intid == "" {
-			log.Printf("notify didn't return a new Change ID, exiting")
-			return nil
-		}
+	report, err := reportFailures(ledger)
+	if err != nil {
+		return resp, err
+	}
 
-		// add internal_identifier to db record
-		ur := Response{
-			SupplierRef: p.SupplierRef,
-			IntIdent:    intid,
-		}
+	n, err := concurrency()
+	if err != nil {
+		return resp, err
+	}
 
-		db, err := newDB()
-		if err != nil {
-			log.Printf("could not create db session: %v", err)
-			return err
-		}
+	margin, err := deadlineMargin()
+	if err != nil {
+		return resp, err
+	}
+
+	models, err := changeModels()
+	if err != nil {
+		return resp, err
+	}
+
+	retry, err := run(ctx, group(e.Records), n, margin, func(ctx context.Context, record *events.DynamoDBEventRecord) error {
+		return handle(ctx, record, sinks, ledger, models)
+	})
+	if err == nil {
+		return resp, nil
+	}
+
+	// Lambda logs the returned error as is
+	err = redact.Error(err)
+	if !report {
+		return resp, err
+	}
+
+	first := earliest(retry)
+	log.Printf("retrying from %v, %v records failed or unstarted: %v", first.Change.SequenceNumber, len(retry), err)
+	resp.BatchItemFailures = []events.DynamoDBBatchItemFailure{{ItemIdentifier: first.Change.SequenceNumber}}
+	return resp, nil
+}
 
-		_, err = db.AddID(&ur)
-		if err != nil {
-			log.Printf("could not update db with internal identifier: %v", err)
-			return err
+// reportFailures reads REPORT_BATCH_ITEM_FAILURES, which needs a ledger as
+// records after a failed one are delivered to the handler again
+func reportFailures(l *Ledger) (bool, error) {
+
+	if os.Getenv("REPORT_BATCH_ITEM_FAILURES") != "true" {
+		return false, nil
+	}
+	if l == nil {
+		return false, errors.New("REPORT_BATCH_ITEM_FAILURES needs IDEMPOTENCY_TABLE, records after a failed one are sent again")
+	}
+	return true, nil
+}
+
+// earliest returns the record with the lowest sequence number
+func earliest(records []*events.DynamoDBEventRecord) *events.DynamoDBEventRecord {
+
+	var first *events.DynamoDBEventRecord
+	for _, r := range records {
+		if first == nil || SequenceBefore(r.Change.SequenceNumber, first.Change.SequenceNumber) {
+			first = r
 		}
 	}
-	return nil
+	return first
+}
+
+// SequenceBefore reports whether stream sequence number a comes before b.
+// Sequence numbers are decimal strings of varying length.
+func SequenceBefore(a, b string) bool {
+	return len(a) < len(b) || (len(a) == len(b) && a < b)
+}
+
+// handle forwards a single stream record
+func handle(ctx context.Context, record *events.DynamoDBEventRecord, sinks []Sink, ledger *Ledger, models []modelRule) error {
+
+	// get relevant values from stream event
+	p := Payload{
+		SupplierRef: attr(record.Change.NewImage, "supplierRef"),
+		Status:      attr(record.Change.NewImage, "status"),
+		Title:       attr(record.Change.NewImage, "title"),
+		Description: attr(record.Change.NewImage, "description"),
+		StartTime:   attr(record.Change.NewImage, "startTime"),
+		EndTime:     attr(record.Change.NewImage, "endTime"),
+	}
+
+	m, err := p.SetMsg(record)
+	if err != nil {
+		return err
+	}
+
+	if m.Event == "" {
+		log.Println("event ignored")
+		return nil
+	}
+
+	m.Sequence = record.Change.SequenceNumber
+	m.Components = list(record.Change.NewImage, "components")
+	m.Assets = list(record.Change.NewImage, "assets")
+
+	issueType := attr(record.Change.NewImage, "issueType")
+	if md, ok := pickModel(models, issueType, attr(record.Change.NewImage, "requestType"), list(record.Change.NewImage, "labels")); ok {
+		log.Printf("sending %v as a %v change", p.SupplierRef, md.Type)
+		m.SetModel(md)
+	} else if len(models) > 0 {
+		log.Printf("no change model for %v, issue type %q", p.SupplierRef, issueType)
+	}
+
+	ok, err := gate(ctx, m, storeOf(sinks))
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return nil
+	}
+
+	_, err = deliver(ctx, sinks, ledger, m)
+	return err
 }
@@ -1,9 +1,17 @@
 package notifier
 
 import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 )
 
 func TestSetMsg(t *testing.T) {
@@ -11,14 +19,17 @@ func TestSetMsg(t *testing.T) {
 	tt := []struct {
 		name          string
 		event         string
+		old           string
 		status        string
 		expect        string
 		expectSuccess string
 	}{
 		{name: "create", event: "INSERT", status: "Scheduled", expect: "HO_SIAM_IN_REST_CHG_POST_JSON", expectSuccess: ""},
-		{name: "update", event: "MODIFY", status: "In Progress", expect: "HO_SIAM_IN_REST_CHG_UPDATE_JSON", expectSuccess: "true"},
-		{name: "complete", event: "MODIFY", status: "Completed", expect: "HO_SIAM_IN_REST_CHG_UPDATE_JSON", expectSuccess: "true"},
+		{name: "update", event: "MODIFY", old: "Scheduled", status: "In Progress", expect: "HO_SIAM_IN_REST_CHG_UPDATE_JSON", expectSuccess: "true"},
+		{name: "complete", event: "MODIFY", old: "In Progress", status: "Completed", expect: "HO_SIAM_IN_REST_CHG_UPDATE_JSON", expectSuccess: "true"},
 		{name: "delete", event: "REMOVE", expect: "", expectSuccess: ""},
+		{name: "late", event: "INSERT", status: "In Progress", expect: "HO_SIAM_IN_REST_CHG_UPDATE_JSON", expectSuccess: "true"},
+		{name: "other", event: "INSERT", status: "Open", expect: "", expectSuccess: ""},
 	}
 
 	for _, tc := range tt {
@@ -31,6 +42,9 @@ func TestSetMsg(t *testing.T) {
 			change := &events.DynamoDBStreamRecord{
 				NewImage: av,
 			}
+			if tc.old != "" {
+				change.OldImage = image(map[string]string{"status": tc.old})
+			}
 
 			event := &events.DynamoDBEventRecord{
 				EventName: tc.event,
@@ -53,3 +67,209 @@ func TestSetMsg(t *testing.T) {
 		})
 	}
 }
+
+func TestSetMsgModify(t *testing.T) {
+
+	tt := []struct {
+		name   string
+		old    map[string]string
+		new    map[string]string
+		expect string
+		event  string
+		err    string
+	}{
+		{name: "own write", old: map[string]string{"status": "In Progress"},
+			new: map[string]string{"status": "In Progress", "internal_identifier": "CHG001"}, expect: ""},
+		{name: "duplicate", old: map[string]string{"status": "Completed"}, new: map[string]string{"status": "Completed"}, expect: ""},
+		{name: "reschedule", old: map[string]string{"status": "Scheduled", "startTime": "a"},
+			new: map[string]string{"status": "Scheduled", "startTime": "b", "internal_identifier": "CHG001"}, expect: updateMsgID, event: EventRescheduled},
+		{name: "renamed", old: map[string]string{"status": "In Progress", "title": "a"},
+			new: map[string]string{"status": "In Progress", "title": "b", "internal_identifier": "CHG001"}, expect: updateMsgID, event: EventUpdated},
+		{name: "started", old: map[string]string{"status": "Scheduled"}, new: map[string]string{"status": "In Progress"}, expect: updateMsgID, event: EventStarted},
+		{name: "completed", old: map[string]string{"status": "In Progress"}, new: map[string]string{"status": "Completed"}, expect: updateMsgID, event: EventCompleted},
+		{name: "cancelled", old: map[string]string{"status": "Scheduled"}, new: map[string]string{"status": "Cancelled"}, expect: "", event: EventCancelled},
+		{name: "untracked status", old: map[string]string{"status": "Scheduled"}, new: map[string]string{"status": "Open"}, expect: ""},
+		{name: "bookkeeping", old: map[string]string{"status": "In Progress", "lastAttemptAt": "a", "snowResponse": "a"},
+			new: map[string]string{"status": "In Progress", "lastAttemptAt": "b", "lastMessageType": updateMsgID, "snowResponse": "b",
+				"overdueNotifiedAt": "b", "internal_identifier": "CHG001"}, expect: ""},
+		{name: "no old image", new: map[string]string{"status": "In Progress"}, err: "the stream must be NEW_AND_OLD_IMAGES"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			event := &events.DynamoDBEventRecord{
+				EventName: "MODIFY",
+				Change: events.DynamoDBStreamRecord{
+					OldImage: image(tc.old),
+					NewImage: image(tc.new),
+				},
+			}
+
+			p := Payload{}
+			msg, err := p.SetMsg(event)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if msg.MessageID != tc.expect {
+				t.Errorf("expected MessageID %q, got %q", tc.expect, msg.MessageID)
+			}
+			if msg.Event != tc.event {
+				t.Errorf("expected Event %q, got %q", tc.event, msg.Event)
+			}
+			if msg.MessageID != "" && msg.IntID != tc.new["internal_identifier"] {
+				t.Errorf("expected IntID %q, got %q", tc.new["internal_identifier"], msg.IntID)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+
+	img := map[string]events.DynamoDBAttributeValue{
+		"labels": events.NewListAttribute([]events.DynamoDBAttributeValue{
+			events.NewStringAttribute("standard"), events.NewNumberAttribute("1"), events.NewStringAttribute("certs"),
+		}),
+		"tags":   events.NewStringSetAttribute([]string{"prod"}),
+		"status": events.NewStringAttribute("Scheduled"),
+		"none":   events.NewNullAttribute(),
+	}
+
+	tt := []struct {
+		name   string
+		expect []string
+	}{
+		{name: "labels", expect: []string{"standard", "certs"}},
+		{name: "tags", expect: []string{"prod"}},
+		{name: "status"},
+		{name: "none"},
+		{name: "missing"},
+	}
+
+	for _, tc := range tt {
+		got := list(img, tc.name)
+		if !reflect.DeepEqual(got, tc.expect) {
+			t.Errorf("%v: expected %v, got %v", tc.name, tc.expect, got)
+		}
+	}
+}
+
+func TestReportFailures(t *testing.T) {
+
+	tt := []struct {
+		name   string
+		env    string
+		ledger *Ledger
+		expect bool
+		err    string
+	}{
+		{name: "off"},
+		{name: "off without ledger", env: "false"},
+		{name: "on", env: "true", ledger: &Ledger{}, expect: true},
+		{name: "on without ledger", env: "true", err: "needs IDEMPOTENCY_TABLE"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			os.Setenv("REPORT_BATCH_ITEM_FAILURES", tc.env)
+			defer os.Unsetenv("REPORT_BATCH_ITEM_FAILURES")
+
+			got, err := reportFailures(tc.ledger)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil || got != tc.expect {
+				t.Errorf("expected %v, got %v: %v", tc.expect, got, err)
+			}
+		})
+	}
+}
+
+func TestEarliest(t *testing.T) {
+
+	var rs []*events.DynamoDBEventRecord
+	for _, seq := range []string{"300", "1000", "200", "250"} {
+		rs = append(rs, &events.DynamoDBEventRecord{Change: events.DynamoDBStreamRecord{SequenceNumber: seq}})
+	}
+
+	if got := earliest(rs).Change.SequenceNumber; got != "200" {
+		t.Errorf("expected 200, got %v", got)
+	}
+}
+
+// flakySink fails deliveries for the changes in fail
+type flakySink struct {
+	fakeSink
+	fail map[string]bool
+}
+
+func (fs *flakySink) Deliver(ctx context.Context, m *Message) (*Result, error) {
+	if fs.fail[m.SupplierRef] {
+		return nil, errors.New("boom")
+	}
+	return fs.fakeSink.Deliver(ctx, m)
+}
+
+func TestRetryFromEarliest(t *testing.T) {
+
+	now := time.Now()
+	l := &Ledger{
+		DynamoDB: &mockLedgerDB{claims: make(map[string]map[string]*dynamodb.AttributeValue)},
+		Table:    "idempotency",
+		TTL:      time.Hour,
+		Lock:     time.Minute,
+		now:      func() time.Time { return now },
+	}
+
+	var rs []events.DynamoDBEventRecord
+	for i, ref := range []string{"a", "b"} {
+		rs = append(rs, events.DynamoDBEventRecord{
+			EventName: "INSERT",
+			Change: events.DynamoDBStreamRecord{
+				SequenceNumber: strconv.Itoa(100 * (i + 1)),
+				Keys:           image(map[string]string{"supplierRef": ref}),
+				NewImage:       image(map[string]string{"supplierRef": ref, "status": "Scheduled"}),
+			},
+		})
+	}
+
+	s := &flakySink{fakeSink: fakeSink{name: "chat"}, fail: map[string]bool{"a": true}}
+	fn := func(ctx context.Context, r *events.DynamoDBEventRecord) error {
+		return handle(ctx, r, []Sink{s}, l, nil)
+	}
+
+	retry, err := run(context.Background(), group(rs), 1, 0, fn)
+	if err == nil || len(retry) != 1 {
+		t.Fatalf("expected a to fail, got %v: %v", len(retry), err)
+	}
+
+	// Lambda re-delivers everything from the earliest failure, including b
+	first := earliest(retry).Change.SequenceNumber
+	if first != "100" {
+		t.Fatalf("expected to retry from 100, got %v", first)
+	}
+
+	s.fail = nil
+	if _, err := run(context.Background(), group(rs), 1, 0, fn); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var sent []string
+	for _, m := range s.got {
+		sent = append(sent, m.SupplierRef)
+	}
+	if expect := []string{"b", "a"}; !reflect.DeepEqual(sent, expect) {
+		t.Errorf("expected b to be sent once, got %v", sent)
+	}
+}
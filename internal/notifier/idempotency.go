@@ -0,0 +1,242 @@
+package notifier
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// ledger defaults, overridden by IDEMPOTENCY_TTL and IDEMPOTENCY_LOCK
+const (
+	defaultLedgerTTL  = 7 * 24 * time.Hour
+	defaultLedgerLock = 15 * time.Minute
+)
+
+// claim states
+const (
+	claimPending = "pending"
+	claimDone    = "done"
+)
+
+// ErrInFlight is returned when another invocation is delivering the same
+// transition, so the stream should retry later
+var ErrInFlight = errors.New("delivery already in progress")
+
+// Ledger records which transitions have been delivered, using conditional
+// writes so each is delivered successfully at most once
+type Ledger struct {
+	DynamoDB dynamodbiface.DynamoDBAPI
+	Table    string
+	TTL      time.Duration
+	Lock     time.Duration
+	now      func() time.Time
+}
+
+// newLedger returns nil when no IDEMPOTENCY_TABLE is configured
+func newLedger() (*Ledger, error) {
+
+	tab, ok := os.LookupEnv("IDEMPOTENCY_TABLE")
+	if !ok || tab == "" {
+		return nil, nil
+	}
+
+	l := &Ledger{Table: tab, TTL: defaultLedgerTTL, Lock: defaultLedgerLock, now: time.Now}
+
+	if v, ok := os.LookupEnv("IDEMPOTENCY_TTL"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IDEMPOTENCY_TTL %q", v)
+		}
+		l.TTL = d
+	}
+
+	if v, ok := os.LookupEnv("IDEMPOTENCY_LOCK"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IDEMPOTENCY_LOCK %q", v)
+		}
+		l.Lock = d
+	}
+
+	db, err := newDB()
+	if err != nil {
+		return nil, err
+	}
+	l.DynamoDB = db.DynamoDB
+	return l, nil
+}
+
+// idempotencyKey identifies a transition for one sink by change, status and
+// a hash of the content being sent and the stream record it came from. A
+// retried record has the same key, a change going A to B and back to A
+// doesn't.
+func idempotencyKey(sink string, m *Message) (string, error) {
+
+	content := struct {
+		MessageID string  `json:"messageid"`
+		Event     string  `json:"event"`
+		Sequence  string  `json:"sequence,omitempty"`
+		Payload   Payload `json:"payload"`
+	}{m.MessageID, m.Event, m.Sequence, m.Payload}
+
+	b, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return sink + "#" + m.SupplierRef + "#" + m.Status + "#" + hex.EncodeToString(sum[:])[:32], nil
+}
+
+// Claim reserves a transition for delivery. It returns false if it has
+// already been delivered, and ErrInFlight if another delivery holds it.
+func (l *Ledger) Claim(ctx context.Context, key string) (bool, error) {
+
+	now := l.now()
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(l.Table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"idempotencyKey": {S: aws.String(key)},
+			"state":          {S: aws.String(claimPending)},
+			"claimedAt":      {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+			"expiresAt":      {N: aws.String(strconv.FormatInt(now.Add(l.TTL).Unix(), 10))},
+		},
+		// free, or pending for longer than a delivery can take
+		ConditionExpression: aws.String("attribute_not_exists(idempotencyKey) OR (#S = :pending AND claimedAt < :stale)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#S": aws.String("state"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pending": {S: aws.String(claimPending)},
+			":stale":   {N: aws.String(strconv.FormatInt(now.Add(-l.Lock).Unix(), 10))},
+		},
+	}
+
+	_, err := l.DynamoDB.PutItemWithContext(ctx, input)
+	if err == nil {
+		return true, nil
+	}
+
+	aerr, ok := err.(awserr.Error)
+	if !ok || aerr.Code() != dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, err
+	}
+
+	state, err := l.state(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	if state == claimDone {
+		return false, nil
+	}
+	return false, ErrInFlight
+}
+
+// Complete marks a claimed transition as delivered
+func (l *Ledger) Complete(ctx context.Context, key string) error {
+
+	input := &dynamodb.UpdateItemInput{
+		TableName:        aws.String(l.Table),
+		UpdateExpression: aws.String("SET #S = :done"),
+		ExpressionAttributeNames: map[string]*string{
+			"#S": aws.String("state"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":done": {S: aws.String(claimDone)},
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"idempotencyKey": {S: aws.String(key)},
+		},
+	}
+
+	_, err := l.DynamoDB.UpdateItemWithContext(ctx, input)
+	return err
+}
+
+// Release gives up a claim after a failed delivery so a retry can take it
+func (l *Ledger) Release(ctx context.Context, key string) error {
+
+	input := &dynamodb.DeleteItemInput{
+		TableName: aws.String(l.Table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"idempotencyKey": {S: aws.String(key)},
+		},
+	}
+
+	_, err := l.DynamoDB.DeleteItemWithContext(ctx, input)
+	return err
+}
+
+// state reads the current state of a claim
+func (l *Ledger) state(ctx context.Context, key string) (string, error) {
+
+	input := &dynamodb.GetItemInput{
+		TableName:      aws.String(l.Table),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]*dynamodb.AttributeValue{
+			"idempotencyKey": {S: aws.String(key)},
+		},
+	}
+
+	out, err := l.DynamoDB.GetItemWithContext(ctx, input)
+	if err != nil {
+		return "", err
+	}
+
+	if v, ok := out.Item["state"]; ok && v.S != nil {
+		return *v.S, nil
+	}
+	return "", nil
+}
+
+// once delivers a message to a sink unless the ledger shows it already has
+// been. A reply from SNOW counts as delivered even if later bookkeeping
+// failed, so a retried INSERT can't create a second change.
+func (l *Ledger) once(ctx context.Context, s Sink, m *Message) (*Result, error) {
+
+	if l == nil {
+		return s.Deliver(ctx, m)
+	}
+
+	key, err := idempotencyKey(s.Name(), m)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := l.Claim(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		log.Printf("%v already delivered to %v, skipping", m.SupplierRef, s.Name())
+		return &Result{Outcome: OutcomeSkipped, IntIdent: m.IntID, Message: "already delivered"}, nil
+	}
+
+	res, err := s.Deliver(ctx, m)
+
+	if res != nil && res.Outcome != OutcomeError {
+		if cerr := l.Complete(ctx, key); cerr != nil {
+			log.Printf("could not mark %v delivered: %v", key, cerr)
+		}
+		return res, err
+	}
+
+	if rerr := l.Release(ctx, key); rerr != nil {
+		log.Printf("could not release %v: %v", key, rerr)
+	}
+	return res, err
+}
@@ -0,0 +1,156 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// mockLedgerDB keeps claims in memory and applies the claim condition
+type mockLedgerDB struct {
+	dynamodbiface.DynamoDBAPI
+	mu     sync.Mutex
+	claims map[string]map[string]*dynamodb.AttributeValue
+}
+
+func (ml *mockLedgerDB) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	key := *input.Item["idempotencyKey"].S
+	if cur, ok := ml.claims[key]; ok {
+		claimed, _ := strconv.ParseInt(*cur["claimedAt"].N, 10, 64)
+		stale, _ := strconv.ParseInt(*input.ExpressionAttributeValues[":stale"].N, 10, 64)
+		if *cur["state"].S != claimPending || claimed >= stale {
+			return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "conditional request failed", nil)
+		}
+	}
+	ml.claims[key] = input.Item
+	return new(dynamodb.PutItemOutput), nil
+}
+
+func (ml *mockLedgerDB) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	return &dynamodb.GetItemOutput{Item: ml.claims[*input.Key["idempotencyKey"].S]}, nil
+}
+
+func (ml *mockLedgerDB) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.claims[*input.Key["idempotencyKey"].S]["state"] = &dynamodb.AttributeValue{S: aws.String(claimDone)}
+	return new(dynamodb.UpdateItemOutput), nil
+}
+
+func (ml *mockLedgerDB) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	delete(ml.claims, *input.Key["idempotencyKey"].S)
+	return new(dynamodb.DeleteItemOutput), nil
+}
+
+func TestIdempotencyKey(t *testing.T) {
+
+	m := Message{MessageID: updateMsgID, Event: EventStarted, Payload: Payload{SupplierRef: "abc-1", Status: "In Progress", Title: "foo"}}
+	a, _ := idempotencyKey("snow", &m)
+	b, _ := idempotencyKey("snow", &m)
+	if a != b {
+		t.Errorf("expected deterministic key, got %v and %v", a, b)
+	}
+
+	m.IntID = "CHG001"
+	if c, _ := idempotencyKey("snow", &m); c != a {
+		t.Errorf("expected key to ignore internal_identifier, got %v", c)
+	}
+
+	if c, _ := idempotencyKey("chat", &m); c == a {
+		t.Errorf("expected key to differ per sink")
+	}
+
+	m.Title = "bar"
+	if c, _ := idempotencyKey("snow", &m); c == a {
+		t.Errorf("expected key to change with content")
+	}
+
+	// the same content again from a later stream record is a new transition
+	m.Title = "foo"
+	m.Sequence = "100"
+	first, _ := idempotencyKey("snow", &m)
+	if first == a {
+		t.Errorf("expected key to change with the stream record")
+	}
+	m.Sequence = "300"
+	if c, _ := idempotencyKey("snow", &m); c == first {
+		t.Errorf("expected a change back to an earlier state to get a new key")
+	}
+	m.Sequence = "100"
+	if c, _ := idempotencyKey("snow", &m); c != first {
+		t.Errorf("expected a retried record to keep its key, got %v", c)
+	}
+}
+
+func TestLedgerOnce(t *testing.T) {
+
+	now := time.Now()
+	l := &Ledger{
+		DynamoDB: &mockLedgerDB{claims: make(map[string]map[string]*dynamodb.AttributeValue)},
+		Table:    "idempotency",
+		TTL:      time.Hour,
+		Lock:     time.Minute,
+		now:      func() time.Time { return now },
+	}
+
+	m := Message{MessageID: createMsgID, Event: EventScheduled, Payload: Payload{SupplierRef: "abc-1", Status: "Scheduled"}}
+
+	// a failed delivery can be retried
+	bad := &fakeSink{name: "snow", err: errors.New("boom")}
+	if _, err := l.once(context.Background(), bad, &m); err == nil {
+		t.Fatalf("expected delivery error")
+	}
+
+	good := &fakeSink{name: "snow"}
+	res, err := l.once(context.Background(), good, &m)
+	if err != nil || res.Outcome != OutcomeUpdated {
+		t.Fatalf("expected delivery after failed attempt, got %v: %v", res, err)
+	}
+
+	// a retried stream record is delivered only once
+	res, err = l.once(context.Background(), good, &m)
+	if err != nil || res.Outcome != OutcomeSkipped {
+		t.Errorf("expected skip for delivered transition, got %v: %v", res, err)
+	}
+	if len(good.got) != 1 {
+		t.Errorf("expected one delivery, got %v", len(good.got))
+	}
+
+	// a claim held by another invocation blocks until it goes stale
+	other := Message{MessageID: updateMsgID, Event: EventStarted, Payload: Payload{SupplierRef: "abc-1", Status: "In Progress"}}
+	key, _ := idempotencyKey("snow", &other)
+	if ok, err := l.Claim(context.Background(), key); !ok || err != nil {
+		t.Fatalf("expected claim, got %v: %v", ok, err)
+	}
+	if _, err := l.once(context.Background(), good, &other); err != ErrInFlight {
+		t.Errorf("expected ErrInFlight, got: %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := l.once(context.Background(), good, &other); err != nil {
+		t.Errorf("expected stale claim to be taken over, got: %v", err)
+	}
+
+	// without a ledger everything is delivered
+	var none *Ledger
+	none.once(context.Background(), good, &m)
+	if len(good.got) != 3 {
+		t.Errorf("expected delivery without ledger, got %v deliveries", len(good.got))
+	}
+}
@@ -0,0 +1,122 @@
+package notifier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/jsd"
+)
+
+// SNOW change types
+const (
+	ChangeStandard  = "standard"
+	ChangeNormal    = "normal"
+	ChangeEmergency = "emergency"
+)
+
+// Model is how a change is raised in SNOW
+type Model struct {
+	Type     string `json:"type"`
+	Category string `json:"category,omitempty"`
+	// Template is the standard change template, required for standard changes
+	Template string `json:"template,omitempty"`
+	// SkipScheduled raises a change that first appears after Scheduled as it
+	// stands, rather than creating it as Scheduled and moving it on
+	SkipScheduled bool `json:"skipScheduled,omitempty"`
+}
+
+// modelRule applies a model to changes matching every criterion it sets.
+// A rule with no criteria matches everything.
+type modelRule struct {
+	IssueType   string   `json:"issueType,omitempty"`
+	RequestType string   `json:"requestType,omitempty"`
+	Labels      []string `json:"labels,omitempty"`
+	Model
+}
+
+// match reports whether a change meets the rule. Labels must all be on
+// the change, in any case.
+func (r *modelRule) match(issueType, requestType string, labels []string) bool {
+
+	if r.IssueType != "" && !strings.EqualFold(r.IssueType, issueType) {
+		return false
+	}
+	if r.RequestType != "" && !strings.EqualFold(r.RequestType, requestType) {
+		return false
+	}
+	for _, l := range r.Labels {
+		if !jsd.ContainsFold(labels, l) {
+			return false
+		}
+	}
+	return true
+}
+
+// changeModels reads CHANGE_MODELS, a JSON list of rules tried in order
+func changeModels() ([]modelRule, error) {
+
+	v, ok := os.LookupEnv("CHANGE_MODELS")
+	if !ok || strings.TrimSpace(v) == "" {
+		return nil, nil
+	}
+
+	var rules []modelRule
+	err := json.Unmarshal([]byte(v), &rules)
+	if err != nil {
+		return nil, errors.New("could not parse CHANGE_MODELS: " + err.Error())
+	}
+
+	for i, r := range rules {
+		switch r.Type {
+		case ChangeNormal:
+		case ChangeStandard:
+			if r.Template == "" {
+				return nil, fmt.Errorf("CHANGE_MODELS rule %v is a standard change without a template", i+1)
+			}
+		case ChangeEmergency:
+		default:
+			return nil, fmt.Errorf("CHANGE_MODELS rule %v has unknown type %q, expected standard, normal or emergency", i+1, r.Type)
+		}
+
+		if r.SkipScheduled && r.Type != ChangeEmergency {
+			return nil, fmt.Errorf("CHANGE_MODELS rule %v skips Scheduled but only emergency changes may", i+1)
+		}
+	}
+	return rules, nil
+}
+
+// pickModel returns the model of the first matching rule, or false when
+// none match and the change goes as it always has
+func pickModel(rules []modelRule, issueType, requestType string, labels []string) (Model, bool) {
+
+	for _, r := range rules {
+		if r.match(issueType, requestType, labels) {
+			return r.Model, true
+		}
+	}
+	return Model{}, false
+}
+
+// ModelFor picks the model from CHANGE_MODELS, for jobs that send outside
+// the stream
+func ModelFor(issueType, requestType string, labels []string) (Model, bool, error) {
+
+	rules, err := changeModels()
+	if err != nil {
+		return Model{}, false, err
+	}
+	md, ok := pickModel(rules, issueType, requestType, labels)
+	return md, ok, nil
+}
+
+// SetModel adds the change type, category and template to the payload
+func (m *Message) SetModel(md Model) {
+
+	m.ChangeType = md.Type
+	m.Category = md.Category
+	m.Template = md.Template
+	m.SkipScheduled = md.SkipScheduled
+}
@@ -0,0 +1,81 @@
+package notifier
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestChangeModels(t *testing.T) {
+
+	tt := []struct {
+		name  string
+		value string
+		rules int
+		err   string
+	}{
+		{name: "unset", value: ""},
+		{name: "valid", value: `[{"issueType":"Emergency Change","type":"emergency","skipScheduled":true},
+			{"labels":["standard"],"type":"standard","template":"STD0001001"},{"type":"normal","category":"Software"}]`, rules: 3},
+		{name: "not json", value: "emergency", err: "could not parse CHANGE_MODELS"},
+		{name: "unknown type", value: `[{"type":"urgent"}]`, err: `unknown type "urgent"`},
+		{name: "standard without template", value: `[{"type":"standard"}]`, err: "without a template"},
+		{name: "normal skipping", value: `[{"type":"normal","skipScheduled":true}]`, err: "only emergency changes may"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			os.Setenv("CHANGE_MODELS", tc.value)
+			defer os.Unsetenv("CHANGE_MODELS")
+
+			rules, err := changeModels()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(rules) != tc.rules {
+				t.Errorf("expected %v rules, got %v", tc.rules, len(rules))
+			}
+		})
+	}
+}
+
+func TestPickModel(t *testing.T) {
+
+	rules := []modelRule{
+		{IssueType: "Emergency Change", Model: Model{Type: ChangeEmergency, SkipScheduled: true}},
+		{RequestType: "Certificate renewal", Labels: []string{"standard", "certs"},
+			Model: Model{Type: ChangeStandard, Category: "Security", Template: "STD0001001"}},
+		{RequestType: "Certificate renewal", Model: Model{Type: ChangeNormal, Category: "Security"}},
+	}
+
+	tt := []struct {
+		name        string
+		issueType   string
+		requestType string
+		labels      []string
+		expect      Model
+		ok          bool
+	}{
+		{name: "issue type", issueType: "emergency change", expect: rules[0].Model, ok: true},
+		{name: "all labels", requestType: "Certificate renewal", labels: []string{"Certs", "standard", "prod"},
+			expect: rules[1].Model, ok: true},
+		{name: "missing label", requestType: "Certificate renewal", labels: []string{"standard"}, expect: rules[2].Model, ok: true},
+		{name: "no match", issueType: "Change", requestType: "Firewall"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			md, ok := pickModel(rules, tc.issueType, tc.requestType, tc.labels)
+			if ok != tc.ok || md != tc.expect {
+				t.Errorf("expected %+v %v, got %+v %v", tc.expect, tc.ok, md, ok)
+			}
+		})
+	}
+}
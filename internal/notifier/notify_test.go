@@ -0,0 +1,102 @@
+package notifier
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/snowmock"
+)
+
+func TestNotify(t *testing.T) {
+
+	create := Message{MessageID: createMsgID, Payload: Payload{SupplierRef: "ACP-1", Status: "Scheduled"}}
+	update := Message{MessageID: updateMsgID, Payload: Payload{SupplierRef: "ACP-1", Status: "In Progress", Success: "true"}}
+
+	tt := []struct {
+		name     string
+		password string
+		steps    []snowmock.Step
+		msgs     []Message
+		outcome  Outcome
+		ident    string
+		requests int
+		err      string
+	}{
+		{name: "create", msgs: []Message{create}, outcome: OutcomeInserted, ident: "CHG0000001", requests: 1},
+		{name: "update", msgs: []Message{create, update}, outcome: OutcomeUpdated, ident: "CHG0000001", requests: 2},
+		{name: "unknown change", msgs: []Message{update}, requests: 1, err: "SNOW rejected message"},
+		{name: "ignored", steps: []snowmock.Step{{Kind: snowmock.KindIgnored}}, msgs: []Message{create},
+			outcome: OutcomeIgnored, requests: 1},
+		{name: "renewed credentials", steps: []snowmock.Step{{Kind: snowmock.KindUnauthorized}}, msgs: []Message{create},
+			outcome: OutcomeInserted, ident: "CHG0000001", requests: 2},
+		{name: "bad credentials", password: "wrong", msgs: []Message{create}, requests: 2, err: "SNOW returned HTTP 401"},
+		{name: "server error", steps: []snowmock.Step{{Kind: snowmock.KindServerError}}, msgs: []Message{create},
+			requests: 1, err: "SNOW returned HTTP 500"},
+		{name: "throttled", steps: []snowmock.Step{{Kind: snowmock.KindThrottle}}, msgs: []Message{create},
+			requests: 1, err: "SNOW returned HTTP 429"},
+		{name: "malformed", steps: []snowmock.Step{{Kind: snowmock.KindMalformed}}, msgs: []Message{create},
+			requests: 1, err: "could not parse SNOW response"},
+		{name: "slow", steps: []snowmock.Step{{Kind: snowmock.KindAuto, Delay: 500 * time.Millisecond}}, msgs: []Message{create},
+			requests: 1, err: "Client.Timeout"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			mock := snowmock.New()
+			mock.Username, mock.Password = "forwarder", "s3cr3t"
+			mock.Script(tc.steps...)
+			srv := httptest.NewServer(mock)
+			defer srv.Close()
+
+			password := "s3cr3t"
+			if tc.password != "" {
+				password = tc.password
+			}
+
+			env := map[string]string{
+				"SNOW_URL":                srv.URL,
+				"SNOW_TIMEOUT":            "200ms",
+				"SNOW_CREDENTIALS_SOURCE": "env",
+				"SNOW_USERNAME":           "forwarder",
+				"SNOW_PASSWORD":           password,
+			}
+			for k, v := range env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			snow = nil
+			defer func() { snow = nil }()
+
+			var res *Result
+			var err error
+			for _, m := range tc.msgs {
+				m := m
+				res, err = m.Notify(context.Background())
+			}
+
+			if got := len(mock.Requests()); got != tc.requests {
+				t.Errorf("expected %v requests to SNOW, got %v", tc.requests, got)
+			}
+
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if res.Outcome != tc.outcome || res.IntIdent != tc.ident {
+				t.Errorf("expected %v %q, got %v %q", tc.outcome, tc.ident, res.Outcome, res.IntIdent)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// defaultConcurrency is how many changes are processed at once
+const defaultConcurrency = 4
+
+// defaultDeadlineMargin leaves time for a record started just before the
+// deadline to finish
+const defaultDeadlineMargin = 5 * time.Second
+
+// concurrency reads CONCURRENCY
+func concurrency() (int, error) {
+
+	v, ok := os.LookupEnv("CONCURRENCY")
+	if !ok || v == "" {
+		return defaultConcurrency, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid CONCURRENCY %q", v)
+	}
+	return n, nil
+}
+
+// group splits stream records by change, keeping stream order within each
+// change and the order changes were first seen
+func group(records []events.DynamoDBEventRecord) [][]*events.DynamoDBEventRecord {
+
+	var groups [][]*events.DynamoDBEventRecord
+	index := make(map[string]int)
+
+	for i := range records {
+		r := &records[i]
+
+		// removed items have no new image, the key is always there
+		ref := attr(r.Change.Keys, "supplierRef")
+		if ref == "" {
+			ref = attr(r.Change.NewImage, "supplierRef")
+		}
+
+		g, ok := index[ref]
+		if !ok {
+			g = len(groups)
+			index[ref] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], r)
+	}
+	return groups
+}
+
+// deadlineMargin reads DEADLINE_MARGIN, how long before the invocation's
+// deadline to stop starting records
+func deadlineMargin() (time.Duration, error) {
+
+	v, ok := os.LookupEnv("DEADLINE_MARGIN")
+	if !ok || v == "" {
+		return defaultDeadlineMargin, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid DEADLINE_MARGIN %q", v)
+	}
+	return d, nil
+}
+
+// late reports whether there's too little time left to start a record
+func late(ctx context.Context, margin time.Duration) bool {
+
+	if ctx.Err() != nil {
+		return true
+	}
+	dl, ok := ctx.Deadline()
+	return ok && time.Until(dl) < margin
+}
+
+// run processes groups on up to n workers. Records in a group are handled
+// in order, and a failure skips the rest of its group so a later status
+// can't overtake an earlier one. No record is started within margin of the
+// deadline. Failed, skipped and unstarted records are returned for retry,
+// and failures are reported together.
+func run(ctx context.Context, groups [][]*events.DynamoDBEventRecord, n int, margin time.Duration,
+	fn func(context.Context, *events.DynamoDBEventRecord) error) ([]*events.DynamoDBEventRecord, error) {
+
+	work := make(chan []*events.DynamoDBEventRecord)
+
+	var mu sync.Mutex
+	var failed []string
+	var retry []*events.DynamoDBEventRecord
+	var unstarted int
+
+	var wg sync.WaitGroup
+	for i := 0; i < n && i < len(groups); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for g := range work {
+				for j, r := range g {
+					if late(ctx, margin) {
+						mu.Lock()
+						unstarted += len(g) - j
+						retry = append(retry, g[j:]...)
+						mu.Unlock()
+						break
+					}
+
+					err := fn(ctx, r)
+					if err == nil {
+						continue
+					}
+
+					if skipped := len(g) - j - 1; skipped > 0 {
+						log.Printf("skipping %v later records for the same change", skipped)
+					}
+
+					mu.Lock()
+					failed = append(failed, err.Error())
+					retry = append(retry, g[j:]...)
+					mu.Unlock()
+					break
+				}
+			}
+		}()
+	}
+
+	for _, g := range groups {
+		work <- g
+	}
+	close(work)
+	wg.Wait()
+
+	var errs []string
+	if len(failed) > 0 {
+		errs = append(errs, fmt.Sprintf("%v of %v changes failed: %v", len(failed), len(groups), strings.Join(failed, "; ")))
+	}
+	if unstarted > 0 {
+		log.Printf("ran out of time, leaving %v records for retry", unstarted)
+		errs = append(errs, fmt.Sprintf("%v records not started before the deadline", unstarted))
+	}
+
+	if len(errs) > 0 {
+		return retry, errors.New(strings.Join(errs, "; "))
+	}
+	return nil, nil
+}
@@ -0,0 +1,200 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// records builds stream records for a sequence of change/status pairs
+func records(pairs ...string) []events.DynamoDBEventRecord {
+
+	var rs []events.DynamoDBEventRecord
+	for i := 0; i < len(pairs); i += 2 {
+		rs = append(rs, events.DynamoDBEventRecord{
+			EventID:   pairs[i] + "/" + pairs[i+1],
+			EventName: "MODIFY",
+			Change: events.DynamoDBStreamRecord{
+				Keys:     image(map[string]string{"supplierRef": pairs[i]}),
+				NewImage: image(map[string]string{"supplierRef": pairs[i], "status": pairs[i+1]}),
+			},
+		})
+	}
+	return rs
+}
+
+func TestGroup(t *testing.T) {
+
+	rs := records("a", "Scheduled", "b", "Scheduled", "a", "In Progress", "c", "Completed", "a", "Completed")
+	rs = append(rs, events.DynamoDBEventRecord{EventID: "b/removed", EventName: "REMOVE",
+		Change: events.DynamoDBStreamRecord{Keys: image(map[string]string{"supplierRef": "b"})}})
+
+	var got [][]string
+	for _, g := range group(rs) {
+		var ids []string
+		for _, r := range g {
+			ids = append(ids, r.EventID)
+		}
+		got = append(got, ids)
+	}
+
+	expect := [][]string{
+		{"a/Scheduled", "a/In Progress", "a/Completed"},
+		{"b/Scheduled", "b/removed"},
+		{"c/Completed"},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestRun(t *testing.T) {
+
+	rs := records("a", "Scheduled", "b", "Scheduled", "a", "In Progress", "c", "Scheduled", "b", "In Progress",
+		"d", "Scheduled", "a", "Completed", "b", "Completed")
+
+	var mu sync.Mutex
+	var active, peak int
+	seen := make(map[string][]string)
+
+	retry, err := run(context.Background(), group(rs), 2, 0, func(ctx context.Context, r *events.DynamoDBEventRecord) error {
+		mu.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		ref := attr(r.Change.Keys, "supplierRef")
+		status := attr(r.Change.NewImage, "status")
+		seen[ref] = append(seen[ref], status)
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+
+		if ref == "b" && status == "In Progress" {
+			return errors.New("SNOW said no")
+		}
+		return nil
+	})
+
+	if err == nil || !strings.Contains(err.Error(), "1 of 4 changes failed: SNOW said no") {
+		t.Errorf("expected one failed change, got: %v", err)
+	}
+	var ids []string
+	for _, r := range retry {
+		ids = append(ids, r.EventID)
+	}
+	if expect := []string{"b/In Progress", "b/Completed"}; !reflect.DeepEqual(ids, expect) {
+		t.Errorf("expected %v for retry, got %v", expect, ids)
+	}
+	if peak != 2 {
+		t.Errorf("expected 2 changes in parallel, got %v", peak)
+	}
+
+	expect := map[string][]string{
+		"a": {"Scheduled", "In Progress", "Completed"},
+		"b": {"Scheduled", "In Progress"},
+		"c": {"Scheduled"},
+		"d": {"Scheduled"},
+	}
+	if !reflect.DeepEqual(seen, expect) {
+		t.Errorf("expected %v, got %v", expect, seen)
+	}
+}
+
+func TestRunDeadline(t *testing.T) {
+
+	rs := records("a", "Scheduled", "a", "In Progress", "b", "Scheduled")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var mu sync.Mutex
+	var done []string
+	retry, err := run(ctx, group(rs), 1, 40*time.Millisecond, func(ctx context.Context, r *events.DynamoDBEventRecord) error {
+		mu.Lock()
+		done = append(done, r.EventID)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+
+	if err == nil || !strings.Contains(err.Error(), "2 records not started before the deadline") {
+		t.Errorf("expected unstarted records, got: %v", err)
+	}
+	if expect := []string{"a/Scheduled"}; !reflect.DeepEqual(done, expect) {
+		t.Errorf("expected %v handled, got %v", expect, done)
+	}
+	if len(retry) != 2 {
+		t.Errorf("expected 2 records for retry, got %v", len(retry))
+	}
+}
+
+func TestDeadlineMargin(t *testing.T) {
+
+	tt := []struct {
+		value  string
+		expect time.Duration
+		err    string
+	}{
+		{value: "", expect: defaultDeadlineMargin},
+		{value: "10s", expect: 10 * time.Second},
+		{value: "0s", expect: 0},
+		{value: "-1s", err: "invalid DEADLINE_MARGIN"},
+		{value: "soon", err: "invalid DEADLINE_MARGIN"},
+	}
+
+	for _, tc := range tt {
+		os.Setenv("DEADLINE_MARGIN", tc.value)
+		d, err := deadlineMargin()
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected error %q, got: %v", tc.err, err)
+			}
+			continue
+		}
+		if d != tc.expect {
+			t.Errorf("expected %v, got %v", tc.expect, d)
+		}
+	}
+	os.Unsetenv("DEADLINE_MARGIN")
+}
+
+func TestConcurrency(t *testing.T) {
+
+	tt := []struct {
+		value  string
+		expect int
+		err    string
+	}{
+		{value: "", expect: defaultConcurrency},
+		{value: "8", expect: 8},
+		{value: "0", err: "invalid CONCURRENCY"},
+		{value: "many", err: "invalid CONCURRENCY"},
+	}
+
+	for _, tc := range tt {
+		os.Setenv("CONCURRENCY", tc.value)
+		n, err := concurrency()
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected error %q, got: %v", tc.err, err)
+			}
+			continue
+		}
+		if n != tc.expect {
+			t.Errorf("expected %v, got %v", tc.expect, n)
+		}
+	}
+	os.Unsetenv("CONCURRENCY")
+}
@@ -0,0 +1,126 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// defaultRefField is the SNOW change field holding the JSD issue key
+const defaultRefField = "correlation_id"
+
+// Change is a change request as SNOW holds it. Dates are UTC, in SNOW's
+// date layout, and State is SNOW's numeric state.
+type Change struct {
+	Number      string
+	SysID       string
+	State       string
+	StartDate   string
+	EndDate     string
+	SupplierRef string
+}
+
+// LookupNumber finds a change by its SNOW number
+func LookupNumber(ctx context.Context, number string) ([]Change, error) {
+	return lookup(ctx, "number", number)
+}
+
+// LookupRef finds changes raised for a JSD issue
+func LookupRef(ctx context.Context, ref string) ([]Change, error) {
+	return lookup(ctx, refField(), ref)
+}
+
+// refField reads SNOW_REF_FIELD
+func refField() string {
+
+	field, ok := os.LookupEnv("SNOW_REF_FIELD")
+	if !ok || field == "" {
+		return defaultRefField
+	}
+	return field
+}
+
+// lookup queries the SNOW change table at SNOW_TABLE_URL for changes where
+// field equals value
+func lookup(ctx context.Context, field, value string) ([]Change, error) {
+
+	tu, ok := os.LookupEnv("SNOW_TABLE_URL")
+	if !ok {
+		return nil, errors.New("missing environment variable SNOW_TABLE_URL")
+	}
+
+	ref := refField()
+	rows, err := queryTable(ctx, tu, field, value, []string{"number", "sys_id", "state", "start_date", "end_date", ref})
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for _, r := range rows {
+		changes = append(changes, Change{
+			Number:      r["number"],
+			SysID:       r["sys_id"],
+			State:       r["state"],
+			StartDate:   r["start_date"],
+			EndDate:     r["end_date"],
+			SupplierRef: r[ref],
+		})
+	}
+	return changes, nil
+}
+
+// queryTable reads fields of the records in a SNOW table where field
+// equals value, using the table API at tableURL
+func queryTable(ctx context.Context, tableURL, field, value string, fields []string) ([]map[string]string, error) {
+
+	// ^ separates terms in an encoded query
+	if value == "" || strings.Contains(value, "^") {
+		return nil, fmt.Errorf("invalid %v %q", field, value)
+	}
+
+	u, err := url.Parse(tableURL)
+	if err != nil {
+		return nil, err
+	}
+
+	q := u.Query()
+	q.Set("sysparm_query", field+"="+value)
+	q.Set("sysparm_fields", strings.Join(fields, ","))
+	q.Set("sysparm_display_value", "false")
+	q.Set("sysparm_limit", "10")
+	u.RawQuery = q.Encode()
+
+	c, err := snowClient()
+	if err != nil {
+		return nil, err
+	}
+
+	code, body, err := c.get(ctx, u.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var rep struct {
+		Result []map[string]string `json:"result"`
+		Error  *ReplyError         `json:"error,omitempty"`
+	}
+	perr := json.Unmarshal(body, &rep)
+
+	if code < 200 || code > 299 {
+		msg := http.StatusText(code)
+		if perr == nil && rep.Error != nil && rep.Error.Message != "" {
+			msg = rep.Error.Message
+		}
+		return nil, &StatusError{Code: code, Message: msg}
+	}
+
+	if perr != nil {
+		return nil, errors.New("could not parse SNOW response: " + perr.Error())
+	}
+	return rep.Result, nil
+}
@@ -0,0 +1,100 @@
+package notifier
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/snowmock"
+)
+
+func TestLookup(t *testing.T) {
+
+	mock := snowmock.New()
+	mock.Username, mock.Password = "forwarder", "s3cr3t"
+	mock.Put(snowmock.Record{Number: "CHG0000001", SupplierRef: "ACP-1", State: "-2"})
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+
+	tt := []struct {
+		name     string
+		table    bool
+		field    string
+		number   string
+		ref      string
+		password string
+		expect   string
+		err      string
+	}{
+		{name: "by number", table: true, number: "CHG0000001", expect: "ACP-1"},
+		{name: "by ref", table: true, ref: "ACP-1", expect: "CHG0000001"},
+		{name: "custom ref field", table: true, field: "u_supplier_ref", ref: "ACP-1", expect: "CHG0000001"},
+		{name: "not found", table: true, number: "CHG0000009"},
+		{name: "no table", number: "CHG0000001", err: "missing environment variable SNOW_TABLE_URL"},
+		{name: "encoded query", table: true, ref: "ACP-1^ORnumber!=x", err: "invalid correlation_id"},
+		{name: "bad credentials", table: true, password: "wrong", number: "CHG0000001", err: "SNOW returned HTTP 401"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			password := "s3cr3t"
+			if tc.password != "" {
+				password = tc.password
+			}
+
+			env := map[string]string{
+				"SNOW_URL":                srv.URL,
+				"SNOW_CREDENTIALS_SOURCE": "env",
+				"SNOW_USERNAME":           "forwarder",
+				"SNOW_PASSWORD":           password,
+			}
+			if tc.table {
+				env["SNOW_TABLE_URL"] = srv.URL + "/api/now/table/change_request"
+			}
+			if tc.field != "" {
+				env["SNOW_REF_FIELD"] = tc.field
+			}
+			for k, v := range env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			snow = nil
+			defer func() { snow = nil }()
+
+			var changes []Change
+			var err error
+			if tc.number != "" {
+				changes, err = LookupNumber(context.Background(), tc.number)
+			} else {
+				changes, err = LookupRef(context.Background(), tc.ref)
+			}
+
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tc.expect == "" {
+				if len(changes) != 0 {
+					t.Errorf("expected no changes, got %+v", changes)
+				}
+				return
+			}
+			if len(changes) != 1 {
+				t.Fatalf("expected one change, got %+v", changes)
+			}
+			if c := changes[0]; c.Number != "CHG0000001" || c.SupplierRef != "ACP-1" || c.State != "-2" {
+				t.Errorf("unexpected change: %+v", c)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when a request can't get a token in time
+var ErrRateLimited = errors.New("SNOW rate limit wait would exceed timeout")
+
+// Rate is the configured limit for an endpoint
+type Rate struct {
+	PerSecond float64 `json:"rate"`
+	Burst     int     `json:"burst"`
+}
+
+// Limiter is a token bucket shared by every request to one endpoint
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	until  time.Time
+	now    func() time.Time
+	sleep  func(context.Context, time.Duration) error
+}
+
+// NewLimiter allows rate requests per second with bursts of up to burst
+func NewLimiter(rate float64, burst int) *Limiter {
+
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+		sleep:  sleepCtx,
+	}
+}
+
+// limiters are kept per endpoint for the life of the container
+var (
+	limiters   = make(map[string]*Limiter)
+	limitersMu sync.Mutex
+)
+
+// endpoint is the scheme, host and path of a URL, without the query, which
+// identifies an endpoint for rate limiting
+func endpoint(u string) string {
+
+	p, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	p.RawQuery = ""
+	p.Fragment = ""
+	return p.String()
+}
+
+// limiterFor returns the shared limiter for an endpoint, configured from
+// SNOW_RATE_LIMITS (a JSON map of endpoint URL to rate and burst), falling
+// back to SNOW_RATE_LIMIT and SNOW_RATE_BURST, which each endpoint gets
+// its own bucket of. Nil means unlimited.
+func limiterFor(endpoint string) (*Limiter, error) {
+
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	if l, ok := limiters[endpoint]; ok {
+		return l, nil
+	}
+
+	r, err := rateFor(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var l *Limiter
+	if r.PerSecond > 0 {
+		l = NewLimiter(r.PerSecond, r.Burst)
+	}
+	limiters[endpoint] = l
+	return l, nil
+}
+
+// rateFor reads the configured rate for an endpoint
+func rateFor(endpoint string) (Rate, error) {
+
+	if v, ok := os.LookupEnv("SNOW_RATE_LIMITS"); ok {
+		var rates map[string]Rate
+		err := json.Unmarshal([]byte(v), &rates)
+		if err != nil {
+			return Rate{}, errors.New("could not parse SNOW_RATE_LIMITS: " + err.Error())
+		}
+		if r, ok := rates[endpoint]; ok {
+			return r, nil
+		}
+	}
+
+	var r Rate
+	if v, ok := os.LookupEnv("SNOW_RATE_LIMIT"); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 {
+			return Rate{}, fmt.Errorf("invalid SNOW_RATE_LIMIT %q", v)
+		}
+		r.PerSecond = f
+	}
+
+	if v, ok := os.LookupEnv("SNOW_RATE_BURST"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return Rate{}, fmt.Errorf("invalid SNOW_RATE_BURST %q", v)
+		}
+		r.Burst = n
+	}
+	return r, nil
+}
+
+// reserve takes a token and returns how long to wait before using it. The
+// token is only taken if the wait is within max, and zero max is no limit.
+func (l *Limiter) reserve(max time.Duration) (time.Duration, error) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+
+	var wait time.Duration
+	if l.tokens < 1 {
+		wait = time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	}
+	if l.until.After(now.Add(wait)) {
+		wait = l.until.Sub(now)
+	}
+
+	if max > 0 && wait > max {
+		return 0, ErrRateLimited
+	}
+
+	l.tokens--
+	return wait, nil
+}
+
+// Wait blocks until a request may be made, or until ctx is done, and fails
+// straight away if that would take longer than max
+func (l *Limiter) Wait(ctx context.Context, max time.Duration) error {
+
+	if l == nil {
+		return nil
+	}
+
+	wait, err := l.reserve(max)
+	if err != nil {
+		return err
+	}
+
+	if wait > 0 {
+		log.Printf("rate limiting SNOW request for %v", wait)
+		return l.sleep(ctx, wait)
+	}
+	return nil
+}
+
+// sleepCtx waits for d, returning early if ctx is done
+func sleepCtx(ctx context.Context, d time.Duration) error {
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Pause holds back requests, e.g. when SNOW asks us to slow down
+func (l *Limiter) Pause(d time.Duration) {
+
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until := l.now().Add(d); until.After(l.until) {
+		l.until = until
+	}
+}
+
+// retryAfter reads SNOW's Retry-After header in seconds, if any
+func retryAfter(h http.Header) time.Duration {
+
+	n, err := strconv.Atoi(h.Get("Retry-After"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
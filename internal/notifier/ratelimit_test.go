@@ -0,0 +1,278 @@
+package notifier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock advances when the limiter sleeps
+type fakeClock struct {
+	now   time.Time
+	slept []time.Duration
+}
+
+func (fc *fakeClock) Now() time.Time {
+	return fc.now
+}
+
+func (fc *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	fc.slept = append(fc.slept, d)
+	fc.now = fc.now.Add(d)
+	return nil
+}
+
+func TestLimiter(t *testing.T) {
+
+	fc := &fakeClock{now: time.Now()}
+	l := NewLimiter(2, 2)
+	l.now, l.sleep = fc.Now, fc.Sleep
+
+	// the burst goes straight through, then requests are spaced out
+	for i := 0; i < 4; i++ {
+		if err := l.Wait(context.Background(), time.Minute); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	expect := []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}
+	if len(fc.slept) != 2 || fc.slept[0] != expect[0] || fc.slept[1] != expect[1] {
+		t.Errorf("expected waits %v, got %v", expect, fc.slept)
+	}
+
+	// a wait longer than the timeout fails without taking a token
+	if err := l.Wait(context.Background(), 100*time.Millisecond); err != ErrRateLimited {
+		t.Errorf("expected ErrRateLimited, got: %v", err)
+	}
+	if err := l.Wait(context.Background(), time.Minute); err != nil || fc.slept[2] != 500*time.Millisecond {
+		t.Errorf("expected next request to wait 500ms, got %v: %v", fc.slept, err)
+	}
+
+	// SNOW asking us to back off holds everything
+	l.Pause(10 * time.Second)
+	fc.slept = nil
+	l.Wait(context.Background(), time.Minute)
+	if len(fc.slept) != 1 || fc.slept[0] != 10*time.Second {
+		t.Errorf("expected 10s pause, got %v", fc.slept)
+	}
+
+	// no limiter means no limit
+	var none *Limiter
+	if err := none.Wait(context.Background(), 0); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLimiterCancelled(t *testing.T) {
+
+	l := NewLimiter(1, 1)
+	l.Pause(time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// a cancelled invocation stops waiting rather than sitting out the pause
+	done := make(chan error, 1)
+	go func() { done <- l.Wait(ctx, 2*time.Hour) }()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("expected context.Canceled, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Wait to return once ctx was cancelled")
+	}
+}
+
+func TestLimiterFor(t *testing.T) {
+
+	tt := []struct {
+		name     string
+		endpoint string
+		env      map[string]string
+		rate     float64
+		burst    float64
+		err      string
+	}{
+		{name: "unlimited", endpoint: "https://snow/a"},
+		{name: "default", endpoint: "https://snow/b", env: map[string]string{"SNOW_RATE_LIMIT": "5", "SNOW_RATE_BURST": "10"}, rate: 5, burst: 10},
+		{name: "per endpoint", endpoint: "https://snow/c", env: map[string]string{"SNOW_RATE_LIMIT": "5",
+			"SNOW_RATE_LIMITS": `{"https://snow/c":{"rate":0.5,"burst":3}}`}, rate: 0.5, burst: 3},
+		{name: "bad map", endpoint: "https://snow/d", env: map[string]string{"SNOW_RATE_LIMITS": `5`}, err: "could not parse SNOW_RATE_LIMITS"},
+		{name: "bad rate", endpoint: "https://snow/e", env: map[string]string{"SNOW_RATE_LIMIT": "fast"}, err: "invalid SNOW_RATE_LIMIT"},
+		{name: "bad burst", endpoint: "https://snow/f", env: map[string]string{"SNOW_RATE_LIMIT": "1", "SNOW_RATE_BURST": "0"}, err: "invalid SNOW_RATE_BURST"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			for k, v := range tc.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			l, err := limiterFor(tc.endpoint)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tc.rate == 0 {
+				if l != nil {
+					t.Errorf("expected no limiter, got %+v", l)
+				}
+				return
+			}
+			if l.rate != tc.rate || l.burst != tc.burst {
+				t.Errorf("expected %v/s burst %v, got %v/s burst %v", tc.rate, tc.burst, l.rate, l.burst)
+			}
+
+			// endpoints share one limiter
+			if again, _ := limiterFor(tc.endpoint); again != l {
+				t.Errorf("expected the same limiter for %v", tc.endpoint)
+			}
+		})
+	}
+}
+
+func TestClientRetryAfter(t *testing.T) {
+
+	tt := []struct {
+		name    string
+		after   string
+		limiter bool
+		expect  int
+		calls   int
+	}{
+		{name: "retried after pause", after: "7", limiter: true, expect: http.StatusOK, calls: 2},
+		{name: "retried without limiter", after: "7", expect: http.StatusOK, calls: 2},
+		{name: "longer than timeout", after: "120", limiter: true, expect: http.StatusTooManyRequests, calls: 1},
+		{name: "longer than timeout without limiter", after: "120", expect: http.StatusTooManyRequests, calls: 1},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			calls := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls == 1 {
+					w.Header().Set("Retry-After", tc.after)
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer srv.Close()
+
+			fc := &fakeClock{now: time.Now()}
+			c := &Client{URL: srv.URL, HTTP: &http.Client{Timeout: time.Minute}, Auth: noAuth{}, sleep: fc.Sleep}
+			if tc.limiter {
+				l := NewLimiter(100, 1)
+				l.now, l.sleep = fc.Now, fc.Sleep
+				c.Limiter = l
+			}
+
+			code, _, err := c.post(context.Background(), []byte(`{}`))
+			if err != nil || code != tc.expect {
+				t.Fatalf("expected %v, got %v: %v", tc.expect, code, err)
+			}
+			if calls != tc.calls {
+				t.Errorf("expected %v requests, got %v", tc.calls, calls)
+			}
+			if tc.calls == 2 && (len(fc.slept) != 1 || fc.slept[0] != 7*time.Second) {
+				t.Errorf("expected the retry to wait for Retry-After, got %v", fc.slept)
+			}
+			if tc.calls == 1 && len(fc.slept) != 0 {
+				t.Errorf("expected no wait, got %v", fc.slept)
+			}
+		})
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+
+	tt := []struct {
+		url    string
+		expect string
+	}{
+		{url: "https://snow/api/now/import/u_change", expect: "https://snow/api/now/import/u_change"},
+		{url: "https://snow/api/now/table/change_request?sysparm_query=number%3DCHG001", expect: "https://snow/api/now/table/change_request"},
+		{url: "https://other/api/now/table/change_request", expect: "https://other/api/now/table/change_request"},
+	}
+
+	for _, tc := range tt {
+		if got := endpoint(tc.url); got != tc.expect {
+			t.Errorf("expected %v, got %v", tc.expect, got)
+		}
+	}
+}
+
+func TestClientLimiterPerEndpoint(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/now/table/") {
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	table := srv.URL + "/api/now/table/change_request"
+	os.Setenv("SNOW_RATE_LIMITS", `{"`+table+`":{"rate":100,"burst":1}}`)
+	defer os.Unsetenv("SNOW_RATE_LIMITS")
+
+	fc := &fakeClock{now: time.Now()}
+	imp := NewLimiter(100, 1)
+	imp.now, imp.sleep = fc.Now, fc.Sleep
+
+	c := &Client{URL: srv.URL + "/api/now/import/u_change", HTTP: &http.Client{Timeout: time.Minute}, Auth: noAuth{}, Limiter: imp}
+
+	// SNOW slowing down table reads doesn't hold back changes being sent
+	code, _, err := c.get(context.Background(), table+"?sysparm_query=number%3DCHG001")
+	if err != nil || code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %v: %v", code, err)
+	}
+	c.post(context.Background(), []byte(`{}`))
+	if len(fc.slept) != 0 {
+		t.Errorf("expected posts not to wait, got %v", fc.slept)
+	}
+
+	tl, _ := limiterFor(table)
+	if tl == nil || tl == imp || !tl.until.After(time.Now()) {
+		t.Errorf("expected the table API's own limiter to be paused, got %+v", tl)
+	}
+}
+
+func TestClientDeadlinePassed(t *testing.T) {
+
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+
+	c := &Client{URL: srv.URL, HTTP: &http.Client{Timeout: time.Minute}, Auth: noAuth{}, Limiter: NewLimiter(100, 1)}
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	_, _, err := c.post(ctx, []byte(`{}`))
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline error, got: %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("expected no request once the deadline passed, got %v", calls)
+	}
+}
@@ -0,0 +1,196 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Outcome is what SNOW did with a message
+type Outcome string
+
+// SNOW outcomes, matching import set row statuses
+const (
+	OutcomeInserted Outcome = "inserted"
+	OutcomeUpdated  Outcome = "updated"
+	OutcomeIgnored  Outcome = "ignored"
+	OutcomeSkipped  Outcome = "skipped"
+	OutcomeError    Outcome = "error"
+)
+
+// Result is returned to the handler after calling SNOW
+type Result struct {
+	Outcome  Outcome
+	IntIdent string
+	SysID    string
+	Number   string
+	Message  string
+}
+
+// Reply is the body SNOW returns, either from the scripted REST endpoint or
+// the import set API
+type Reply struct {
+	ImportSet    string      `json:"import_set,omitempty"`
+	StagingTable string      `json:"staging_table,omitempty"`
+	Status       string      `json:"status,omitempty"`
+	Result       Rows        `json:"result"`
+	Error        *ReplyError `json:"error,omitempty"`
+}
+
+// ReplyError is a SNOW platform error
+type ReplyError struct {
+	Message string `json:"message"`
+	Detail  string `json:"detail"`
+}
+
+// Row is the outcome of one transformed import set row
+type Row struct {
+	Status        string `json:"status"`
+	SysID         string `json:"sys_id"`
+	Table         string `json:"table"`
+	DisplayName   string `json:"display_name"`
+	DisplayValue  string `json:"display_value"`
+	IntIdent      string `json:"internal_identifier"`
+	Log           string `json:"log"`
+	ErrorMessage  string `json:"error_message"`
+	StatusMessage string `json:"status_message"`
+}
+
+// Rows holds a single result object or an array of them
+type Rows []Row
+
+// UnmarshalJSON accepts both an object and an array
+func (r *Rows) UnmarshalJSON(b []byte) error {
+
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || bytes.Equal(b, []byte("null")) {
+		*r = nil
+		return nil
+	}
+
+	if b[0] == '[' {
+		var rows []Row
+		err := json.Unmarshal(b, &rows)
+		if err != nil {
+			return err
+		}
+		*r = rows
+		return nil
+	}
+
+	var row Row
+	err := json.Unmarshal(b, &row)
+	if err != nil {
+		return err
+	}
+	*r = Rows{row}
+	return nil
+}
+
+// StatusError is returned when SNOW replies with a non-2xx code
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("SNOW returned HTTP %v: %v", e.Code, e.Message)
+}
+
+// Temporary reports whether the request may succeed if retried later
+func (e *StatusError) Temporary() bool {
+	return e.Code == http.StatusTooManyRequests || e.Code >= 500
+}
+
+// permanent reports whether err is a SNOW reply that retrying won't change
+func permanent(err error) bool {
+
+	var se *StatusError
+	return errors.As(err, &se) && !se.Temporary()
+}
+
+// parseReply turns a SNOW reply into a result
+func parseReply(code int, body []byte) (*Result, error) {
+
+	var rep Reply
+	perr := json.Unmarshal(body, &rep)
+
+	if code < 200 || code > 299 {
+		msg := http.StatusText(code)
+		if perr == nil && rep.Error != nil && rep.Error.Message != "" {
+			msg = rep.Error.Message
+		}
+		return nil, &StatusError{Code: code, Message: msg}
+	}
+
+	if perr != nil {
+		return nil, errors.New("could not parse SNOW response: " + perr.Error())
+	}
+
+	if rep.Error != nil {
+		return nil, errors.New("SNOW returned error: " + rep.Error.Message)
+	}
+
+	if len(rep.Result) == 0 {
+		return nil, errors.New("unexpected SNOW response format: no result")
+	}
+
+	// one message is one row, anything else is the first error or row
+	row := rep.Result[0]
+	for _, r := range rep.Result {
+		if outcome(r) == OutcomeError {
+			row = r
+			break
+		}
+	}
+
+	res := &Result{
+		Outcome:  outcome(row),
+		IntIdent: row.IntIdent,
+		SysID:    row.SysID,
+		Number:   row.DisplayValue,
+		Message:  row.StatusMessage,
+	}
+	if res.IntIdent == "" {
+		res.IntIdent = res.Number
+	}
+	if row.ErrorMessage != "" {
+		res.Message = row.ErrorMessage
+	}
+
+	switch res.Outcome {
+	case OutcomeError:
+		return res, errors.New("SNOW rejected message: " + res.Message)
+	case "":
+		return nil, errors.New("could not understand SNOW response")
+	case OutcomeInserted, OutcomeUpdated:
+		if res.IntIdent == "" {
+			return nil, errors.New("missing internal_identifier in SNOW response")
+		}
+	}
+	return res, nil
+}
+
+// outcome reads a row status, falling back to the transform log wording
+func outcome(r Row) Outcome {
+
+	switch o := Outcome(strings.ToLower(r.Status)); o {
+	case OutcomeInserted, OutcomeUpdated, OutcomeIgnored, OutcomeSkipped, OutcomeError:
+		return o
+	}
+
+	switch {
+	case strings.Contains(r.Log, "Inserting"):
+		return OutcomeInserted
+	case strings.Contains(r.Log, "Updating"):
+		return OutcomeUpdated
+	case strings.Contains(r.Log, "Ignoring"):
+		return OutcomeIgnored
+	case strings.Contains(r.Log, "Skipping"):
+		return OutcomeSkipped
+	}
+	return ""
+}
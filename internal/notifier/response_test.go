@@ -0,0 +1,79 @@
+package notifier
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseReply(t *testing.T) {
+
+	tt := []struct {
+		name     string
+		code     int
+		body     string
+		outcome  Outcome
+		intIdent string
+		err      string
+	}{
+		{name: "legacy insert", code: 200, body: `{"result":{"internal_identifier":"CHG001","log":"Inserting change"}}`,
+			outcome: OutcomeInserted, intIdent: "CHG001"},
+		{name: "legacy update", code: 200, body: `{"result":{"internal_identifier":"CHG001","log":"Updating change"}}`,
+			outcome: OutcomeUpdated, intIdent: "CHG001"},
+		{name: "legacy unknown", code: 200, body: `{"result":{"internal_identifier":"CHG001","log":"Done"}}`,
+			err: "could not understand SNOW response"},
+		{name: "import insert", code: 201, body: `{"import_set":"ISET001","result":[{"status":"inserted","sys_id":"abc","display_value":"CHG002"}]}`,
+			outcome: OutcomeInserted, intIdent: "CHG002"},
+		{name: "import update", code: 201, body: `{"import_set":"ISET001","result":[{"status":"updated","sys_id":"abc","internal_identifier":"CHG003"}]}`,
+			outcome: OutcomeUpdated, intIdent: "CHG003"},
+		{name: "import ignored", code: 201, body: `{"result":[{"status":"ignored","status_message":"No field values changed"}]}`,
+			outcome: OutcomeIgnored},
+		{name: "import skipped", code: 201, body: `{"result":[{"status":"skipped"}]}`, outcome: OutcomeSkipped},
+		{name: "row error", code: 201, body: `{"result":[{"status":"inserted","display_value":"CHG004"},{"status":"error","error_message":"invalid state"}]}`,
+			err: "SNOW rejected message: invalid state"},
+		{name: "missing id", code: 201, body: `{"result":[{"status":"inserted"}]}`, err: "missing internal_identifier"},
+		{name: "platform error", code: 200, body: `{"error":{"message":"bad","detail":"worse"},"status":"failure"}`, err: "SNOW returned error: bad"},
+		{name: "no result", code: 200, body: `{}`, err: "no result"},
+		{name: "malformed", code: 200, body: `<html>`, err: "could not parse SNOW response"},
+		{name: "unauthorised", code: 401, body: `{"error":{"message":"User Not Authenticated"}}`, err: "HTTP 401: User Not Authenticated"},
+		{name: "unavailable", code: 503, body: `<html>`, err: "HTTP 503: Service Unavailable"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			res, err := parseReply(tc.code, []byte(tc.body))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res.Outcome != tc.outcome {
+				t.Errorf("expected outcome %v, got %v", tc.outcome, res.Outcome)
+			}
+			if res.IntIdent != tc.intIdent {
+				t.Errorf("expected internal_identifier %q, got %q", tc.intIdent, res.IntIdent)
+			}
+		})
+	}
+}
+
+func TestStatusErrorTemporary(t *testing.T) {
+
+	tt := []struct {
+		code   int
+		expect bool
+	}{
+		{code: 400}, {code: 401}, {code: 429, expect: true}, {code: 500, expect: true}, {code: 503, expect: true},
+	}
+
+	for _, tc := range tt {
+		e := &StatusError{Code: tc.code}
+		if e.Temporary() != tc.expect {
+			t.Errorf("expected Temporary() %v for %v", tc.expect, tc.code)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sink delivers change events to an external system
+type Sink interface {
+	// Name identifies the sink in config and logs
+	Name() string
+	// Deliver sends a message and returns the external reference and outcome
+	Deliver(ctx context.Context, m *Message) (*Result, error)
+}
+
+// multiSink delivers to several targets, each tracked by the ledger on its
+// own so a failing target doesn't repeat deliveries to the others
+type multiSink interface {
+	Sink
+	Targets(m *Message) []Sink
+}
+
+// Delivery is the result of sending one message to one sink
+type Delivery struct {
+	Sink   string
+	Result *Result
+	Err    error
+}
+
+// newSinks builds the sinks listed in SINKS, SNOW only by default. SNOW
+// always goes first so the others can link to the change it holds.
+func newSinks() ([]Sink, error) {
+
+	v, ok := os.LookupEnv("SINKS")
+	if !ok || strings.TrimSpace(v) == "" {
+		v = "snow"
+	}
+
+	names := strings.Split(v, ",")
+	sort.SliceStable(names, func(i, j int) bool {
+		return strings.TrimSpace(names[i]) == "snow" && strings.TrimSpace(names[j]) != "snow"
+	})
+
+	var sinks []Sink
+	for _, name := range names {
+		switch name = strings.TrimSpace(name); name {
+		case "snow":
+			s, err := NewSnowSink()
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, s)
+		case "chat":
+			cs, err := newChatSink()
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, cs)
+		default:
+			return nil, fmt.Errorf("unknown sink %q", name)
+		}
+	}
+	return sinks, nil
+}
+
+// deliver sends a message to every sink, and every target of a multiSink.
+// A failing sink doesn't stop the others; failures are reported together
+// once all sinks have been tried, and the record is retried. Only the
+// ledger stops that retry repeating deliveries that succeeded, so fansOut
+// sinks need one.
+func deliver(ctx context.Context, sinks []Sink, l *Ledger, m *Message) ([]Delivery, error) {
+
+	var ds []Delivery
+	var failed []string
+
+	var targets []Sink
+	for _, s := range sinks {
+		ms, ok := s.(multiSink)
+		if !ok {
+			targets = append(targets, s)
+			continue
+		}
+		t := ms.Targets(m)
+		if len(t) == 0 {
+			log.Printf("no %v targets for %v %v", s.Name(), m.SupplierRef, m.Event)
+		}
+		targets = append(targets, t...)
+	}
+
+	// the SNOW ID of a change it has just raised is passed on to the rest
+	intID := m.IntID
+
+	for _, s := range targets {
+
+		// sinks may fill in their own references, so each gets a copy
+		mc := *m
+		mc.IntID = intID
+		res, err := l.once(ctx, s, &mc)
+		if s.Name() == "snow" && err == nil && res != nil && res.IntIdent != "" && intID == "" {
+			intID = res.IntIdent
+		}
+
+		// unmappable and rejected messages are parked rather than retried.
+		// Short-circuited ones fail the record, so later transitions for
+		// the change wait behind them.
+		if errors.Is(err, ErrNoCI) || permanent(err) {
+			if perr := park(ctx, &mc, err); perr == nil {
+				res, err = &Result{Outcome: OutcomeSkipped, Message: err.Error()}, nil
+			}
+		}
+
+		ds = append(ds, Delivery{Sink: s.Name(), Result: res, Err: err})
+		if err != nil {
+			log.Printf("could not deliver %v to %v: %v", m.SupplierRef, s.Name(), err)
+			failed = append(failed, s.Name()+": "+err.Error())
+			continue
+		}
+		log.Printf("delivered %v to %v, outcome: %v", m.SupplierRef, s.Name(), res.Outcome)
+	}
+
+	if len(failed) > 0 {
+		return ds, errors.New("delivery failed for " + strings.Join(failed, "; "))
+	}
+	return ds, nil
+}
+
+// fansOut reports whether a message may go to more than one target
+func fansOut(sinks []Sink) bool {
+
+	if len(sinks) > 1 {
+		return true
+	}
+	for _, s := range sinks {
+		cs, ok := s.(*chatSink)
+		if !ok {
+			continue
+		}
+		for _, hooks := range cs.routes {
+			if len(hooks) > 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NewSnowSink returns the SNOW sink, for jobs that send outside the stream
+func NewSnowSink() (Sink, error) {
+
+	db, err := newDB()
+	if err != nil {
+		return nil, err
+	}
+
+	cmdb, err := newCMDB()
+	if err != nil {
+		return nil, err
+	}
+	return &snowSink{db: db, cmdb: cmdb}, nil
+}
+
+// snowSink sends messages to the SNOW integration endpoint and keeps the
+// change's internal_identifier in step. With a CMDB, messages carry the
+// change's configuration items.
+type snowSink struct {
+	db   *DB
+	cmdb *CMDB
+}
+
+// Name identifies the sink
+func (s *snowSink) Name() string {
+	return "snow"
+}
+
+// Deliver calls SNOW and records the attempt on the change item
+func (s *snowSink) Deliver(ctx context.Context, m *Message) (*Result, error) {
+
+	res, err := s.send(ctx, m)
+	if m.MessageID == "" {
+		return res, err
+	}
+
+	ds := DeliveryState{
+		SupplierRef: m.SupplierRef,
+		Status:      m.Status,
+		MessageType: m.MessageID,
+		Response:    summary(res, err),
+		Err:         err,
+		At:          time.Now(),
+	}
+
+	// SNOW has had the message either way, so don't fail on bookkeeping
+	_, derr := s.db.RecordDelivery(ctx, &ds)
+	if derr != nil {
+		log.Printf("could not record delivery state for %v: %v", m.SupplierRef, derr)
+	}
+	return res, err
+}
+
+// send calls SNOW, creating the change first when needed
+func (s *snowSink) send(ctx context.Context, m *Message) (*Result, error) {
+
+	if m.MessageID == "" {
+		return &Result{Outcome: OutcomeSkipped, IntIdent: m.IntID, Message: "no SNOW message for " + m.Event}, nil
+	}
+
+	if s.cmdb != nil {
+		err := s.cmdb.Attach(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the stream record may predate the ID being stored, so check the table
+	// before raising the change again
+	if m.MessageID == updateMsgID && m.IntID == "" {
+		id, err := s.db.StoredID(ctx, m.SupplierRef)
+		if err != nil {
+			return nil, errors.New("could not read internal identifier: " + err.Error())
+		}
+		if id != "" {
+			log.Printf("using stored internal_identifier %v for %v", id, m.SupplierRef)
+			m.IntID = id
+		}
+	}
+
+	// changes first seen after Scheduled don't exist in SNOW yet. Emergency
+	// changes may be raised as they stand, the rest start as Scheduled.
+	if m.MessageID == updateMsgID && m.IntID == "" {
+		if m.SkipScheduled {
+			log.Printf("no internal_identifier for %s, raising it %v", m.SupplierRef, m.Status)
+			m.MessageID = createMsgID
+		} else {
+			err := m.createFirst(ctx, s.db)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// call SNOW and expect internal_identifer in return for new changes
+	res, err := m.Notify(ctx)
+	if err != nil {
+		return res, err
+	}
+
+	if res.Outcome != OutcomeInserted {
+		return res, nil
+	}
+
+	// add internal_identifier to db record
+	ur := Response{
+		SupplierRef: m.SupplierRef,
+		IntIdent:    res.IntIdent,
+	}
+
+	_, err = s.db.AddID(ctx, &ur)
+	if err != nil {
+		return res, errors.New("could not update db with internal identifier: " + err.Error())
+	}
+	return res, nil
+}
+
+// summary describes SNOW's reply for the change item
+func summary(res *Result, err error) string {
+
+	if err != nil && res == nil {
+		return "no reply: " + err.Error()
+	}
+	if res == nil {
+		return ""
+	}
+
+	s := string(res.Outcome)
+	if res.IntIdent != "" {
+		s += " " + res.IntIdent
+	}
+	if res.Message != "" {
+		s += ": " + res.Message
+	}
+	return s
+}
+
+// createFirst raises the change in SNOW for an update that has no
+// internal_identifier yet, stores the new ID and attaches it to the update
+func (m *Message) createFirst(ctx context.Context, db *DB) error {
+
+	log.Printf("no internal_identifier for %s, creating change in SNOW first", m.SupplierRef)
+
+	// SNOW only accepts new changes as Scheduled, the update moves it on
+	c := Message{
+		MessageID: createMsgID,
+		Payload:   m.Payload,
+	}
+	c.Status = "Scheduled"
+	c.Success = ""
+
+	res, err := c.Notify(ctx)
+	if err != nil {
+		return err
+	}
+
+	// an update means SNOW already knew the change, so adopt its ID
+	if res.Outcome != OutcomeInserted && res.Outcome != OutcomeUpdated {
+		return errors.New("SNOW did not create a change for " + m.SupplierRef + ", outcome: " + string(res.Outcome))
+	}
+
+	ur := Response{
+		SupplierRef: m.SupplierRef,
+		IntIdent:    res.IntIdent,
+	}
+
+	_, err = db.AddID(ctx, &ur)
+	if err != nil {
+		return err
+	}
+
+	m.IntID = res.IntIdent
+	return nil
+}
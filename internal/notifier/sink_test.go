@@ -0,0 +1,395 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSink records messages and returns a canned result
+type fakeSink struct {
+	name string
+	err  error
+	got  []Message
+}
+
+func (fs *fakeSink) Name() string {
+	return fs.name
+}
+
+func (fs *fakeSink) Deliver(ctx context.Context, m *Message) (*Result, error) {
+	fs.got = append(fs.got, *m)
+	m.IntID = fs.name
+	if fs.err != nil {
+		return nil, fs.err
+	}
+	return &Result{Outcome: OutcomeUpdated, IntIdent: fs.name}, nil
+}
+
+func TestDeliver(t *testing.T) {
+
+	os.Unsetenv("DEAD_LETTER_QUEUE_URL")
+
+	tt := []struct {
+		name   string
+		sinks  []*fakeSink
+		failed []string
+		linked string
+		err    string
+	}{
+		{name: "all good", sinks: []*fakeSink{{name: "a"}, {name: "b"}}},
+		{name: "snow ID passed on", sinks: []*fakeSink{{name: "snow"}, {name: "b"}}, linked: "snow"},
+		{name: "failed snow", sinks: []*fakeSink{{name: "snow", err: errors.New("boom")}, {name: "b"}}, failed: []string{"snow"},
+			err: "delivery failed for snow: boom"},
+		{name: "one bad", sinks: []*fakeSink{{name: "a", err: errors.New("boom")}, {name: "b"}}, failed: []string{"a"},
+			err: "delivery failed for a: boom"},
+		{name: "circuit open", sinks: []*fakeSink{{name: "a", err: ErrCircuitOpen}, {name: "b"}}, failed: []string{"a"},
+			err: "circuit breaker is open"},
+		{name: "rejected", sinks: []*fakeSink{{name: "snow", err: &StatusError{Code: 422, Message: "bad"}}, {name: "b"}}, failed: []string{"snow"},
+			err: "SNOW returned HTTP 422"},
+		{name: "no CI", sinks: []*fakeSink{{name: "a", err: fmt.Errorf("%w for abc-123", ErrNoCI)}, {name: "b"}}, failed: []string{"a"},
+			err: "no SNOW configuration item for abc-123"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			var sinks []Sink
+			for _, s := range tc.sinks {
+				sinks = append(sinks, s)
+			}
+
+			m := Message{MessageID: updateMsgID, Payload: Payload{SupplierRef: "abc-123"}}
+			ds, err := deliver(context.Background(), sinks, nil, &m)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			// every sink is tried and tracked on its own
+			if len(ds) != len(tc.sinks) {
+				t.Fatalf("expected %v deliveries, got %v", len(tc.sinks), len(ds))
+			}
+			for i, d := range ds {
+				if len(tc.sinks[i].got) != 1 {
+					t.Errorf("expected sink %v to be called once", d.Sink)
+				}
+				bad := d.Err != nil
+				if bad != contains(tc.failed, d.Sink) {
+					t.Errorf("unexpected result for sink %v: %v", d.Sink, d.Err)
+				}
+			}
+
+			// sinks don't see each other's references, bar the SNOW ID
+			if m.IntID != "" || tc.sinks[1].got[0].IntID != tc.linked {
+				t.Errorf("expected sinks to get their own copy of the message, got %q", tc.sinks[1].got[0].IntID)
+			}
+		})
+	}
+}
+
+func TestDeliverParked(t *testing.T) {
+
+	os.Setenv("DEAD_LETTER_QUEUE_URL", "https://sqs/dlq")
+	defer os.Unsetenv("DEAD_LETTER_QUEUE_URL")
+
+	tt := []struct {
+		name   string
+		err    error
+		parked bool
+	}{
+		{name: "rejected", err: &StatusError{Code: 400, Message: "bad"}, parked: true},
+		{name: "not found", err: fmt.Errorf("update failed: %w", &StatusError{Code: 404}), parked: true},
+		{name: "busy", err: &StatusError{Code: 503}},
+		{name: "no reply", err: errors.New("timeout")},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			ms := &mockSQS{}
+			dlq = &DLQ{SQS: ms, URL: "https://sqs/dlq"}
+			defer func() { dlq = nil }()
+
+			m := Message{MessageID: updateMsgID, Payload: Payload{SupplierRef: "abc-123"}}
+			ds, err := deliver(context.Background(), []Sink{&fakeSink{name: "snow", err: tc.err}}, nil, &m)
+
+			// permanent rejections go to the queue, anything else is retried
+			if tc.parked {
+				if err != nil || ds[0].Result.Outcome != OutcomeSkipped || ms.body == "" {
+					t.Errorf("expected message to be parked, got %+v: %v", ds[0], err)
+				}
+				return
+			}
+			if err == nil || ms.body != "" {
+				t.Errorf("expected a failure to retry, got %+v: %v", ds[0], err)
+			}
+		})
+	}
+}
+
+func TestFansOut(t *testing.T) {
+
+	one := map[string][]Webhook{"*": {{Type: "slack", URL: "http://a"}}, "ACP": {{Type: "teams", URL: "http://b"}}}
+	two := map[string][]Webhook{"ACP": {{Type: "slack", URL: "http://a"}, {Type: "teams", URL: "http://b"}}}
+
+	tt := []struct {
+		name   string
+		sinks  []Sink
+		expect bool
+	}{
+		{name: "snow", sinks: []Sink{&fakeSink{name: "snow"}}},
+		{name: "one webhook per project", sinks: []Sink{&chatSink{routes: one}}},
+		{name: "snow and chat", sinks: []Sink{&fakeSink{name: "snow"}, &chatSink{routes: one}}, expect: true},
+		{name: "two webhooks", sinks: []Sink{&chatSink{routes: two}}, expect: true},
+	}
+
+	for _, tc := range tt {
+		if got := fansOut(tc.sinks); got != tc.expect {
+			t.Errorf("%v: expected %v, got %v", tc.name, tc.expect, got)
+		}
+	}
+}
+
+func TestNewSinks(t *testing.T) {
+
+	tt := []struct {
+		name   string
+		sinks  string
+		expect []string
+		err    string
+	}{
+		{name: "default", expect: []string{"snow"}},
+		{name: "listed", sinks: "snow, snow", expect: []string{"snow", "snow"}},
+		{name: "unknown", sinks: "snow,pager", err: "unknown sink \"pager\""},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			os.Setenv("REGION", "eu")
+			os.Setenv("SINKS", tc.sinks)
+			defer os.Unsetenv("SINKS")
+
+			sinks, err := newSinks()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var names []string
+			for _, s := range sinks {
+				names = append(names, s.Name())
+			}
+			if strings.Join(names, ",") != strings.Join(tc.expect, ",") {
+				t.Errorf("expected %v, got %v", tc.expect, names)
+			}
+		})
+	}
+}
+
+func TestCreateFirst(t *testing.T) {
+
+	tt := []struct {
+		name   string
+		reply  string
+		expect string
+		err    string
+	}{
+		{name: "good", reply: `{"result":{"internal_identifier":"CHG001","log":"Inserting"}}`, expect: "CHG001"},
+		{name: "updated", reply: `{"result":{"internal_identifier":"CHG001","log":"Updating"}}`, expect: "CHG001"},
+		{name: "ignored", reply: `{"result":[{"status":"ignored","status_message":"No field values changed"}]}`, err: "did not create a change"},
+		{name: "error", reply: `{"error":{"message":"bad"}}`, err: "SNOW returned error"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			var sent Message
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&sent)
+				w.Write([]byte(tc.reply))
+			}))
+			defer srv.Close()
+
+			os.Setenv("SNOW_URL", srv.URL)
+			os.Setenv("TABLE_NAME", "bar")
+			os.Setenv("SNOW_CREDENTIALS_SOURCE", "env")
+			os.Setenv("SNOW_USERNAME", "user")
+			os.Setenv("SNOW_PASSWORD", "pass")
+			snow = nil
+
+			db := new(DB)
+			db.DynamoDB = &mockDynamoDB{}
+
+			m := Message{
+				MessageID: updateMsgID,
+				Payload:   Payload{SupplierRef: "abc-123", Status: "In Progress", Success: "true"},
+			}
+
+			err := m.createFirst(context.Background(), db)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if sent.MessageID != createMsgID || sent.Status != "Scheduled" || sent.Success != "" {
+				t.Errorf("expected create message for Scheduled change, got %+v", sent)
+			}
+			if m.IntID != tc.expect {
+				t.Errorf("expected IntID %v, got %v", tc.expect, m.IntID)
+			}
+			if m.Status != "In Progress" {
+				t.Errorf("expected update to keep status In Progress, got %v", m.Status)
+			}
+		})
+	}
+}
+
+func TestSnowSinkDeliver(t *testing.T) {
+
+	tt := []struct {
+		name    string
+		msg     Message
+		replies []string
+		ids     map[string]string
+		outcome Outcome
+		updates int
+		raised  string
+	}{
+		{name: "create", msg: Message{MessageID: createMsgID, Payload: Payload{SupplierRef: "abc-1", Status: "Scheduled"}},
+			replies: []string{`{"result":{"internal_identifier":"CHG001","log":"Inserting"}}`}, outcome: OutcomeInserted, updates: 1, raised: "Scheduled"},
+		{name: "update", msg: Message{MessageID: updateMsgID, IntID: "CHG001", Payload: Payload{SupplierRef: "abc-1", Status: "In Progress"}},
+			replies: []string{`{"result":{"internal_identifier":"CHG001","log":"Updating"}}`}, outcome: OutcomeUpdated, raised: "In Progress"},
+		{name: "late", msg: Message{MessageID: updateMsgID, Payload: Payload{SupplierRef: "abc-1", Status: "In Progress"}},
+			replies: []string{`{"result":{"internal_identifier":"CHG001","log":"Inserting"}}`, `{"result":{"internal_identifier":"CHG001","log":"Updating"}}`},
+			outcome: OutcomeUpdated, updates: 1, raised: "Scheduled"},
+		{name: "stored since", msg: Message{MessageID: updateMsgID, Payload: Payload{SupplierRef: "abc-1", Status: "In Progress"}},
+			ids:     map[string]string{"abc-1": "CHG001"},
+			replies: []string{`{"result":{"internal_identifier":"CHG001","log":"Updating"}}`}, outcome: OutcomeUpdated, raised: "In Progress"},
+		{name: "emergency", msg: Message{MessageID: updateMsgID, SkipScheduled: true,
+			Payload: Payload{SupplierRef: "abc-1", Status: "In Progress", ChangeType: ChangeEmergency}},
+			replies: []string{`{"result":{"internal_identifier":"CHG001","log":"Inserting"}}`}, outcome: OutcomeInserted, updates: 1, raised: "In Progress"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			var sent []Message
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var m Message
+				json.NewDecoder(r.Body).Decode(&m)
+				sent = append(sent, m)
+				w.Write([]byte(tc.replies[len(sent)-1]))
+			}))
+			defer srv.Close()
+
+			os.Setenv("SNOW_URL", srv.URL)
+			os.Setenv("TABLE_NAME", "bar")
+			os.Setenv("SNOW_CREDENTIALS_SOURCE", "env")
+			os.Setenv("SNOW_USERNAME", "user")
+			os.Setenv("SNOW_PASSWORD", "pass")
+			snow = nil
+
+			md := &mockDynamoDB{ids: tc.ids}
+			s := &snowSink{db: &DB{DynamoDB: md}}
+
+			res, err := s.Deliver(context.Background(), &tc.msg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res.Outcome != tc.outcome {
+				t.Errorf("expected outcome %v, got %v", tc.outcome, res.Outcome)
+			}
+			if len(sent) != len(tc.replies) {
+				t.Errorf("expected %v calls to SNOW, got %v", len(tc.replies), len(sent))
+			}
+			var ids int
+			for _, u := range md.updates {
+				if strings.Contains(*u.UpdateExpression, "internal_identifier") {
+					ids++
+				}
+			}
+			if ids != tc.updates {
+				t.Errorf("expected %v internal_identifier writes, got %v", tc.updates, ids)
+			}
+
+			// the attempt is recorded last
+			last := md.updates[len(md.updates)-1]
+			if !strings.Contains(*last.UpdateExpression, "lastSuccessAt") || *last.ExpressionAttributeValues[":st"].S != tc.msg.Status {
+				t.Errorf("expected delivery state to be recorded, got %v", last)
+			}
+			if sent[0].Status != tc.raised || sent[0].ChangeType != tc.msg.ChangeType {
+				t.Errorf("expected change raised %v as %q, got %v as %q", tc.raised, tc.msg.ChangeType, sent[0].Status, sent[0].ChangeType)
+			}
+			if sent[len(sent)-1].IntID != tc.msg.IntID {
+				t.Errorf("expected last message to carry %q, got %q", tc.msg.IntID, sent[len(sent)-1].IntID)
+			}
+		})
+	}
+}
+
+func TestSnowSinkConfigItems(t *testing.T) {
+
+	var sent []Message
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m Message
+		json.NewDecoder(r.Body).Decode(&m)
+		sent = append(sent, m)
+		w.Write([]byte(`{"result":{"internal_identifier":"CHG001","log":"Inserting"}}`))
+	}))
+	defer srv.Close()
+
+	os.Setenv("SNOW_URL", srv.URL)
+	os.Setenv("TABLE_NAME", "bar")
+	os.Setenv("SNOW_CREDENTIALS_SOURCE", "env")
+	os.Setenv("SNOW_USERNAME", "user")
+	os.Setenv("SNOW_PASSWORD", "pass")
+	snow = nil
+	ciCache.m = make(map[string]cachedCI)
+
+	cmdb := &CMDB{DynamoDB: &mockCMDBTable{items: map[string]string{"component:Platform": "ci-platform"}},
+		Table: "cmdb", TTL: time.Minute, now: time.Now}
+	s := &snowSink{db: &DB{DynamoDB: &mockDynamoDB{}}, cmdb: cmdb}
+
+	m := Message{MessageID: createMsgID, Payload: Payload{SupplierRef: "abc-1", Status: "Scheduled"},
+		Components: []string{"Platform"}}
+	_, err := s.Deliver(context.Background(), &m)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sent) != 1 || sent[0].ConfigItem != "ci-platform" {
+		t.Errorf("expected the change to carry its CI, got %+v", sent)
+	}
+
+	// an unmapped component stops the change before it reaches SNOW
+	m = Message{MessageID: createMsgID, Payload: Payload{SupplierRef: "abc-2", Status: "Scheduled"},
+		Components: []string{"Billing"}}
+	_, err = s.Deliver(context.Background(), &m)
+	if !errors.Is(err, ErrNoCI) || !strings.Contains(err.Error(), "component:Billing not mapped in cmdb") {
+		t.Errorf("expected a missing mapping, got: %v", err)
+	}
+	if len(sent) != 1 {
+		t.Errorf("expected nothing more sent to SNOW, got %v calls", len(sent))
+	}
+}
@@ -0,0 +1,155 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/snowtime"
+)
+
+// templates are parsed once per cold start, keyed by message type
+var (
+	templates   map[string]*template.Template
+	templateDir string
+	templatesMu sync.Mutex
+)
+
+// funcs are available to outbound templates
+var funcs = template.FuncMap{
+	"json":     toJSON,
+	"truncate": truncate,
+	"date":     date,
+	"now":      now,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"trim":     strings.TrimSpace,
+	"default":  orDefault,
+}
+
+// loadTemplates parses every *.tmpl file in SNOW_TEMPLATE_DIR. A template
+// is named after its message type, e.g. HO_SIAM_IN_REST_CHG_POST_JSON.tmpl,
+// and default.tmpl is used for types without their own.
+func loadTemplates() (map[string]*template.Template, error) {
+
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+
+	dir := os.Getenv("SNOW_TEMPLATE_DIR")
+	if templates != nil && dir == templateDir {
+		return templates, nil
+	}
+
+	tmpls := make(map[string]*template.Template)
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			b, err := ioutil.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+
+			name := strings.TrimSuffix(filepath.Base(f), ".tmpl")
+			t, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(string(b))
+			if err != nil {
+				return nil, err
+			}
+			tmpls[name] = t
+		}
+	}
+
+	templates = tmpls
+	templateDir = dir
+	return templates, nil
+}
+
+// render builds the SNOW request body, falling back to the Message struct
+// when no template is configured
+func render(m *Message) ([]byte, error) {
+
+	tmpls, err := loadTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	t, ok := tmpls[m.MessageID]
+	if !ok {
+		t, ok = tmpls["default"]
+	}
+	if !ok {
+		return json.Marshal(m)
+	}
+
+	var buf bytes.Buffer
+	err = t.Execute(&buf, m)
+	if err != nil {
+		return nil, err
+	}
+
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("template %v did not render valid JSON", t.Name())
+	}
+	return buf.Bytes(), nil
+}
+
+// toJSON escapes a value for use inside a JSON document, quotes included
+func toJSON(v interface{}) (string, error) {
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// truncate shortens s to n characters, marking the cut with an ellipsis
+func truncate(n int, s string) string {
+
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n <= 3 {
+		return string([]rune(s)[:n])
+	}
+	return string([]rune(s)[:n-3]) + "..."
+}
+
+// date reformats a stored SNOW time with another layout
+func date(layout, s string) (string, error) {
+
+	if s == "" {
+		return "", nil
+	}
+
+	t, err := snowtime.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(layout), nil
+}
+
+// now formats the current time
+func now(layout string) string {
+	return time.Now().Format(layout)
+}
+
+// orDefault returns def when s is empty
+func orDefault(def, s string) string {
+
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,94 @@
+package notifier
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	post := `{"type":"create","ref":{{json .SupplierRef}},"title":{{json (truncate 10 .Title)}},"start":{{json (date "02/01/2006 15:04" .StartTime)}}}`
+	def := `{"type":{{json (lower .MessageID)}},"id":{{json (default "none" .IntID)}}}`
+	ioutil.WriteFile(filepath.Join(dir, createMsgID+".tmpl"), []byte(post), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "default.tmpl"), []byte(def), 0600)
+
+	bad, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(bad)
+	ioutil.WriteFile(filepath.Join(bad, "default.tmpl"), []byte(`{"title":{{.Title}}}`), 0600)
+
+	tt := []struct {
+		name   string
+		dir    string
+		msg    Message
+		expect string
+		err    string
+	}{
+		{name: "no templates", msg: Message{MessageID: createMsgID, Payload: Payload{SupplierRef: "abc-1"}},
+			expect: `{"messageid":"HO_SIAM_IN_REST_CHG_POST_JSON","payload":{"supplierRef":"abc-1","status":"","title":"","description":"","startTime":"","endTime":""}}`},
+		{name: "per type", dir: dir, msg: Message{MessageID: createMsgID,
+			Payload: Payload{SupplierRef: "abc-1", Title: `a "quoted" long title`, StartTime: "2020-09-01 18:30:00"}},
+			expect: `{"type":"create","ref":"abc-1","title":"a \"quot...","start":"01/09/2020 18:30"}`},
+		{name: "default", dir: dir, msg: Message{MessageID: updateMsgID},
+			expect: `{"type":"ho_siam_in_rest_chg_update_json","id":"none"}`},
+		{name: "invalid json", dir: bad, msg: Message{MessageID: updateMsgID, Payload: Payload{Title: "foo"}},
+			err: "did not render valid JSON"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			os.Setenv("SNOW_TEMPLATE_DIR", tc.dir)
+			defer os.Unsetenv("SNOW_TEMPLATE_DIR")
+
+			b, err := render(&tc.msg)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(b) != tc.expect {
+				t.Errorf("expected %s, got %s", tc.expect, b)
+			}
+			if !json.Valid(b) {
+				t.Errorf("rendered invalid JSON: %s", b)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+
+	tt := []struct {
+		n      int
+		input  string
+		expect string
+	}{
+		{n: 10, input: "short", expect: "short"},
+		{n: 8, input: "much too long", expect: "much ..."},
+		{n: 2, input: "long", expect: "lo"},
+		{n: 4, input: "ééééé", expect: "é..."},
+	}
+
+	for _, tc := range tt {
+		if got := truncate(tc.n, tc.input); got != tc.expect {
+			t.Errorf("expected %q, got %q", tc.expect, got)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package reconciler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/notifier"
+	"github.com/UKHomeOffice/snow-forwarder/internal/snowtime"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Mismatch kinds
+const (
+	// KindMissing is a change SNOW doesn't have under its stored ID. These
+	// are only reported, even with RECONCILE_FIX, as raising the change
+	// again could duplicate one SNOW renumbered or merged.
+	KindMissing = "missing"
+	// KindDrift is a change whose state or window differs in SNOW
+	KindDrift = "drift"
+)
+
+// defaultStates are the SNOW change states that match each JSD status
+var defaultStates = map[string][]string{
+	"Scheduled":   {"-5", "-4", "-3", "-2"},
+	"In Progress": {"-1"},
+	"Completed":   {"0", "3"},
+	"Cancelled":   {"4"},
+	"Canceled":    {"4"},
+}
+
+// Mismatch is a change that differs between the table and SNOW
+type Mismatch struct {
+	SupplierRef string   `json:"supplierRef"`
+	IntIdent    string   `json:"internal_identifier"`
+	Kind        string   `json:"kind"`
+	Fields      []string `json:"fields,omitempty"`
+	Detail      string   `json:"detail"`
+	Fixed       bool     `json:"fixed"`
+}
+
+// Report is the outcome of a reconciliation run
+type Report struct {
+	Checked    int        `json:"checked"`
+	Matched    int        `json:"matched"`
+	Unlinked   int        `json:"unlinked"`
+	Mismatches []Mismatch `json:"mismatches"`
+	Fixed      int        `json:"fixed"`
+	Errors     []string   `json:"errors,omitempty"`
+}
+
+// Handler compares every change in the table with SNOW, on a schedule
+func Handler(ctx context.Context, e events.CloudWatchEvent) (*Report, error) {
+
+	db, err := newDB()
+	if err != nil {
+		return nil, err
+	}
+
+	states, err := stateMap()
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := db.Items(ctx)
+	if err != nil {
+		log.Printf("could not read changes: %v", err)
+		return nil, err
+	}
+
+	var snow notifier.Sink
+	if os.Getenv("RECONCILE_FIX") == "true" {
+		snow, err = notifier.NewSnowSink()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rep := reconcile(ctx, items, states, snow)
+
+	log.Printf("reconciled %v changes: %v matched, %v mismatched, %v fixed, %v without a SNOW ID, %v errors",
+		rep.Checked, rep.Matched, len(rep.Mismatches), rep.Fixed, rep.Unlinked, len(rep.Errors))
+	return rep, nil
+}
+
+// reconcile checks each item against SNOW, re-sending drifted changes
+// through the SNOW sink when there is one; missing ones are left for a
+// person to look at. Changes not reached before ctx is done are reported as
+// errors.
+func reconcile(ctx context.Context, items []Item, states map[string][]string, snow notifier.Sink) *Report {
+
+	rep := &Report{Mismatches: []Mismatch{}}
+
+	for i, it := range items {
+		if ctx.Err() != nil {
+			log.Printf("stopping with %v changes left to check: %v", len(items)-i, ctx.Err())
+			rep.Errors = append(rep.Errors, fmt.Sprintf("%v changes not checked: %v", len(items)-i, ctx.Err()))
+			break
+		}
+		rep.Checked++
+
+		// records that never got an ID have nothing to compare, so are only
+		// counted
+		if it.IntIdent == "" {
+			rep.Unlinked++
+			continue
+		}
+
+		changes, err := notifier.LookupNumber(ctx, it.IntIdent)
+		if err != nil {
+			log.Printf("could not look up %v in SNOW: %v", it.SupplierRef, err)
+			rep.Errors = append(rep.Errors, fmt.Sprintf("%v: %v", it.SupplierRef, err))
+			continue
+		}
+
+		if len(changes) == 0 {
+			mm := Mismatch{SupplierRef: it.SupplierRef, IntIdent: it.IntIdent, Kind: KindMissing,
+				Detail: "change not found in SNOW"}
+			log.Printf("mismatch for %v: %v", it.SupplierRef, mm.Detail)
+			rep.Mismatches = append(rep.Mismatches, mm)
+			continue
+		}
+
+		fields, detail := compare(it, changes[0], states)
+		if len(fields) == 0 {
+			rep.Matched++
+			continue
+		}
+
+		mm := Mismatch{SupplierRef: it.SupplierRef, IntIdent: it.IntIdent, Kind: KindDrift, Fields: fields, Detail: detail}
+		log.Printf("mismatch for %v: %v", it.SupplierRef, detail)
+
+		// re-sending a change the sweeper closed would reopen it
+		if snow != nil && fixable(it.Status) && it.OverdueClosedAt == "" {
+			err = correct(ctx, snow, it)
+			if err != nil {
+				log.Printf("could not correct %v: %v", it.SupplierRef, err)
+				rep.Errors = append(rep.Errors, fmt.Sprintf("%v: %v", it.SupplierRef, err))
+			} else {
+				mm.Fixed = true
+				rep.Fixed++
+			}
+		}
+		rep.Mismatches = append(rep.Mismatches, mm)
+	}
+	return rep
+}
+
+// compare lists the fields that differ between an item and SNOW, with a
+// description for the report. A change the sweeper closed as overdue should
+// be Completed in SNOW, whatever the table holds.
+func compare(it Item, c notifier.Change, states map[string][]string) ([]string, string) {
+
+	var fields, detail []string
+
+	status := it.Status
+	if it.OverdueClosedAt != "" {
+		status = "Completed"
+	}
+
+	if allowed, ok := states[status]; ok && !contains(allowed, c.State) {
+		fields = append(fields, "status")
+		detail = append(detail, fmt.Sprintf("status %v but SNOW state %v", status, c.State))
+	}
+
+	if it.StartTime != "" && snowtime.UTC(it.StartTime) != c.StartDate {
+		fields = append(fields, "startTime")
+		detail = append(detail, fmt.Sprintf("startTime %v but SNOW start_date %v UTC", it.StartTime, c.StartDate))
+	}
+
+	if it.EndTime != "" && snowtime.UTC(it.EndTime) != c.EndDate {
+		fields = append(fields, "endTime")
+		detail = append(detail, fmt.Sprintf("endTime %v but SNOW end_date %v UTC", it.EndTime, c.EndDate))
+	}
+	return fields, strings.Join(detail, ", ")
+}
+
+// fixable reports whether the notifier sends this status to SNOW, so a
+// re-send can correct it
+func fixable(status string) bool {
+	return status == "Scheduled" || status == "In Progress" || status == "Completed"
+}
+
+// correct re-sends the change as the table holds it, with its CIs and
+// change model, so the SNOW sink records the delivery
+func correct(ctx context.Context, snow notifier.Sink, it Item) error {
+
+	m := notifier.NewUpdate(it.IntIdent, notifier.Payload{
+		SupplierRef: it.SupplierRef,
+		Status:      it.Status,
+		Title:       it.Title,
+		Description: it.Description,
+		StartTime:   it.StartTime,
+		EndTime:     it.EndTime,
+	})
+
+	m.Components = it.Components
+	m.Assets = it.Assets
+
+	md, ok, err := notifier.ModelFor(it.IssueType, it.RequestType, it.Labels)
+	if err != nil {
+		return err
+	}
+	if ok {
+		m.SetModel(md)
+	}
+
+	res, err := snow.Deliver(ctx, m)
+	if err != nil {
+		return err
+	}
+	if res.Outcome != notifier.OutcomeUpdated && res.Outcome != notifier.OutcomeInserted {
+		return fmt.Errorf("SNOW %v the correction: %v", res.Outcome, res.Message)
+	}
+	log.Printf("corrected %v in SNOW", it.SupplierRef)
+	return nil
+}
+
+// stateMap reads SNOW_STATE_MAP, a JSON object of JSD status to the SNOW
+// states that match it
+func stateMap() (map[string][]string, error) {
+
+	v, ok := os.LookupEnv("SNOW_STATE_MAP")
+	if !ok || v == "" {
+		return defaultStates, nil
+	}
+
+	var states map[string][]string
+	err := json.Unmarshal([]byte(v), &states)
+	if err != nil {
+		return nil, errors.New("could not parse SNOW_STATE_MAP: " + err.Error())
+	}
+	return states, nil
+}
+
+// contains reports whether s is in list
+func contains(list []string, s string) bool {
+
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
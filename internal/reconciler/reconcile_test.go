@@ -0,0 +1,184 @@
+package reconciler
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/notifier"
+	"github.com/UKHomeOffice/snow-forwarder/internal/snowmock"
+)
+
+// fakeSnow stands in for the SNOW sink, sending straight to SNOW
+type fakeSnow struct {
+	sent []*notifier.Message
+}
+
+func (fs *fakeSnow) Name() string {
+	return "snow"
+}
+
+func (fs *fakeSnow) Deliver(ctx context.Context, m *notifier.Message) (*notifier.Result, error) {
+	fs.sent = append(fs.sent, m)
+	return m.Notify(ctx)
+}
+
+func TestReconcile(t *testing.T) {
+
+	mock := snowmock.New()
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+
+	env := map[string]string{
+		"SNOW_URL":                srv.URL + "/api/now/import/u_siam_change_inbound",
+		"SNOW_TABLE_URL":          srv.URL + "/api/now/table/change_request",
+		"SNOW_CREDENTIALS_SOURCE": "env",
+		"SNOW_USERNAME":           "forwarder",
+		"SNOW_PASSWORD":           "s3cr3t",
+		"CHANGE_MODELS":           `[{"issueType":"Emergency Change","type":"emergency","category":"Software"}]`,
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	// 10:00 in London in July is 09:00 UTC
+	item := Item{SupplierRef: "ACP-1", Status: "In Progress", StartTime: "2020-07-01 10:00:00",
+		EndTime: "2020-07-01 12:00:00", IntIdent: "CHG0000001", IssueType: "Emergency Change", Components: []string{"Billing"}}
+	inSNOW := snowmock.Record{Number: "CHG0000001", SupplierRef: "ACP-1", State: "-1",
+		StartDate: "2020-07-01 09:00:00", EndDate: "2020-07-01 11:00:00"}
+
+	closed := item
+	closed.OverdueClosedAt = "2020-07-02T12:00:00Z"
+
+	tt := []struct {
+		name     string
+		item     Item
+		snow     *snowmock.Record
+		fix      bool
+		matched  int
+		unlinked int
+		kind     string
+		fields   []string
+		fixed    bool
+	}{
+		{name: "matched", item: item, snow: &inSNOW, matched: 1},
+		{name: "unlinked", item: Item{SupplierRef: "ACP-1", Status: "Scheduled"}, unlinked: 1},
+		{name: "missing", item: item, kind: KindMissing},
+		{name: "missing report only", item: item, fix: true, kind: KindMissing},
+		{name: "state", item: item, snow: &snowmock.Record{Number: "CHG0000001", SupplierRef: "ACP-1", State: "-2",
+			StartDate: "2020-07-01 09:00:00", EndDate: "2020-07-01 11:00:00"}, kind: KindDrift, fields: []string{"status"}},
+		{name: "window", item: item, snow: &snowmock.Record{Number: "CHG0000001", SupplierRef: "ACP-1", State: "-1",
+			StartDate: "2020-07-01 09:00:00", EndDate: "2020-07-01 15:00:00"}, kind: KindDrift, fields: []string{"endTime"}},
+		{name: "fixed", item: item, fix: true, snow: &snowmock.Record{Number: "CHG0000001", SupplierRef: "ACP-1", State: "-2",
+			StartDate: "2020-07-01 08:00:00", EndDate: "2020-07-01 11:00:00"}, kind: KindDrift, fields: []string{"status", "startTime"}, fixed: true},
+		{name: "closed as overdue", item: closed, snow: &snowmock.Record{Number: "CHG0000001", SupplierRef: "ACP-1", State: "3",
+			StartDate: "2020-07-01 09:00:00", EndDate: "2020-07-01 11:00:00"}, matched: 1},
+		{name: "closed as overdue not reopened", item: closed, fix: true, snow: &inSNOW, kind: KindDrift, fields: []string{"status"}},
+		{name: "cancelled not sent", fix: true, item: Item{SupplierRef: "ACP-1", Status: "Cancelled", IntIdent: "CHG0000001"},
+			snow: &inSNOW, kind: KindDrift, fields: []string{"status"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			mock.Reset()
+			if tc.snow != nil {
+				mock.Put(*tc.snow)
+			}
+
+			var snow notifier.Sink
+			fs := &fakeSnow{}
+			if tc.fix {
+				snow = fs
+			}
+
+			rep := reconcile(context.Background(), []Item{tc.item}, defaultStates, snow)
+
+			if len(rep.Errors) > 0 {
+				t.Fatalf("unexpected errors: %v", rep.Errors)
+			}
+			if rep.Checked != 1 || rep.Matched != tc.matched || rep.Unlinked != tc.unlinked {
+				t.Errorf("unexpected counts: %+v", rep)
+			}
+
+			if tc.kind == "" {
+				if len(rep.Mismatches) != 0 {
+					t.Errorf("expected no mismatches, got %+v", rep.Mismatches)
+				}
+				return
+			}
+
+			if len(rep.Mismatches) != 1 {
+				t.Fatalf("expected one mismatch, got %+v", rep.Mismatches)
+			}
+			mm := rep.Mismatches[0]
+			if mm.Kind != tc.kind || !reflect.DeepEqual(mm.Fields, tc.fields) || mm.Fixed != tc.fixed {
+				t.Errorf("expected %v %v fixed %v, got %+v", tc.kind, tc.fields, tc.fixed, mm)
+			}
+
+			// a correction brings SNOW back in line with the table
+			if tc.fixed {
+				c, _ := mock.Change("ACP-1")
+				if c.State != "-1" || c.StartDate != "2020-07-01 09:00:00" {
+					t.Errorf("expected SNOW to be corrected, got %+v", c)
+				}
+
+				// it goes through the SNOW sink with the change's CIs and model
+				if len(fs.sent) != 1 || fs.sent[0].ChangeType != "emergency" || !reflect.DeepEqual(fs.sent[0].Components, []string{"Billing"}) {
+					t.Errorf("expected the correction with its CIs and model, got %+v", fs.sent)
+				}
+			} else if len(mock.Requests()) != 0 {
+				t.Errorf("expected nothing sent to SNOW, got %v", len(mock.Requests()))
+			}
+		})
+	}
+}
+
+func TestReconcileCancelled(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rep := reconcile(ctx, []Item{{SupplierRef: "ACP-1", IntIdent: "CHG0000001"}, {SupplierRef: "ACP-2"}}, defaultStates, nil)
+
+	if rep.Checked != 0 || len(rep.Errors) != 1 || !strings.Contains(rep.Errors[0], "2 changes not checked") {
+		t.Errorf("expected nothing checked, got %+v", rep)
+	}
+}
+
+func TestStateMap(t *testing.T) {
+
+	tt := []struct {
+		name   string
+		value  string
+		expect map[string][]string
+		err    string
+	}{
+		{name: "default", expect: defaultStates},
+		{name: "custom", value: `{"Scheduled":["-2"]}`, expect: map[string][]string{"Scheduled": {"-2"}}},
+		{name: "invalid", value: `["-2"]`, err: "could not parse SNOW_STATE_MAP"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			os.Setenv("SNOW_STATE_MAP", tc.value)
+			defer os.Unsetenv("SNOW_STATE_MAP")
+
+			states, err := stateMap()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if !reflect.DeepEqual(states, tc.expect) {
+				t.Errorf("expected %v, got %v", tc.expect, states)
+			}
+		})
+	}
+}
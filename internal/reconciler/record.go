@@ -0,0 +1,90 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// DB wraps DynamodDB with iface pkg for easier testing
+type DB struct {
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+func newDB() (*DB, error) {
+
+	var db = new(DB)
+	reg, ok := os.LookupEnv("REGION")
+	if !ok {
+		return nil, errors.New("missing AWS region")
+	}
+
+	awsConfig := aws.Config{
+		Region: aws.String(reg),
+	}
+
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+		Config:            awsConfig,
+	}))
+
+	svc := dynamodb.New(sess, aws.NewConfig())
+	db.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	return db, nil
+}
+
+// Item is a change as the listener recorded it
+type Item struct {
+	SupplierRef string   `dynamodbav:"supplierRef"`
+	Status      string   `dynamodbav:"status"`
+	Title       string   `dynamodbav:"title"`
+	Description string   `dynamodbav:"description"`
+	StartTime   string   `dynamodbav:"startTime"`
+	EndTime     string   `dynamodbav:"endTime"`
+	IntIdent    string   `dynamodbav:"internal_identifier"`
+	IssueType   string   `dynamodbav:"issueType"`
+	RequestType string   `dynamodbav:"requestType"`
+	Labels      []string `dynamodbav:"labels"`
+	Components  []string `dynamodbav:"components"`
+	Assets      []string `dynamodbav:"assets"`
+	// OverdueClosedAt is set when the sweeper closed the change in SNOW
+	OverdueClosedAt string `dynamodbav:"overdueClosedAt"`
+}
+
+// Items reads every change in the table
+func (d *DB) Items(ctx context.Context) ([]Item, error) {
+
+	tab, ok := os.LookupEnv("TABLE_NAME")
+	if !ok {
+		return nil, errors.New("missing table name")
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(tab),
+	}
+
+	var items []Item
+	var uerr error
+	err := d.DynamoDB.ScanPagesWithContext(ctx, input, func(page *dynamodb.ScanOutput, last bool) bool {
+		var batch []Item
+		uerr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &batch)
+		if uerr != nil {
+			return false
+		}
+		items = append(items, batch...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if uerr != nil {
+		return nil, errors.New("could not read changes: " + uerr.Error())
+	}
+	return items, nil
+}
@@ -0,0 +1,61 @@
+package reconciler
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+type mockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	pages [][]map[string]*dynamodb.AttributeValue
+}
+
+func (md *mockDynamoDB) ScanPagesWithContext(ctx aws.Context, input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool, opts ...request.Option) error {
+
+	for i, p := range md.pages {
+		if !fn(&dynamodb.ScanOutput{Items: p}, i == len(md.pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestItems(t *testing.T) {
+
+	os.Setenv("TABLE_NAME", "changes")
+	defer os.Unsetenv("TABLE_NAME")
+
+	item := func(ref, id string) map[string]*dynamodb.AttributeValue {
+		m := map[string]*dynamodb.AttributeValue{
+			"supplierRef": {S: aws.String(ref)},
+			"status":      {S: aws.String("Scheduled")},
+		}
+		if id != "" {
+			m["internal_identifier"] = &dynamodb.AttributeValue{S: aws.String(id)}
+		}
+		return m
+	}
+
+	db := DB{DynamoDB: &mockDynamoDB{pages: [][]map[string]*dynamodb.AttributeValue{
+		{item("ACP-1", "CHG0000001"), item("ACP-2", "")},
+		{item("ACP-3", "CHG0000003")},
+	}}}
+
+	items, err := db.Items(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(items) != 3 {
+		t.Fatalf("expected 3 items, got %v", len(items))
+	}
+	if items[0].IntIdent != "CHG0000001" || items[1].IntIdent != "" || items[2].SupplierRef != "ACP-3" {
+		t.Errorf("unexpected items: %+v", items)
+	}
+}
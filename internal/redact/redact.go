@@ -0,0 +1,191 @@
+package redact
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Mask replaces anything redacted
+const Mask = "[REDACTED]"
+
+// defaultFields have their values masked wherever they appear as JSON keys
+// or key=value pairs
+var defaultFields = []string{
+	"description",
+	"password",
+	"secret",
+	"client_secret",
+	"token",
+	"access_token",
+	"refresh_token",
+	"authorization",
+}
+
+// defaultPatterns catch sensitive values wherever they appear
+var defaultPatterns = []string{
+	// IPv4 addresses
+	`\b(?:\d{1,3}\.){3}\d{1,3}\b`,
+	// email addresses
+	`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	// bearer and basic auth headers
+	`(?i)\b(?:bearer|basic)\s+[A-Za-z0-9._~+/=-]+`,
+}
+
+// Redactor masks sensitive values in strings
+type Redactor struct {
+	rules []*regexp.Regexp
+	keep  []string
+}
+
+// New compiles a redactor for the given field names and patterns
+func New(fields, patterns []string) (*Redactor, error) {
+
+	var r Redactor
+
+	for _, f := range fields {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		q := regexp.QuoteMeta(f)
+
+		// "field": "value", keeping the key so lines stay readable
+		r.rules = append(r.rules, regexp.MustCompile(`(?i)("`+q+`"\s*:\s*)"(?:[^"\\]|\\.)*"`))
+		r.keep = append(r.keep, `$1"`+Mask+`"`)
+
+		// field=value
+		r.rules = append(r.rules, regexp.MustCompile(`(?i)(\b`+q+`=)[^\s&,"]+`))
+		r.keep = append(r.keep, `${1}`+Mask)
+	}
+
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %v", p, err)
+		}
+		r.rules = append(r.rules, re)
+		r.keep = append(r.keep, Mask)
+	}
+	return &r, nil
+}
+
+// String masks every rule match in s. A nil Redactor passes s through.
+func (r *Redactor) String(s string) string {
+
+	if r == nil {
+		return s
+	}
+	for i, re := range r.rules {
+		s = re.ReplaceAllString(s, r.keep[i])
+	}
+	return s
+}
+
+// FromEnv builds a redactor from REDACT_FIELDS, a comma separated list of
+// field names, and REDACT_PATTERNS, a JSON array of regular expressions.
+// Both add to the defaults. LOG_VERBOSE=true turns redaction off.
+func FromEnv() (*Redactor, error) {
+
+	if Verbose() {
+		return nil, nil
+	}
+
+	fields := defaultFields
+	if v, ok := os.LookupEnv("REDACT_FIELDS"); ok && v != "" {
+		fields = append(append([]string{}, fields...), strings.Split(v, ",")...)
+	}
+
+	patterns := defaultPatterns
+	if v, ok := os.LookupEnv("REDACT_PATTERNS"); ok && v != "" {
+		var extra []string
+		if err := json.Unmarshal([]byte(v), &extra); err != nil {
+			return nil, errors.New("could not parse REDACT_PATTERNS: " + err.Error())
+		}
+		patterns = append(append([]string{}, patterns...), extra...)
+	}
+
+	return New(fields, patterns)
+}
+
+// Verbose reports whether LOG_VERBOSE is set, which logs full payloads
+// without redaction. Only enable it while debugging.
+func Verbose() bool {
+
+	v, _ := os.LookupEnv("LOG_VERBOSE")
+	return v == "true"
+}
+
+// std redacts package level helpers and the standard logger
+var (
+	std   *Redactor
+	stdMu sync.RWMutex
+)
+
+// Setup configures redaction from the environment and applies it to the
+// standard logger
+func Setup() error {
+
+	r, err := FromEnv()
+	if err != nil {
+		return err
+	}
+
+	stdMu.Lock()
+	std = r
+	stdMu.Unlock()
+
+	log.SetOutput(NewWriter(os.Stderr, r))
+	if r == nil {
+		log.Println("LOG_VERBOSE is set, logs are not redacted")
+	}
+	return nil
+}
+
+// String masks s with the configured redactor
+func String(s string) string {
+
+	stdMu.RLock()
+	defer stdMu.RUnlock()
+	return std.String(s)
+}
+
+// Error returns err with its message masked, or nil
+func Error(err error) error {
+
+	if err == nil {
+		return nil
+	}
+	s := String(err.Error())
+	if s == err.Error() {
+		return err
+	}
+	return errors.New(s)
+}
+
+// Writer masks everything written through it
+type Writer struct {
+	w io.Writer
+	r *Redactor
+}
+
+// NewWriter wraps w. The standard logger writes one line per call, so
+// matches are never split across writes.
+func NewWriter(w io.Writer, r *Redactor) *Writer {
+	return &Writer{w: w, r: r}
+}
+
+// Write masks p before passing it on, reporting the original length
+func (wr *Writer) Write(p []byte) (int, error) {
+
+	if _, err := wr.w.Write([]byte(wr.r.String(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,116 @@
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+
+	r, err := New(defaultFields, defaultPatterns)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tt := []struct {
+		name   string
+		input  string
+		expect string
+	}{
+		{name: "payload", input: `{"supplierRef":"ACP-1","description":"reboot web-1 at 10.0.0.12"}`,
+			expect: `{"supplierRef":"ACP-1","description":"[REDACTED]"}`},
+		{name: "escaped quotes", input: `{"description": "say \"hi\"", "title":"x"}`,
+			expect: `{"description": "[REDACTED]", "title":"x"}`},
+		{name: "ip", input: "could not reach 192.168.1.20:443", expect: "could not reach [REDACTED]:443"},
+		{name: "email", input: "approved by jane.doe@example.gov.uk", expect: "approved by [REDACTED]"},
+		{name: "bearer", input: "Authorization: Bearer abc.def-123", expect: "Authorization: [REDACTED]"},
+		{name: "form", input: "grant_type=client_credentials&client_secret=s3cr3t&scope=x",
+			expect: "grant_type=client_credentials&client_secret=[REDACTED]&scope=x"},
+		{name: "case", input: `{"Password":"hunter2"}`, expect: `{"Password":"[REDACTED]"}`},
+		{name: "untouched", input: "updated ACP-1 with Scheduled on table changes", expect: "updated ACP-1 with Scheduled on table changes"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := r.String(tc.input); got != tc.expect {
+				t.Errorf("expected %q, got %q", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+
+	tt := []struct {
+		name   string
+		env    map[string]string
+		input  string
+		expect string
+		err    string
+	}{
+		{name: "defaults", input: `{"title":"web-1"} 10.1.1.1`, expect: `{"title":"web-1"} [REDACTED]`},
+		{name: "extra field", env: map[string]string{"REDACT_FIELDS": "title"},
+			input: `{"title":"web-1"}`, expect: `{"title":"[REDACTED]"}`},
+		{name: "extra pattern", env: map[string]string{"REDACT_PATTERNS": `["web-\\d+"]`},
+			input: "rebooting web-1", expect: "rebooting [REDACTED]"},
+		{name: "verbose", env: map[string]string{"LOG_VERBOSE": "true"},
+			input: `{"description":"10.1.1.1"}`, expect: `{"description":"10.1.1.1"}`},
+		{name: "bad json", env: map[string]string{"REDACT_PATTERNS": "web-1"}, err: "could not parse REDACT_PATTERNS"},
+		{name: "bad pattern", env: map[string]string{"REDACT_PATTERNS": `["("]`}, err: "invalid redaction pattern"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			for k, v := range tc.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			r, err := FromEnv()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := r.String(tc.input); got != tc.expect {
+				t.Errorf("expected %q, got %q", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestSetup(t *testing.T) {
+
+	defer log.SetOutput(os.Stderr)
+
+	if err := Setup(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the standard logger is redacted
+	var buf bytes.Buffer
+	log.SetOutput(NewWriter(&buf, std))
+	log.Printf("the payload that will be sent: %v", `{"description":"patch 10.0.0.1"}`)
+	if strings.Contains(buf.String(), "10.0.0.1") || !strings.Contains(buf.String(), Mask) {
+		t.Errorf("expected redacted log line, got %q", buf.String())
+	}
+
+	// and so are errors
+	err := Error(errors.New("dial tcp 10.0.0.1:443: timeout"))
+	if err.Error() != "dial tcp [REDACTED]:443: timeout" {
+		t.Errorf("unexpected error message: %v", err)
+	}
+	if Error(nil) != nil {
+		t.Errorf("expected nil error")
+	}
+}
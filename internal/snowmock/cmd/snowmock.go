@@ -0,0 +1,25 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/snowmock"
+)
+
+func main() {
+
+	s, err := snowmock.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	addr, ok := os.LookupEnv("LISTEN_ADDR")
+	if !ok {
+		addr = ":8080"
+	}
+
+	log.Printf("mock SNOW listening on %v", addr)
+	log.Fatal(http.ListenAndServe(addr, s))
+}
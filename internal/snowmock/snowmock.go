@@ -0,0 +1,536 @@
+package snowmock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/snowtime"
+)
+
+// SNOW message types, as sent by the notifier
+const (
+	createMsgID = "HO_SIAM_IN_REST_CHG_POST_JSON"
+	updateMsgID = "HO_SIAM_IN_REST_CHG_UPDATE_JSON"
+)
+
+// Kinds of scripted reply
+const (
+	// KindAuto inserts or updates the change the way SNOW would
+	KindAuto = "auto"
+	// KindInsert always inserts a new change
+	KindInsert = "insert"
+	// KindUpdate updates the change, even if the mock hasn't seen it
+	KindUpdate = "update"
+	// KindIgnored and KindSkipped leave the change untouched
+	KindIgnored = "ignored"
+	KindSkipped = "skipped"
+	// KindError is a transform error on an accepted request
+	KindError = "error"
+	// KindUnauthorized rejects the credentials
+	KindUnauthorized = "unauthorized"
+	// KindThrottle replies 429 with Retry-After
+	KindThrottle = "throttle"
+	// KindServerError is a platform error with an HTTP 500
+	KindServerError = "server_error"
+	// KindMalformed replies 200 with a body that isn't JSON
+	KindMalformed = "malformed"
+)
+
+// Step is one scripted reply. Steps are used in order, one per request,
+// after which the server falls back to KindAuto.
+type Step struct {
+	Kind    string        `json:"kind"`
+	Delay   time.Duration `json:"-"`
+	Message string        `json:"message,omitempty"`
+	// Code overrides the HTTP status
+	Code int `json:"code,omitempty"`
+}
+
+// UnmarshalJSON reads the delay as a duration string, e.g. "2s"
+func (s *Step) UnmarshalJSON(b []byte) error {
+
+	type step Step
+	aux := struct {
+		*step
+		Delay string `json:"delay"`
+	}{step: (*step)(s)}
+
+	err := json.Unmarshal(b, &aux)
+	if err != nil {
+		return err
+	}
+
+	if aux.Delay != "" {
+		s.Delay, err = time.ParseDuration(aux.Delay)
+		if err != nil {
+			return fmt.Errorf("invalid delay %q", aux.Delay)
+		}
+	}
+	return nil
+}
+
+// Request is a message received by the mock
+type Request struct {
+	MessageID   string
+	IntID       string
+	SupplierRef string
+	Status      string
+	Body        []byte
+	// Reply is the status code sent back, zero until the reply is ready
+	Reply int
+}
+
+// message is the part of the notifier payload the mock understands
+type message struct {
+	MessageID string `json:"messageid"`
+	IntID     string `json:"internal_identifier"`
+	Payload   struct {
+		SupplierRef string `json:"supplierRef"`
+		Status      string `json:"status"`
+		StartTime   string `json:"startTime"`
+		EndTime     string `json:"endTime"`
+	} `json:"payload"`
+}
+
+// Record is a change as the mock holds it, with SNOW's numeric state and
+// UTC dates
+type Record struct {
+	Number      string
+	SupplierRef string
+	State       string
+	StartDate   string
+	EndDate     string
+}
+
+// states maps JSD statuses to SNOW change states
+var states = map[string]string{
+	"Scheduled":   "-2",
+	"In Progress": "-1",
+	"Completed":   "3",
+	"Cancelled":   "4",
+}
+
+// Server emulates the SNOW import set endpoint
+type Server struct {
+	// Username and Password are required as basic auth when set
+	Username string
+	Password string
+	// Token is required as a bearer token when set, and is issued by the
+	// OAuth token endpoint
+	Token string
+
+	mu       sync.Mutex
+	script   []Step
+	changes  map[string]*Record
+	next     int
+	requests []Request
+}
+
+// New returns a mock with no changes and no script
+func New() *Server {
+	return &Server{changes: make(map[string]*Record), next: 1}
+}
+
+// Script queues replies for the next requests
+func (s *Server) Script(steps ...Step) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = append(s.script, steps...)
+}
+
+// Reset forgets changes, requests and any unused script
+func (s *Server) Reset() {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = nil
+	s.changes = make(map[string]*Record)
+	s.next = 1
+	s.requests = nil
+}
+
+// Requests returns every message received so far
+func (s *Server) Requests() []Request {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Change returns the change SNOW holds for a supplier ref
+func (s *Server) Change(ref string) (Record, bool) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.changes[ref]
+	if !ok {
+		return Record{}, false
+	}
+	return *c, true
+}
+
+// Put adds or replaces a change, e.g. to emulate an edit made in SNOW
+func (s *Server) Put(c Record) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changes[c.SupplierRef] = &c
+}
+
+// ServeHTTP handles import set posts, table API queries and OAuth token
+// requests
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/table/") {
+		s.table(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if strings.HasSuffix(r.URL.Path, "/oauth_token.do") {
+		s.token(w, r)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var m message
+	perr := json.Unmarshal(body, &m)
+
+	// record on arrival, so requests the client gave up on still count
+	s.mu.Lock()
+	i := len(s.requests)
+	s.requests = append(s.requests, Request{
+		MessageID:   m.MessageID,
+		IntID:       m.IntID,
+		SupplierRef: m.Payload.SupplierRef,
+		Status:      m.Payload.Status,
+		Body:        body,
+	})
+	s.mu.Unlock()
+
+	step := s.step()
+	time.Sleep(step.Delay)
+
+	code, reply := s.reply(r, step, &m, perr)
+
+	s.mu.Lock()
+	if i < len(s.requests) {
+		s.requests[i].Reply = code
+	}
+	s.mu.Unlock()
+
+	if step.Kind == KindThrottle {
+		w.Header().Set("Retry-After", "1")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(reply)
+}
+
+// step takes the next scripted reply
+func (s *Server) step() Step {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.script) == 0 {
+		return Step{Kind: KindAuto}
+	}
+	st := s.script[0]
+	s.script = s.script[1:]
+	return st
+}
+
+// reply works out the status code and body for a request
+func (s *Server) reply(r *http.Request, st Step, m *message, perr error) (int, []byte) {
+
+	if st.Kind == KindUnauthorized || !s.authorized(r) {
+		return status(st, http.StatusUnauthorized), platformError("User Not Authenticated", "Required to provide Auth information")
+	}
+
+	switch st.Kind {
+	case KindThrottle:
+		return status(st, http.StatusTooManyRequests), platformError("Rate limit exceeded", st.Message)
+	case KindServerError:
+		return status(st, http.StatusInternalServerError), platformError("Internal server error", st.Message)
+	case KindMalformed:
+		return status(st, http.StatusOK), []byte(`<html><body>Service Unavailable`)
+	}
+
+	if perr != nil {
+		return http.StatusBadRequest, platformError("Exception while reading request", perr.Error())
+	}
+
+	kind := st.Kind
+	if kind == "" || kind == KindAuto {
+		kind = s.auto(m)
+	}
+
+	row := map[string]string{
+		"transform_map": "SIAM Change Inbound",
+		"table":         "change_request",
+		"display_name":  "number",
+		"status":        kind,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ref := m.Payload.SupplierRef
+	switch kind {
+	case KindInsert:
+		num := fmt.Sprintf("CHG%07d", s.next)
+		s.next++
+		s.changes[ref] = &Record{Number: num, SupplierRef: ref}
+		s.changes[ref].apply(m)
+		row["status"] = "inserted"
+		row["display_value"] = num
+		row["sys_id"] = sysID(num)
+	case KindUpdate:
+		c, ok := s.changes[ref]
+		if !ok {
+			c = &Record{Number: m.IntID, SupplierRef: ref}
+			s.changes[ref] = c
+		}
+		if m.IntID != "" {
+			c.Number = m.IntID
+		}
+		c.apply(m)
+		row["status"] = "updated"
+		row["display_value"] = c.Number
+		row["sys_id"] = sysID(c.Number)
+	case KindIgnored, KindSkipped:
+		row["status_message"] = orDefault(st.Message, "No field values changed")
+	case KindError:
+		row["error_message"] = orDefault(st.Message, "Unable to resolve target record")
+	default:
+		return http.StatusBadRequest, platformError("Unknown scenario step", kind)
+	}
+
+	b, _ := json.Marshal(map[string]interface{}{
+		"import_set":    "ISET0010001",
+		"staging_table": "u_siam_change_inbound",
+		"result":        []map[string]string{row},
+	})
+	return status(st, http.StatusCreated), b
+}
+
+// auto decides what SNOW would do with a message
+func (s *Server) auto(m *message) string {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch m.MessageID {
+	case createMsgID:
+		if _, ok := s.changes[m.Payload.SupplierRef]; ok {
+			return KindUpdate
+		}
+		return KindInsert
+	case updateMsgID:
+		if _, ok := s.changes[m.Payload.SupplierRef]; !ok && m.IntID == "" {
+			return KindError
+		}
+		return KindUpdate
+	}
+	return KindError
+}
+
+// apply copies the state and window from a message
+func (c *Record) apply(m *message) {
+
+	if st, ok := states[m.Payload.Status]; ok {
+		c.State = st
+	}
+	if m.Payload.StartTime != "" {
+		c.StartDate = snowtime.UTC(m.Payload.StartTime)
+	}
+	if m.Payload.EndTime != "" {
+		c.EndDate = snowtime.UTC(m.Payload.EndTime)
+	}
+}
+
+// table answers table API queries of the form field=value. The number field
+// matches change numbers, any other field the supplier ref. Like SNOW,
+// matching ignores case.
+func (s *Server) table(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if !s.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(platformError("User Not Authenticated", "Required to provide Auth information"))
+		return
+	}
+
+	q := strings.SplitN(r.URL.Query().Get("sysparm_query"), "=", 2)
+	if len(q) != 2 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(platformError("Invalid query", r.URL.Query().Get("sysparm_query")))
+		return
+	}
+
+	// the ref field is whichever requested field isn't a standard one
+	ref := "correlation_id"
+	for _, f := range strings.Split(r.URL.Query().Get("sysparm_fields"), ",") {
+		switch f {
+		case "", "number", "sys_id", "state", "start_date", "end_date":
+		default:
+			ref = f
+		}
+	}
+
+	s.mu.Lock()
+	rows := []map[string]string{}
+	for _, c := range s.changes {
+		v := c.SupplierRef
+		if q[0] == "number" {
+			v = c.Number
+		}
+		if strings.EqualFold(v, q[1]) {
+			rows = append(rows, map[string]string{
+				"number":     c.Number,
+				"sys_id":     sysID(c.Number),
+				"state":      c.State,
+				"start_date": c.StartDate,
+				"end_date":   c.EndDate,
+				ref:          c.SupplierRef,
+			})
+		}
+	}
+	s.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"result": rows})
+}
+
+// authorized checks the configured credentials, if any
+func (s *Server) authorized(r *http.Request) bool {
+
+	if s.Token != "" {
+		return r.Header.Get("Authorization") == "Bearer "+s.Token
+	}
+	if s.Username != "" {
+		u, p, ok := r.BasicAuth()
+		return ok && u == s.Username && p == s.Password
+	}
+	return true
+}
+
+// token issues the configured OAuth token for the configured client
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+
+	if s.Token == "" {
+		http.Error(w, "OAuth is not enabled", http.StatusNotFound)
+		return
+	}
+
+	id, secret := r.FormValue("client_id"), r.FormValue("client_secret")
+	if u, p, ok := r.BasicAuth(); ok {
+		id, secret = u, p
+	}
+	if s.Username != "" && (id != s.Username || secret != s.Password) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"access_denied","error_description":"access_denied"}`))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": s.Token,
+		"token_type":   "Bearer",
+		"expires_in":   1799,
+	})
+}
+
+// LoadScript reads a JSON array of steps
+func LoadScript(path string) ([]Step, error) {
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []Step
+	err = json.Unmarshal(b, &steps)
+	if err != nil {
+		return nil, errors.New("could not parse scenario: " + err.Error())
+	}
+	return steps, nil
+}
+
+// FromEnv builds a mock from SNOWMOCK_USERNAME, SNOWMOCK_PASSWORD,
+// SNOWMOCK_TOKEN and a SNOWMOCK_SCENARIO file
+func FromEnv() (*Server, error) {
+
+	s := New()
+	s.Username = os.Getenv("SNOWMOCK_USERNAME")
+	s.Password = os.Getenv("SNOWMOCK_PASSWORD")
+	s.Token = os.Getenv("SNOWMOCK_TOKEN")
+
+	if path, ok := os.LookupEnv("SNOWMOCK_SCENARIO"); ok && path != "" {
+		steps, err := LoadScript(path)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("loaded %v scenario steps from %v", len(steps), path)
+		s.Script(steps...)
+	}
+	return s, nil
+}
+
+// status returns the step's code, or def
+func status(st Step, def int) int {
+
+	if st.Code != 0 {
+		return st.Code
+	}
+	return def
+}
+
+// platformError is the body SNOW sends when it rejects a request outright
+func platformError(msg, detail string) []byte {
+
+	b, _ := json.Marshal(map[string]interface{}{
+		"error":  map[string]string{"message": msg, "detail": detail},
+		"status": "failure",
+	})
+	return b
+}
+
+// sysID makes a stable fake sys_id for a change number
+func sysID(num string) string {
+
+	if num == "" {
+		return ""
+	}
+	return fmt.Sprintf("%032x", []byte(num))[:32]
+}
+
+// orDefault returns v, or def when v is empty
+func orDefault(v, def string) string {
+
+	if v == "" {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,169 @@
+package snowmock
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+
+	create := `{"messageid":"HO_SIAM_IN_REST_CHG_POST_JSON","payload":{"supplierRef":"ACP-1","status":"Scheduled"}}`
+	update := `{"messageid":"HO_SIAM_IN_REST_CHG_UPDATE_JSON","payload":{"supplierRef":"ACP-1","status":"Completed"}}`
+
+	tt := []struct {
+		name   string
+		steps  []Step
+		bodies []string
+		auth   bool
+		code   int
+		status string
+		number string
+	}{
+		{name: "insert", bodies: []string{create}, auth: true, code: 201, status: "inserted", number: "CHG0000001"},
+		{name: "update", bodies: []string{create, update}, auth: true, code: 201, status: "updated", number: "CHG0000001"},
+		{name: "create twice", bodies: []string{create, create}, auth: true, code: 201, status: "updated", number: "CHG0000001"},
+		{name: "update unknown", bodies: []string{update}, auth: true, code: 201, status: "error"},
+		{name: "no auth", bodies: []string{create}, code: 401},
+		{name: "scripted", steps: []Step{{Kind: KindThrottle}, {Kind: KindSkipped}}, bodies: []string{create, create},
+			auth: true, code: 201, status: "skipped"},
+		{name: "code override", steps: []Step{{Kind: KindError, Code: 200}}, bodies: []string{create}, auth: true,
+			code: 200, status: "error"},
+		{name: "not json", bodies: []string{"{"}, auth: true, code: 400},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			s := New()
+			s.Username, s.Password = "user", "pass"
+			s.Script(tc.steps...)
+			srv := httptest.NewServer(s)
+			defer srv.Close()
+
+			var res *http.Response
+			for _, b := range tc.bodies {
+				req, _ := http.NewRequest("POST", srv.URL+"/api/now/import/u_siam_change_inbound", strings.NewReader(b))
+				if tc.auth {
+					req.SetBasicAuth("user", "pass")
+				}
+				var err error
+				res, err = http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				defer res.Body.Close()
+			}
+
+			if res.StatusCode != tc.code {
+				t.Fatalf("expected HTTP %v, got %v", tc.code, res.StatusCode)
+			}
+			if tc.status == "" {
+				return
+			}
+
+			var rep struct {
+				Result []map[string]string `json:"result"`
+			}
+			json.NewDecoder(res.Body).Decode(&rep)
+			if len(rep.Result) != 1 {
+				t.Fatalf("expected one result row, got %v", rep.Result)
+			}
+			if row := rep.Result[0]; row["status"] != tc.status || row["display_value"] != tc.number {
+				t.Errorf("expected %v %q, got %v", tc.status, tc.number, row)
+			}
+
+			if got := len(s.Requests()); got != len(tc.bodies) {
+				t.Errorf("expected %v requests, got %v", len(tc.bodies), got)
+			}
+		})
+	}
+}
+
+func TestToken(t *testing.T) {
+
+	s := New()
+	s.Username, s.Password, s.Token = "id", "secret", "tok"
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	res, err := http.PostForm(srv.URL+"/oauth_token.do", map[string][]string{
+		"grant_type": {"client_credentials"}, "client_id": {"id"}, "client_secret": {"secret"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer res.Body.Close()
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+	}
+	json.NewDecoder(res.Body).Decode(&tok)
+	if tok.AccessToken != "tok" {
+		t.Fatalf("expected token, got %q", tok.AccessToken)
+	}
+
+	req, _ := http.NewRequest("POST", srv.URL, strings.NewReader(`{"messageid":"HO_SIAM_IN_REST_CHG_POST_JSON"}`))
+	req.Header.Set("Authorization", "Bearer tok")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		t.Errorf("expected bearer token to be accepted, got HTTP %v", res.StatusCode)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "snowmock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tt := []struct {
+		name     string
+		scenario string
+		steps    int
+		err      string
+	}{
+		{name: "scenario", scenario: `[{"kind":"unauthorized"},{"kind":"auto","delay":"2s"}]`, steps: 2},
+		{name: "bad json", scenario: `{"kind":"auto"}`, err: "could not parse scenario"},
+		{name: "bad delay", scenario: `[{"kind":"auto","delay":"soon"}]`, err: "invalid delay"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			path := filepath.Join(dir, tc.name+".json")
+			ioutil.WriteFile(path, []byte(tc.scenario), 0600)
+			os.Setenv("SNOWMOCK_SCENARIO", path)
+			defer os.Unsetenv("SNOWMOCK_SCENARIO")
+
+			s, err := FromEnv()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(s.script) != tc.steps {
+				t.Errorf("expected %v steps, got %v", tc.steps, len(s.script))
+			}
+			if s.script[1].Delay != 2*time.Second {
+				t.Errorf("expected 2s delay, got %v", s.script[1].Delay)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package snowtime
+
+import "time"
+
+// Layout is how the listener stores start and end times, in London time,
+// and how SNOW sends and returns dates, in UTC
+const Layout = "2006-01-02 15:04:05"
+
+// Parse reads a stored London time
+func Parse(v string) (time.Time, error) {
+
+	loc, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.ParseInLocation(Layout, v, loc)
+}
+
+// UTC converts a stored London time to the UTC SNOW stores, leaving
+// anything it can't parse as it is
+func UTC(v string) string {
+
+	t, err := Parse(v)
+	if err != nil {
+		return v
+	}
+	return t.UTC().Format(Layout)
+}
@@ -0,0 +1,42 @@
+package snowtime
+
+import (
+	"testing"
+)
+
+func TestUTC(t *testing.T) {
+
+	tt := []struct {
+		name   string
+		value  string
+		expect string
+	}{
+		{name: "summer", value: "2020-07-01 10:00:00", expect: "2020-07-01 09:00:00"},
+		{name: "winter", value: "2020-12-01 10:00:00", expect: "2020-12-01 10:00:00"},
+		{name: "not a time", value: "soon", expect: "soon"},
+		{name: "empty"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := UTC(tc.value); got != tc.expect {
+				t.Errorf("expected %q, got %q", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+
+	got, err := Parse("2020-07-01 10:00:00")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Location().String() != "Europe/London" || got.UTC().Hour() != 9 {
+		t.Errorf("expected 10:00 London time, got %v", got)
+	}
+
+	if _, err := Parse("2020-07-01T10:00:00Z"); err == nil {
+		t.Errorf("expected an error for another layout")
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"log"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/redact"
+	"github.com/UKHomeOffice/snow-forwarder/internal/sweeper"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+func main() {
+
+	if err := redact.Setup(); err != nil {
+		log.Fatal(err)
+	}
+
+	lambda.Start(sweeper.Handler)
+}
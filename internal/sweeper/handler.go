@@ -0,0 +1,73 @@
+package sweeper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Report is the outcome of each sweep that ran
+type Report struct {
+	Overdue *OverdueReport `json:"overdue,omitempty"`
+	Stuck   *StuckReport   `json:"stuck,omitempty"`
+	Redrive *RedriveReport `json:"redrive,omitempty"`
+}
+
+// Handler runs the sweeps listed in SWEEPS on a schedule
+func Handler(ctx context.Context, e events.CloudWatchEvent) (*Report, error) {
+
+	sweeps := "overdue"
+	if v, ok := os.LookupEnv("SWEEPS"); ok {
+		sweeps = v
+	}
+
+	db, err := newDB()
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := db.Items(ctx)
+	if err != nil {
+		log.Printf("could not read changes: %v", err)
+		return nil, err
+	}
+
+	var rep Report
+	for _, name := range strings.Split(sweeps, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "overdue":
+			s, err := newOverdueSweep(db)
+			if err != nil {
+				return nil, err
+			}
+			rep.Overdue = s.run(ctx, items)
+			log.Printf("overdue sweep: %v of %v changes overdue, %v owners notified, %v closed, %v errors",
+				len(rep.Overdue.Overdue), rep.Overdue.Checked, rep.Overdue.Notified, rep.Overdue.Closed, len(rep.Overdue.Errors))
+		case "stuck":
+			s, err := newStuckSweep(db)
+			if err != nil {
+				return nil, err
+			}
+			rep.Stuck = s.run(ctx, items)
+			log.Printf("stuck sweep: %v of %v changes without a SNOW ID, %v recovered, %v raised again, %v ambiguous, %v errors",
+				len(rep.Stuck.Stuck), rep.Stuck.Checked, len(rep.Stuck.Recovered), len(rep.Stuck.Recreated),
+				len(rep.Stuck.Ambiguous), len(rep.Stuck.Errors))
+		case "redrive":
+			s, err := newRedriveSweep()
+			if err != nil {
+				return nil, err
+			}
+			rep.Redrive = s.run(ctx)
+			log.Printf("redrive sweep: %v of %v parked messages sent to SNOW, %v errors",
+				len(rep.Redrive.Redriven), rep.Redrive.Received, len(rep.Redrive.Errors))
+		default:
+			return nil, fmt.Errorf("unknown sweep %q", name)
+		}
+	}
+	return &rep, nil
+}
@@ -0,0 +1,263 @@
+package sweeper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/jira"
+	"github.com/UKHomeOffice/snow-forwarder/internal/notifier"
+	"github.com/UKHomeOffice/snow-forwarder/internal/snowtime"
+)
+
+// defaultGrace is how long an overdue change stays open before it's closed
+const defaultGrace = 24 * time.Hour
+
+// commenter posts a comment on a JSD issue
+type commenter interface {
+	Comment(ctx context.Context, key, body string) error
+}
+
+// OverdueReport is the outcome of an overdue sweep
+type OverdueReport struct {
+	Checked  int      `json:"checked"`
+	Overdue  []string `json:"overdue"`
+	Notified int      `json:"notified"`
+	Closed   int      `json:"closed"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// overdueSweep finds changes still open after their window ended
+type overdueSweep struct {
+	db      *DB
+	jira    commenter
+	chat    notifier.Sink
+	snow    notifier.Sink
+	grace   time.Duration
+	close   bool
+	success bool
+	now     func() time.Time
+}
+
+// newOverdueSweep reads OVERDUE_NOTIFY, a comma separated list of jsd and
+// chat, OVERDUE_GRACE, OVERDUE_AUTO_CLOSE and OVERDUE_CLOSE_OUTCOME
+func newOverdueSweep(db *DB) (*overdueSweep, error) {
+
+	s := &overdueSweep{db: db, grace: defaultGrace, now: time.Now}
+
+	notify := "jsd"
+	if v, ok := os.LookupEnv("OVERDUE_NOTIFY"); ok {
+		notify = v
+	}
+
+	for _, n := range strings.Split(notify, ",") {
+		var err error
+		switch strings.TrimSpace(n) {
+		case "":
+		case "jsd":
+			s.jira, err = jira.New()
+		case "chat":
+			s.chat, err = notifier.NewChatSink()
+		default:
+			err = fmt.Errorf("unknown OVERDUE_NOTIFY channel %q", n)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if v, ok := os.LookupEnv("OVERDUE_GRACE"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid OVERDUE_GRACE %q", v)
+		}
+		s.grace = d
+	}
+
+	s.close = os.Getenv("OVERDUE_AUTO_CLOSE") == "true"
+	if s.close {
+		var err error
+		s.snow, err = notifier.NewSnowSink()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch v := os.Getenv("OVERDUE_CLOSE_OUTCOME"); v {
+	case "", "unsuccessful":
+	case "successful":
+		s.success = true
+	default:
+		return nil, fmt.Errorf("invalid OVERDUE_CLOSE_OUTCOME %q", v)
+	}
+	return s, nil
+}
+
+// run notifies owners of overdue changes once, and closes them in SNOW once
+// the grace period has passed if auto close is on
+func (s *overdueSweep) run(ctx context.Context, items []Item) *OverdueReport {
+
+	rep := &OverdueReport{Overdue: []string{}}
+	now := s.now()
+
+	for i, it := range items {
+		if ctx.Err() != nil {
+			log.Printf("stopping with %v changes left to check: %v", len(items)-i, ctx.Err())
+			rep.Errors = append(rep.Errors, fmt.Sprintf("%v changes not checked: %v", len(items)-i, ctx.Err()))
+			break
+		}
+		rep.Checked++
+
+		// a rescheduled change may fall overdue again, and its owner is told
+		// again when it does
+		if rescheduled(it) {
+			err := s.db.ClearNotified(ctx, it.SupplierRef)
+			if err != nil {
+				log.Printf("could not clear overdue notification for %v: %v", it.SupplierRef, err)
+				rep.Errors = append(rep.Errors, fmt.Sprintf("%v: %v", it.SupplierRef, err))
+				continue
+			}
+			log.Printf("%v was rescheduled to end at %v since its owner was told it was overdue", it.SupplierRef, it.EndTime)
+			it.OverdueNotifiedAt = ""
+		}
+
+		end, ok := overdue(it, now)
+		if !ok {
+			continue
+		}
+		rep.Overdue = append(rep.Overdue, it.SupplierRef)
+		log.Printf("%v is overdue, due to finish at %v and still %v", it.SupplierRef, it.EndTime, it.Status)
+
+		if it.OverdueNotifiedAt == "" {
+			err := s.notify(ctx, it)
+			if err == nil {
+				err = s.db.MarkNotified(ctx, it.SupplierRef, it.EndTime, now)
+			}
+			if err != nil {
+				log.Printf("could not notify owner of %v: %v", it.SupplierRef, err)
+				rep.Errors = append(rep.Errors, fmt.Sprintf("%v: %v", it.SupplierRef, err))
+			} else {
+				rep.Notified++
+			}
+		}
+
+		if !s.close || it.OverdueClosedAt != "" || now.Before(end.Add(s.grace)) {
+			continue
+		}
+
+		// a change SNOW never heard of can't be closed there
+		if it.IntIdent == "" {
+			continue
+		}
+
+		err := s.closeChange(ctx, it)
+		if err == nil {
+			err = s.db.Mark(ctx, it.SupplierRef, "overdueClosedAt", now)
+		}
+		if err != nil {
+			log.Printf("could not close %v in SNOW: %v", it.SupplierRef, err)
+			rep.Errors = append(rep.Errors, fmt.Sprintf("%v: %v", it.SupplierRef, err))
+			continue
+		}
+		rep.Closed++
+	}
+	return rep
+}
+
+// rescheduled reports whether a change's window has moved since its owner
+// was told it was overdue. Notifications from before the endTime was kept
+// are left alone.
+func rescheduled(it Item) bool {
+	return it.OverdueNotifiedAt != "" && it.OverdueNotifiedEnd != "" && it.OverdueNotifiedEnd != it.EndTime
+}
+
+// overdue reports whether a change is still open after its window, and when
+// the window ended. Changes the sweep has closed in SNOW are done with.
+func overdue(it Item, now time.Time) (time.Time, bool) {
+
+	if it.OverdueClosedAt != "" || (it.Status != "Scheduled" && it.Status != "In Progress") {
+		return time.Time{}, false
+	}
+
+	end, err := snowtime.Parse(it.EndTime)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return end, now.After(end)
+}
+
+// notify tells the change owner on each configured channel
+func (s *overdueSweep) notify(ctx context.Context, it Item) error {
+
+	if s.jira != nil {
+		err := s.jira.Comment(ctx, it.SupplierRef, s.comment(it))
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.chat != nil {
+		m := &notifier.Message{
+			IntID: it.IntIdent,
+			Event: notifier.EventOverdue,
+			Payload: notifier.Payload{
+				SupplierRef: it.SupplierRef,
+				Status:      it.Status,
+				Title:       it.Title,
+				StartTime:   it.StartTime,
+				EndTime:     it.EndTime,
+			},
+		}
+		_, err := s.chat.Deliver(ctx, m)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// comment asks the owner to finish off the change
+func (s *overdueSweep) comment(it Item) string {
+
+	c := fmt.Sprintf("This change was due to finish at %v and is still %v. Please complete or cancel it.", it.EndTime, it.Status)
+	if s.close {
+		outcome := "unsuccessful"
+		if s.success {
+			outcome = "successful"
+		}
+		c += fmt.Sprintf(" It will be closed in SNOW as %v %v after it was due to finish.", outcome, s.grace)
+	}
+	return c
+}
+
+// closeChange completes the change in SNOW with the configured outcome,
+// through the SNOW sink so it carries the change's CIs and model and the
+// delivery is recorded
+func (s *overdueSweep) closeChange(ctx context.Context, it Item) error {
+
+	m := notifier.NewClose(it.IntIdent, notifier.Payload{
+		SupplierRef: it.SupplierRef,
+		Title:       it.Title,
+		Description: it.Description,
+		StartTime:   it.StartTime,
+		EndTime:     it.EndTime,
+	}, s.success)
+
+	err := describe(m, it)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.snow.Deliver(ctx, m)
+	if err != nil {
+		return err
+	}
+	if res.Outcome != notifier.OutcomeUpdated {
+		return fmt.Errorf("SNOW %v the close: %v", res.Outcome, res.Message)
+	}
+	log.Printf("closed overdue change %v in SNOW", it.SupplierRef)
+	return nil
+}
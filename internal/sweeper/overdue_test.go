@@ -0,0 +1,237 @@
+package sweeper
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/notifier"
+	"github.com/UKHomeOffice/snow-forwarder/internal/snowmock"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// mockDynamoDB records which attributes were set on which change
+type mockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	err   error
+	marks []string
+}
+
+func (md *mockDynamoDB) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+
+	if md.err != nil {
+		return nil, md.err
+	}
+
+	attr := aws.StringValue(input.ExpressionAttributeNames["#A"])
+	if attr == "" {
+		attr = "internal_identifier=" + aws.StringValue(input.ExpressionAttributeValues[":cid"].S)
+	}
+	if strings.HasPrefix(aws.StringValue(input.UpdateExpression), "REMOVE") {
+		attr = "-" + attr
+	}
+	md.marks = append(md.marks, *input.Key["supplierRef"].S+":"+attr)
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+type fakeJira struct {
+	err      error
+	comments []string
+}
+
+func (fj *fakeJira) Comment(ctx context.Context, key, body string) error {
+	fj.comments = append(fj.comments, key+": "+body)
+	return fj.err
+}
+
+type fakeChat struct {
+	events []string
+}
+
+func (fc *fakeChat) Name() string {
+	return "chat"
+}
+
+func (fc *fakeChat) Deliver(ctx context.Context, m *notifier.Message) (*notifier.Result, error) {
+	fc.events = append(fc.events, m.SupplierRef+":"+m.Event)
+	return &notifier.Result{Outcome: notifier.OutcomeInserted}, nil
+}
+
+// snowSink stands in for the SNOW sink, sending straight to SNOW
+type snowSink struct {
+	sent []*notifier.Message
+}
+
+func (ss *snowSink) Name() string {
+	return "snow"
+}
+
+func (ss *snowSink) Deliver(ctx context.Context, m *notifier.Message) (*notifier.Result, error) {
+	ss.sent = append(ss.sent, m)
+	return m.Notify(ctx)
+}
+
+func TestOverdue(t *testing.T) {
+
+	mock := snowmock.New()
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+
+	env := map[string]string{
+		"TABLE_NAME":              "changes",
+		"SNOW_URL":                srv.URL,
+		"SNOW_CREDENTIALS_SOURCE": "env",
+		"SNOW_USERNAME":           "forwarder",
+		"SNOW_PASSWORD":           "s3cr3t",
+		"CHANGE_MODELS":           `[{"issueType":"Emergency Change","type":"emergency"}]`,
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	// noon in London in July
+	now := time.Date(2020, 7, 1, 11, 0, 0, 0, time.UTC)
+
+	tt := []struct {
+		name     string
+		item     Item
+		close    bool
+		jiraErr  error
+		overdue  int
+		comments int
+		chats    int
+		marks    []string
+		closed   int
+		err      string
+	}{
+		{name: "not due", item: Item{SupplierRef: "ACP-1", Status: "In Progress", EndTime: "2020-07-01 13:00:00"}},
+		{name: "completed", item: Item{SupplierRef: "ACP-1", Status: "Completed", EndTime: "2020-07-01 10:00:00"}},
+		{name: "overdue", item: Item{SupplierRef: "ACP-1", Status: "In Progress", EndTime: "2020-07-01 10:00:00"},
+			overdue: 1, comments: 1, chats: 1, marks: []string{"ACP-1:overdueNotifiedAt"}},
+		{name: "already notified", item: Item{SupplierRef: "ACP-1", Status: "Scheduled", EndTime: "2020-07-01 10:00:00",
+			OverdueNotifiedAt: "2020-07-01T10:00:00Z"}, overdue: 1},
+		{name: "in grace", close: true, item: Item{SupplierRef: "ACP-1", Status: "In Progress", EndTime: "2020-07-01 10:00:00",
+			IntIdent: "CHG0000001", OverdueNotifiedAt: "2020-07-01T10:00:00Z"}, overdue: 1},
+		{name: "closed", close: true, item: Item{SupplierRef: "ACP-1", Status: "In Progress", EndTime: "2020-06-30 10:00:00",
+			IntIdent: "CHG0000001", OverdueNotifiedAt: "2020-06-30T10:00:00Z", IssueType: "Emergency Change", Components: []string{"Billing"}},
+			overdue: 1, closed: 1, marks: []string{"ACP-1:overdueClosedAt"}},
+		{name: "rescheduled and overdue again", item: Item{SupplierRef: "ACP-1", Status: "In Progress", EndTime: "2020-07-01 10:00:00",
+			OverdueNotifiedAt: "2020-06-30T10:00:00Z", OverdueNotifiedEnd: "2020-06-30 09:00:00"}, overdue: 1, comments: 1, chats: 1,
+			marks: []string{"ACP-1:-overdueNotifiedAt", "ACP-1:overdueNotifiedAt"}},
+		{name: "rescheduled", item: Item{SupplierRef: "ACP-1", Status: "In Progress", EndTime: "2020-07-01 13:00:00",
+			OverdueNotifiedAt: "2020-06-30T10:00:00Z", OverdueNotifiedEnd: "2020-06-30 09:00:00"}, marks: []string{"ACP-1:-overdueNotifiedAt"}},
+		{name: "notified for this window", item: Item{SupplierRef: "ACP-1", Status: "In Progress", EndTime: "2020-07-01 10:00:00",
+			OverdueNotifiedAt: "2020-07-01T10:00:00Z", OverdueNotifiedEnd: "2020-07-01 10:00:00"}, overdue: 1},
+		{name: "already closed", close: true, item: Item{SupplierRef: "ACP-1", Status: "In Progress", EndTime: "2020-06-30 10:00:00",
+			IntIdent: "CHG0000001", OverdueNotifiedAt: "2020-06-30T10:00:00Z", OverdueClosedAt: "2020-07-01T10:00:00Z"}},
+		{name: "closed before notified", item: Item{SupplierRef: "ACP-1", Status: "In Progress", EndTime: "2020-06-30 10:00:00",
+			IntIdent: "CHG0000001", OverdueClosedAt: "2020-07-01T10:00:00Z"}},
+		{name: "no SNOW ID", close: true, item: Item{SupplierRef: "ACP-1", Status: "Scheduled", EndTime: "2020-06-30 10:00:00",
+			OverdueNotifiedAt: "2020-06-30T10:00:00Z"}, overdue: 1},
+		{name: "jira down", jiraErr: errors.New("Jira returned 503"), item: Item{SupplierRef: "ACP-1", Status: "In Progress",
+			EndTime: "2020-07-01 10:00:00"}, overdue: 1, comments: 1, err: "Jira returned 503"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			mock.Reset()
+			md := &mockDynamoDB{}
+			jira := &fakeJira{err: tc.jiraErr}
+			chat := &fakeChat{}
+			snow := &snowSink{}
+
+			s := &overdueSweep{db: &DB{DynamoDB: md}, jira: jira, chat: chat, snow: snow, grace: 12 * time.Hour,
+				close: tc.close, now: func() time.Time { return now }}
+
+			rep := s.run(context.Background(), []Item{tc.item})
+
+			if tc.err != "" {
+				if len(rep.Errors) != 1 || !strings.Contains(rep.Errors[0], tc.err) {
+					t.Errorf("expected error %q, got %v", tc.err, rep.Errors)
+				}
+			} else if len(rep.Errors) != 0 {
+				t.Fatalf("unexpected errors: %v", rep.Errors)
+			}
+
+			if len(rep.Overdue) != tc.overdue || rep.Closed != tc.closed {
+				t.Errorf("expected %v overdue and %v closed, got %+v", tc.overdue, tc.closed, rep)
+			}
+
+			if len(jira.comments) != tc.comments || len(chat.events) != tc.chats {
+				t.Errorf("expected %v notifications, got %v and %v", tc.comments, jira.comments, chat.events)
+			}
+
+			if strings.Join(md.marks, ",") != strings.Join(tc.marks, ",") {
+				t.Errorf("expected marks %v, got %v", tc.marks, md.marks)
+			}
+
+			reqs := mock.Requests()
+			if len(reqs) != tc.closed {
+				t.Fatalf("expected %v closes sent to SNOW, got %v", tc.closed, len(reqs))
+			}
+			if tc.closed > 0 && (reqs[0].IntID != "CHG0000001" || reqs[0].Status != "Completed" ||
+				!strings.Contains(string(reqs[0].Body), `"success":"false"`)) {
+				t.Errorf("unexpected close: %s", reqs[0].Body)
+			}
+
+			// closes go through the SNOW sink with the change's CIs and model
+			if tc.closed > 0 && (len(snow.sent) != 1 || snow.sent[0].ChangeType != "emergency" || len(snow.sent[0].Components) != 1) {
+				t.Errorf("expected the close with its CIs and model, got %+v", snow.sent)
+			}
+		})
+	}
+}
+
+func TestNewOverdueSweep(t *testing.T) {
+
+	tt := []struct {
+		name    string
+		env     map[string]string
+		grace   time.Duration
+		success bool
+		err     string
+	}{
+		{name: "defaults", env: map[string]string{"OVERDUE_NOTIFY": ""}, grace: defaultGrace},
+		{name: "configured", env: map[string]string{"OVERDUE_NOTIFY": "", "OVERDUE_GRACE": "2h",
+			"OVERDUE_CLOSE_OUTCOME": "successful"}, grace: 2 * time.Hour, success: true},
+		{name: "jsd needs jira", err: "missing environment variable JIRA_API_URL"},
+		{name: "unknown channel", env: map[string]string{"OVERDUE_NOTIFY": "email"}, err: "unknown OVERDUE_NOTIFY channel"},
+		{name: "bad grace", env: map[string]string{"OVERDUE_NOTIFY": "", "OVERDUE_GRACE": "-1h"}, err: "invalid OVERDUE_GRACE"},
+		{name: "bad outcome", env: map[string]string{"OVERDUE_NOTIFY": "", "OVERDUE_CLOSE_OUTCOME": "fine"},
+			err: "invalid OVERDUE_CLOSE_OUTCOME"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			for k, v := range tc.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			s, err := newOverdueSweep(&DB{})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if s.grace != tc.grace || s.success != tc.success {
+				t.Errorf("expected grace %v success %v, got %v %v", tc.grace, tc.success, s.grace, s.success)
+			}
+		})
+	}
+}
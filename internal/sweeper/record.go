@@ -0,0 +1,202 @@
+package sweeper
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// DB wraps DynamodDB with iface pkg for easier testing
+type DB struct {
+	DynamoDB dynamodbiface.DynamoDBAPI
+}
+
+func newDB() (*DB, error) {
+
+	var db = new(DB)
+	reg, ok := os.LookupEnv("REGION")
+	if !ok {
+		return nil, errors.New("missing AWS region")
+	}
+
+	awsConfig := aws.Config{
+		Region: aws.String(reg),
+	}
+
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+		Config:            awsConfig,
+	}))
+
+	svc := dynamodb.New(sess, aws.NewConfig())
+	db.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
+	return db, nil
+}
+
+// Item is a change as the listener recorded it, with the sweeper's marks
+type Item struct {
+	SupplierRef        string   `dynamodbav:"supplierRef"`
+	Status             string   `dynamodbav:"status"`
+	Title              string   `dynamodbav:"title"`
+	Description        string   `dynamodbav:"description"`
+	StartTime          string   `dynamodbav:"startTime"`
+	EndTime            string   `dynamodbav:"endTime"`
+	IntIdent           string   `dynamodbav:"internal_identifier"`
+	IssueType          string   `dynamodbav:"issueType"`
+	RequestType        string   `dynamodbav:"requestType"`
+	Labels             []string `dynamodbav:"labels"`
+	Components         []string `dynamodbav:"components"`
+	Assets             []string `dynamodbav:"assets"`
+	CreatedAt          string   `dynamodbav:"createdAt"`
+	LastAttemptAt      string   `dynamodbav:"lastAttemptAt"`
+	OverdueNotifiedAt  string   `dynamodbav:"overdueNotifiedAt"`
+	OverdueNotifiedEnd string   `dynamodbav:"overdueNotifiedEnd"`
+	OverdueClosedAt    string   `dynamodbav:"overdueClosedAt"`
+}
+
+// Items reads every change in the table
+func (d *DB) Items(ctx context.Context) ([]Item, error) {
+
+	tab, ok := os.LookupEnv("TABLE_NAME")
+	if !ok {
+		return nil, errors.New("missing table name")
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(tab),
+	}
+
+	var items []Item
+	var uerr error
+	err := d.DynamoDB.ScanPagesWithContext(ctx, input, func(page *dynamodb.ScanOutput, last bool) bool {
+		var batch []Item
+		uerr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &batch)
+		if uerr != nil {
+			return false
+		}
+		items = append(items, batch...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if uerr != nil {
+		return nil, errors.New("could not read changes: " + uerr.Error())
+	}
+	return items, nil
+}
+
+// Mark stamps an attribute on a change with the current time, so it isn't
+// acted on again. The stream ignores it as it isn't a tracked field.
+func (d *DB) Mark(ctx context.Context, ref, attribute string, at time.Time) error {
+
+	tab, ok := os.LookupEnv("TABLE_NAME")
+	if !ok {
+		return errors.New("missing table name")
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName:           aws.String(tab),
+		UpdateExpression:    aws.String("SET #A = :at"),
+		ConditionExpression: aws.String("attribute_exists(supplierRef)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#A": aws.String(attribute),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":at": {S: aws.String(at.UTC().Format(time.RFC3339))},
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"supplierRef": {S: aws.String(ref)},
+		},
+	}
+
+	_, err := d.DynamoDB.UpdateItemWithContext(ctx, input)
+	return err
+}
+
+// MarkNotified stores when the owner was told the change was overdue, and
+// the endTime it was overdue against
+func (d *DB) MarkNotified(ctx context.Context, ref, end string, at time.Time) error {
+
+	tab, ok := os.LookupEnv("TABLE_NAME")
+	if !ok {
+		return errors.New("missing table name")
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName:           aws.String(tab),
+		UpdateExpression:    aws.String("SET #A = :at, #E = :end"),
+		ConditionExpression: aws.String("attribute_exists(supplierRef)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#A": aws.String("overdueNotifiedAt"),
+			"#E": aws.String("overdueNotifiedEnd"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":at":  {S: aws.String(at.UTC().Format(time.RFC3339))},
+			":end": {S: aws.String(end)},
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"supplierRef": {S: aws.String(ref)},
+		},
+	}
+
+	_, err := d.DynamoDB.UpdateItemWithContext(ctx, input)
+	return err
+}
+
+// ClearNotified forgets the owner was told, once the change is rescheduled
+func (d *DB) ClearNotified(ctx context.Context, ref string) error {
+
+	tab, ok := os.LookupEnv("TABLE_NAME")
+	if !ok {
+		return errors.New("missing table name")
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName:           aws.String(tab),
+		UpdateExpression:    aws.String("REMOVE #A, #E"),
+		ConditionExpression: aws.String("attribute_exists(supplierRef)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#A": aws.String("overdueNotifiedAt"),
+			"#E": aws.String("overdueNotifiedEnd"),
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"supplierRef": {S: aws.String(ref)},
+		},
+	}
+
+	_, err := d.DynamoDB.UpdateItemWithContext(ctx, input)
+	return err
+}
+
+// SetID stores an internal_identifier recovered from SNOW, unless the
+// notifier has stored one in the meantime
+func (d *DB) SetID(ctx context.Context, ref, id string) error {
+
+	tab, ok := os.LookupEnv("TABLE_NAME")
+	if !ok {
+		return errors.New("missing table name")
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName:           aws.String(tab),
+		UpdateExpression:    aws.String("SET internal_identifier = :cid"),
+		ConditionExpression: aws.String("attribute_exists(supplierRef) AND attribute_not_exists(internal_identifier)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":cid": {S: aws.String(id)},
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"supplierRef": {S: aws.String(ref)},
+		},
+	}
+
+	_, err := d.DynamoDB.UpdateItemWithContext(ctx, input)
+	return err
+}
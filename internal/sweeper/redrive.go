@@ -0,0 +1,129 @@
+package sweeper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/notifier"
+)
+
+// defaultRedriveMax is how many parked messages one run reads
+const defaultRedriveMax = 100
+
+// RedriveReport is the outcome of a dead-letter redrive
+type RedriveReport struct {
+	Received int      `json:"received"`
+	Redriven []string `json:"redriven"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// deadLetters reads and removes parked messages
+type deadLetters interface {
+	Receive(ctx context.Context, max int) ([]*notifier.DeadLetter, error)
+	Delete(ctx context.Context, dl *notifier.DeadLetter) error
+}
+
+// redriveSweep replays messages the notifier parked on the dead-letter
+// queue
+type redriveSweep struct {
+	queue deadLetters
+	snow  notifier.Sink
+	max   int
+}
+
+// newRedriveSweep reads DEAD_LETTER_QUEUE_URL and REDRIVE_MAX
+func newRedriveSweep() (*redriveSweep, error) {
+
+	s := &redriveSweep{max: defaultRedriveMax}
+
+	if v, ok := os.LookupEnv("REDRIVE_MAX"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid REDRIVE_MAX %q", v)
+		}
+		s.max = n
+	}
+
+	q, err := notifier.NewDLQ()
+	if err != nil {
+		return nil, err
+	}
+	s.queue = q
+
+	s.snow, err = notifier.NewSnowSink()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// run sends parked messages to SNOW again, in stream order for each change.
+// A failure leaves the rest of that change's messages on the queue, so a
+// later transition can't overtake an earlier one.
+func (s *redriveSweep) run(ctx context.Context) *RedriveReport {
+
+	rep := &RedriveReport{Redriven: []string{}}
+
+	dls, err := s.queue.Receive(ctx, s.max)
+	if err != nil {
+		log.Printf("could not read the dead-letter queue: %v", err)
+		rep.Errors = append(rep.Errors, err.Error())
+	}
+	rep.Received = len(dls)
+
+	for _, g := range byChange(dls) {
+		for i, dl := range g {
+			if ctx.Err() != nil {
+				rep.Errors = append(rep.Errors, fmt.Sprintf("%v messages not redriven: %v", len(g)-i, ctx.Err()))
+				break
+			}
+
+			m := dl.Restore()
+			_, err := s.snow.Deliver(ctx, m)
+			if err != nil {
+				log.Printf("could not redrive %v, leaving %v messages for it on the queue: %v", m.SupplierRef, len(g)-i, err)
+				rep.Errors = append(rep.Errors, fmt.Sprintf("%v: %v", m.SupplierRef, err))
+				break
+			}
+
+			err = s.queue.Delete(ctx, dl)
+			if err != nil {
+				log.Printf("could not remove redriven %v from the queue: %v", m.SupplierRef, err)
+				rep.Errors = append(rep.Errors, fmt.Sprintf("%v: %v", m.SupplierRef, err))
+			}
+			log.Printf("redrove %v %v to SNOW", m.SupplierRef, m.Event)
+			rep.Redriven = append(rep.Redriven, m.SupplierRef)
+		}
+	}
+	return rep
+}
+
+// byChange groups parked messages by change, each in stream order, keeping
+// the order changes were first seen
+func byChange(dls []*notifier.DeadLetter) [][]*notifier.DeadLetter {
+
+	var groups [][]*notifier.DeadLetter
+	index := make(map[string]int)
+
+	for _, dl := range dls {
+		ref := dl.Message.SupplierRef
+		g, ok := index[ref]
+		if !ok {
+			g = len(groups)
+			index[ref] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], dl)
+	}
+
+	for _, g := range groups {
+		sort.SliceStable(g, func(i, j int) bool {
+			return notifier.SequenceBefore(g[i].Sequence, g[j].Sequence)
+		})
+	}
+	return groups
+}
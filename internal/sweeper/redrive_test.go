@@ -0,0 +1,85 @@
+package sweeper
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/notifier"
+)
+
+// fakeQueue hands out parked messages and records deletions
+type fakeQueue struct {
+	parked  []*notifier.DeadLetter
+	deleted []*notifier.DeadLetter
+}
+
+func (fq *fakeQueue) Receive(ctx context.Context, max int) ([]*notifier.DeadLetter, error) {
+	return fq.parked, nil
+}
+
+func (fq *fakeQueue) Delete(ctx context.Context, dl *notifier.DeadLetter) error {
+	fq.deleted = append(fq.deleted, dl)
+	return nil
+}
+
+// failingSnow records messages and fails those for changes in fail
+type failingSnow struct {
+	fail map[string]bool
+	sent []string
+}
+
+func (fs *failingSnow) Name() string {
+	return "snow"
+}
+
+func (fs *failingSnow) Deliver(ctx context.Context, m *notifier.Message) (*notifier.Result, error) {
+	if fs.fail[m.SupplierRef] {
+		return nil, errors.New("SNOW said no")
+	}
+	fs.sent = append(fs.sent, m.SupplierRef+":"+m.Status)
+	return &notifier.Result{Outcome: notifier.OutcomeUpdated}, nil
+}
+
+// parked builds a dead letter for a change's status at a stream position
+func parked(ref, status, seq string) *notifier.DeadLetter {
+	return &notifier.DeadLetter{
+		Reason:   "no SNOW configuration item",
+		Message:  notifier.Message{Payload: notifier.Payload{SupplierRef: ref, Status: status}},
+		Sequence: seq,
+	}
+}
+
+func TestRedrive(t *testing.T) {
+
+	q := &fakeQueue{parked: []*notifier.DeadLetter{
+		parked("ACP-1", "In Progress", "200"),
+		parked("ACP-2", "Scheduled", "150"),
+		parked("ACP-1", "Scheduled", "100"),
+		parked("ACP-3", "Scheduled", "300"),
+		parked("ACP-3", "In Progress", "1000"),
+		parked("ACP-2", "In Progress", "250"),
+	}}
+	snow := &failingSnow{fail: map[string]bool{"ACP-2": true}}
+
+	s := &redriveSweep{queue: q, snow: snow, max: 10}
+	rep := s.run(context.Background())
+
+	// each change goes in stream order, a failure holds back the rest of it
+	expect := []string{"ACP-1:Scheduled", "ACP-1:In Progress", "ACP-3:Scheduled", "ACP-3:In Progress"}
+	if !reflect.DeepEqual(snow.sent, expect) {
+		t.Errorf("expected %v, got %v", expect, snow.sent)
+	}
+	if rep.Received != 6 || len(rep.Redriven) != 4 || len(rep.Errors) != 1 {
+		t.Errorf("unexpected report %+v", rep)
+	}
+	if len(q.deleted) != 4 {
+		t.Errorf("expected 4 messages removed from the queue, got %v", len(q.deleted))
+	}
+	for _, dl := range q.deleted {
+		if dl.Message.SupplierRef == "ACP-2" {
+			t.Errorf("expected ACP-2 to stay on the queue")
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package sweeper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/notifier"
+)
+
+// defaultStuckAfter is how long a change may wait for an internal_identifier
+const defaultStuckAfter = time.Hour
+
+// StuckReport is the outcome of a stuck record sweep
+type StuckReport struct {
+	Checked   int      `json:"checked"`
+	Stuck     []string `json:"stuck"`
+	Recovered []string `json:"recovered"`
+	Recreated []string `json:"recreated"`
+	Ambiguous []string `json:"ambiguous,omitempty"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// stuckSweep finds changes that never got an internal_identifier
+type stuckSweep struct {
+	db       *DB
+	snow     notifier.Sink
+	after    time.Duration
+	recreate bool
+	now      func() time.Time
+}
+
+// newStuckSweep reads STUCK_AFTER and STUCK_RECREATE
+func newStuckSweep(db *DB) (*stuckSweep, error) {
+
+	s := &stuckSweep{db: db, after: defaultStuckAfter, recreate: true, now: time.Now}
+
+	if v, ok := os.LookupEnv("STUCK_AFTER"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid STUCK_AFTER %q", v)
+		}
+		s.after = d
+	}
+
+	s.recreate = os.Getenv("STUCK_RECREATE") != "false"
+	if s.recreate {
+		var err error
+		s.snow, err = notifier.NewSnowSink()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// run looks each stuck change up in SNOW by supplier ref, storing the ID if
+// SNOW has it and raising the change again if not
+func (s *stuckSweep) run(ctx context.Context, items []Item) *StuckReport {
+
+	rep := &StuckReport{Stuck: []string{}, Recovered: []string{}, Recreated: []string{}}
+	now := s.now()
+
+	for i, it := range items {
+		if ctx.Err() != nil {
+			log.Printf("stopping with %v changes left to check: %v", len(items)-i, ctx.Err())
+			rep.Errors = append(rep.Errors, fmt.Sprintf("%v changes not checked: %v", len(items)-i, ctx.Err()))
+			break
+		}
+		rep.Checked++
+
+		if !stuck(it, now, s.after) {
+			continue
+		}
+		rep.Stuck = append(rep.Stuck, it.SupplierRef)
+
+		changes, err := notifier.LookupRef(ctx, it.SupplierRef)
+		if err != nil {
+			log.Printf("could not look up %v in SNOW: %v", it.SupplierRef, err)
+			rep.Errors = append(rep.Errors, fmt.Sprintf("%v: %v", it.SupplierRef, err))
+			continue
+		}
+
+		switch {
+		case len(changes) == 1:
+			err = s.db.SetID(ctx, it.SupplierRef, changes[0].Number)
+			if err != nil {
+				log.Printf("could not store %v for %v: %v", changes[0].Number, it.SupplierRef, err)
+				rep.Errors = append(rep.Errors, fmt.Sprintf("%v: %v", it.SupplierRef, err))
+				continue
+			}
+			log.Printf("recovered %v for %v from SNOW", changes[0].Number, it.SupplierRef)
+			rep.Recovered = append(rep.Recovered, it.SupplierRef)
+
+		case len(changes) > 1:
+			// picking one could tie the change to the wrong SNOW record
+			log.Printf("%v changes in SNOW for %v, leaving it for a person", len(changes), it.SupplierRef)
+			rep.Ambiguous = append(rep.Ambiguous, it.SupplierRef)
+
+		case s.recreate:
+			err = s.raise(ctx, it)
+			if err != nil {
+				log.Printf("could not raise %v in SNOW: %v", it.SupplierRef, err)
+				rep.Errors = append(rep.Errors, fmt.Sprintf("%v: %v", it.SupplierRef, err))
+				continue
+			}
+			rep.Recreated = append(rep.Recreated, it.SupplierRef)
+
+		default:
+			log.Printf("%v is not in SNOW and STUCK_RECREATE is false", it.SupplierRef)
+		}
+	}
+	return rep
+}
+
+// stuck reports whether a change is still waiting too long for an ID,
+// whether or not the notifier ever tried to send it. Only Scheduled and In
+// Progress changes go to SNOW. Changes are aged from when they were
+// recorded, or by their last delivery attempt if that was before createdAt
+// was kept; without a time to go on a change isn't stuck.
+func stuck(it Item, now time.Time, after time.Duration) bool {
+
+	if it.IntIdent != "" || (it.Status != "Scheduled" && it.Status != "In Progress") {
+		return false
+	}
+
+	since := it.CreatedAt
+	if since == "" {
+		since = it.LastAttemptAt
+	}
+
+	if since == "" {
+		return false
+	}
+
+	t, err := time.Parse(time.RFC3339, since)
+	if err != nil {
+		return false
+	}
+	return now.Sub(t) >= after
+}
+
+// describe adds the change's CIs and change model to a message, as the
+// notifier sends them
+func describe(m *notifier.Message, it Item) error {
+
+	m.Components = it.Components
+	m.Assets = it.Assets
+
+	md, ok, err := notifier.ModelFor(it.IssueType, it.RequestType, it.Labels)
+	if err != nil {
+		return err
+	}
+	if ok {
+		m.SetModel(md)
+	}
+	return nil
+}
+
+// raise sends the change to SNOW again through the SNOW sink, which stores
+// the new ID and moves the change on from Scheduled if it has started
+func (s *stuckSweep) raise(ctx context.Context, it Item) error {
+
+	p := notifier.Payload{
+		SupplierRef: it.SupplierRef,
+		Status:      it.Status,
+		Title:       it.Title,
+		Description: it.Description,
+		StartTime:   it.StartTime,
+		EndTime:     it.EndTime,
+	}
+
+	m := notifier.NewCreate(p)
+	if it.Status != "Scheduled" {
+		m = notifier.NewUpdate("", p)
+	}
+
+	err := describe(m, it)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.snow.Deliver(ctx, m)
+	if err != nil {
+		return err
+	}
+	log.Printf("raised %v in SNOW as %v", it.SupplierRef, res.IntIdent)
+	return nil
+}
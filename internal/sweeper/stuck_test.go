@@ -0,0 +1,143 @@
+package sweeper
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UKHomeOffice/snow-forwarder/internal/notifier"
+	"github.com/UKHomeOffice/snow-forwarder/internal/snowmock"
+)
+
+// fakeSnow records what would be raised in SNOW
+type fakeSnow struct {
+	sent []string
+}
+
+func (fs *fakeSnow) Name() string {
+	return "snow"
+}
+
+func (fs *fakeSnow) Deliver(ctx context.Context, m *notifier.Message) (*notifier.Result, error) {
+	sent := m.MessageID + ":" + m.Status
+	if m.ChangeType != "" {
+		sent += ":" + m.ChangeType
+	}
+	fs.sent = append(fs.sent, sent)
+	return &notifier.Result{Outcome: notifier.OutcomeInserted, IntIdent: "CHG0000009"}, nil
+}
+
+func TestStuck(t *testing.T) {
+
+	mock := snowmock.New()
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+
+	env := map[string]string{
+		"TABLE_NAME":              "changes",
+		"SNOW_URL":                srv.URL,
+		"SNOW_TABLE_URL":          srv.URL + "/api/now/table/change_request",
+		"SNOW_CREDENTIALS_SOURCE": "env",
+		"SNOW_USERNAME":           "forwarder",
+		"SNOW_PASSWORD":           "s3cr3t",
+		"CHANGE_MODELS":           `[{"issueType":"Emergency Change","type":"emergency","skipScheduled":true}]`,
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	now := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	old := "2020-07-01T10:00:00Z"
+
+	tt := []struct {
+		name      string
+		item      Item
+		snow      []snowmock.Record
+		recreate  bool
+		dbErr     error
+		stuck     int
+		recovered int
+		recreated int
+		ambiguous int
+		marks     []string
+		sent      []string
+		err       string
+	}{
+		{name: "has ID", item: Item{SupplierRef: "ACP-1", Status: "Scheduled", IntIdent: "CHG0000001", CreatedAt: old, LastAttemptAt: old}},
+		{name: "cancelled", item: Item{SupplierRef: "ACP-1", Status: "Cancelled", CreatedAt: old, LastAttemptAt: old}},
+		{name: "recent", item: Item{SupplierRef: "ACP-1", Status: "Scheduled", CreatedAt: "2020-07-01T11:30:00Z", LastAttemptAt: old}},
+		{name: "not forwarded", item: Item{SupplierRef: "ACP-1", Status: "Open", CreatedAt: old, LastAttemptAt: old}},
+		{name: "completed", item: Item{SupplierRef: "ACP-1", Status: "Completed", CreatedAt: old, LastAttemptAt: old}},
+		{name: "never attempted", recreate: true, item: Item{SupplierRef: "ACP-1", Status: "Scheduled", CreatedAt: old},
+			stuck: 1, recreated: 1, sent: []string{"HO_SIAM_IN_REST_CHG_POST_JSON:Scheduled"}},
+		{name: "never attempted recent", item: Item{SupplierRef: "ACP-1", Status: "Scheduled", CreatedAt: "2020-07-01T11:30:00Z"}},
+		{name: "legacy", item: Item{SupplierRef: "ACP-1", Status: "Scheduled"}},
+		{name: "bad timestamp", item: Item{SupplierRef: "ACP-1", Status: "Scheduled", LastAttemptAt: "yesterday"}},
+		{name: "recovered", item: Item{SupplierRef: "ACP-1", Status: "Scheduled", CreatedAt: old, LastAttemptAt: old},
+			snow:  []snowmock.Record{{Number: "CHG0000001", SupplierRef: "ACP-1"}},
+			stuck: 1, recovered: 1, marks: []string{"ACP-1:internal_identifier=CHG0000001"}},
+		{name: "aged by attempt", item: Item{SupplierRef: "ACP-1", Status: "In Progress", LastAttemptAt: old},
+			snow:  []snowmock.Record{{Number: "CHG0000001", SupplierRef: "ACP-1"}},
+			stuck: 1, recovered: 1, marks: []string{"ACP-1:internal_identifier=CHG0000001"}},
+		{name: "ambiguous", item: Item{SupplierRef: "ACP-1", Status: "Scheduled", LastAttemptAt: old},
+			snow:  []snowmock.Record{{Number: "CHG0000001", SupplierRef: "ACP-1"}, {Number: "CHG0000002", SupplierRef: "acp-1"}},
+			stuck: 1, ambiguous: 1},
+		{name: "raised", recreate: true, item: Item{SupplierRef: "ACP-1", Status: "Scheduled", CreatedAt: old, LastAttemptAt: old},
+			stuck: 1, recreated: 1, sent: []string{"HO_SIAM_IN_REST_CHG_POST_JSON:Scheduled"}},
+		{name: "raised in progress", recreate: true, item: Item{SupplierRef: "ACP-1", Status: "In Progress", CreatedAt: old, LastAttemptAt: old},
+			stuck: 1, recreated: 1, sent: []string{"HO_SIAM_IN_REST_CHG_UPDATE_JSON:In Progress"}},
+		{name: "raised as emergency", recreate: true,
+			item:  Item{SupplierRef: "ACP-1", Status: "In Progress", IssueType: "Emergency Change", CreatedAt: old, LastAttemptAt: old},
+			stuck: 1, recreated: 1, sent: []string{"HO_SIAM_IN_REST_CHG_UPDATE_JSON:In Progress:emergency"}},
+		{name: "report only", item: Item{SupplierRef: "ACP-1", Status: "Scheduled", CreatedAt: old, LastAttemptAt: old}, stuck: 1},
+		{name: "db error", item: Item{SupplierRef: "ACP-1", Status: "Scheduled", CreatedAt: old, LastAttemptAt: old},
+			snow:  []snowmock.Record{{Number: "CHG0000001", SupplierRef: "ACP-1"}},
+			dbErr: errors.New("ConditionalCheckFailedException"), stuck: 1, err: "ConditionalCheckFailedException"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			mock.Reset()
+			for _, c := range tc.snow {
+				mock.Put(c)
+			}
+
+			md := &mockDynamoDB{err: tc.dbErr}
+			snow := &fakeSnow{}
+			s := &stuckSweep{db: &DB{DynamoDB: md}, after: time.Hour, recreate: tc.recreate,
+				now: func() time.Time { return now }}
+			if tc.recreate {
+				s.snow = snow
+			}
+
+			rep := s.run(context.Background(), []Item{tc.item})
+
+			if tc.err != "" {
+				if len(rep.Errors) != 1 || !strings.Contains(rep.Errors[0], tc.err) {
+					t.Errorf("expected error %q, got %v", tc.err, rep.Errors)
+				}
+			} else if len(rep.Errors) != 0 {
+				t.Fatalf("unexpected errors: %v", rep.Errors)
+			}
+
+			if len(rep.Stuck) != tc.stuck || len(rep.Recovered) != tc.recovered || len(rep.Recreated) != tc.recreated ||
+				len(rep.Ambiguous) != tc.ambiguous {
+				t.Errorf("expected %v stuck, %v recovered, %v raised, %v ambiguous, got %+v",
+					tc.stuck, tc.recovered, tc.recreated, tc.ambiguous, rep)
+			}
+
+			if strings.Join(md.marks, ",") != strings.Join(tc.marks, ",") {
+				t.Errorf("expected marks %v, got %v", tc.marks, md.marks)
+			}
+			if strings.Join(snow.sent, ",") != strings.Join(tc.sent, ",") {
+				t.Errorf("expected %v sent, got %v", tc.sent, snow.sent)
+			}
+		})
+	}
+}
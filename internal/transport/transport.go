@@ -0,0 +1,164 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// tlsVersions are the accepted values of OUTBOUND_TLS_MIN_VERSION
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Config is how outbound connections leave the function
+type Config struct {
+	// Proxy is used for every request not matched by NoProxy. Without it
+	// the standard HTTPS_PROXY, HTTP_PROXY and NO_PROXY variables apply.
+	Proxy   *url.URL
+	NoProxy []string
+	// RootCAs are trusted as well as the system roots, e.g. a TLS
+	// inspecting proxy's CA
+	RootCAs    *x509.CertPool
+	MinVersion uint16
+}
+
+// FromEnv reads OUTBOUND_PROXY, OUTBOUND_NO_PROXY, a comma separated list of
+// hosts, domains and CIDRs, OUTBOUND_CA_BUNDLE, a PEM file, and
+// OUTBOUND_TLS_MIN_VERSION, which defaults to 1.2
+func FromEnv() (*Config, error) {
+
+	c := &Config{MinVersion: tls.VersionTLS12}
+
+	if v, ok := os.LookupEnv("OUTBOUND_PROXY"); ok && v != "" {
+		u, err := url.Parse(v)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid OUTBOUND_PROXY %q", v)
+		}
+		c.Proxy = u
+	}
+
+	if v, ok := os.LookupEnv("OUTBOUND_NO_PROXY"); ok && v != "" {
+		for _, h := range strings.Split(v, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				c.NoProxy = append(c.NoProxy, strings.ToLower(h))
+			}
+		}
+	}
+
+	if f, ok := os.LookupEnv("OUTBOUND_CA_BUNDLE"); ok && f != "" {
+		pem, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, errors.New("could not read OUTBOUND_CA_BUNDLE: " + err.Error())
+		}
+
+		c.RootCAs, err = x509.SystemCertPool()
+		if err != nil || c.RootCAs == nil {
+			c.RootCAs = x509.NewCertPool()
+		}
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in OUTBOUND_CA_BUNDLE " + f)
+		}
+	}
+
+	if v, ok := os.LookupEnv("OUTBOUND_TLS_MIN_VERSION"); ok && v != "" {
+		mv, ok := tlsVersions[v]
+		if !ok {
+			return nil, fmt.Errorf("invalid OUTBOUND_TLS_MIN_VERSION %q, expected 1.0 to 1.3", v)
+		}
+		c.MinVersion = mv
+	}
+	return c, nil
+}
+
+// New returns a transport configured from the environment
+func New() (*http.Transport, error) {
+
+	c, err := FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return c.Transport(), nil
+}
+
+// Transport builds an HTTP transport for the config
+func (c *Config) Transport() *http.Transport {
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = c.proxy
+	tr.TLSClientConfig = &tls.Config{
+		RootCAs:    c.RootCAs,
+		MinVersion: c.MinVersion,
+	}
+	return tr
+}
+
+// proxy picks the proxy for a request
+func (c *Config) proxy(req *http.Request) (*url.URL, error) {
+
+	if c.Proxy == nil {
+		return http.ProxyFromEnvironment(req)
+	}
+	if c.bypass(req.URL) {
+		return nil, nil
+	}
+	return c.Proxy, nil
+}
+
+// bypass reports whether a URL matches the no proxy list. Entries match a
+// host exactly, a domain and its subdomains with or without a leading dot,
+// an IP range in CIDR form, or everything with *.
+func (c *Config) bypass(u *url.URL) bool {
+
+	host := strings.ToLower(u.Hostname())
+	ip := net.ParseIP(host)
+
+	for _, np := range c.NoProxy {
+		if np == "*" {
+			return true
+		}
+
+		if _, cidr, err := net.ParseCIDR(np); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		// host:port entries only match that port
+		if h, p, err := net.SplitHostPort(np); err == nil {
+			if p != port(u) {
+				continue
+			}
+			np = h
+		}
+
+		np = strings.TrimPrefix(np, ".")
+		if host == np || strings.HasSuffix(host, "."+np) {
+			return true
+		}
+	}
+	return false
+}
+
+// port returns the URL's port, or the scheme's default
+func port(u *url.URL) string {
+
+	if p := u.Port(); p != "" {
+		return p
+	}
+	if u.Scheme == "http" {
+		return "80"
+	}
+	return "443"
+}
@@ -0,0 +1,183 @@
+package transport
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFromEnv(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	empty := filepath.Join(dir, "empty.pem")
+	ioutil.WriteFile(empty, []byte("not a cert"), 0600)
+
+	tt := []struct {
+		name    string
+		env     map[string]string
+		version uint16
+		noProxy []string
+		err     string
+	}{
+		{name: "defaults", version: tls.VersionTLS12},
+		{name: "configured", env: map[string]string{"OUTBOUND_PROXY": "http://proxy:3128",
+			"OUTBOUND_NO_PROXY": "localhost, .Internal ,", "OUTBOUND_TLS_MIN_VERSION": "1.3"},
+			version: tls.VersionTLS13, noProxy: []string{"localhost", ".internal"}},
+		{name: "bad proxy", env: map[string]string{"OUTBOUND_PROXY": "proxy"}, err: "invalid OUTBOUND_PROXY"},
+		{name: "bad version", env: map[string]string{"OUTBOUND_TLS_MIN_VERSION": "1.4"}, err: "invalid OUTBOUND_TLS_MIN_VERSION"},
+		{name: "missing bundle", env: map[string]string{"OUTBOUND_CA_BUNDLE": filepath.Join(dir, "nope.pem")},
+			err: "could not read OUTBOUND_CA_BUNDLE"},
+		{name: "empty bundle", env: map[string]string{"OUTBOUND_CA_BUNDLE": empty}, err: "no certificates found"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+
+			for k, v := range tc.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			c, err := FromEnv()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if c.MinVersion != tc.version || strings.Join(c.NoProxy, ",") != strings.Join(tc.noProxy, ",") {
+				t.Errorf("unexpected config: %+v", c)
+			}
+		})
+	}
+}
+
+func TestBypass(t *testing.T) {
+
+	c := Config{NoProxy: []string{"localhost", ".internal", "corp.example", "10.0.0.0/8", "api.example:8443"}}
+
+	tt := []struct {
+		url    string
+		expect bool
+	}{
+		{url: "http://localhost:8080/x", expect: true},
+		{url: "https://jira.internal/rest", expect: true},
+		{url: "https://internal/rest", expect: true},
+		{url: "https://snow.corp.example", expect: true},
+		{url: "https://notcorp.example"},
+		{url: "https://10.1.2.3/api", expect: true},
+		{url: "https://192.168.1.1/api"},
+		{url: "https://api.example:8443/", expect: true},
+		{url: "https://api.example/"},
+		{url: "https://instance.service-now.com/api"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.url, func(t *testing.T) {
+			u, _ := url.Parse(tc.url)
+			if got := c.bypass(u); got != tc.expect {
+				t.Errorf("expected %v, got %v", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestProxy(t *testing.T) {
+
+	// a forward proxy sees the full target URL
+	var seen string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	os.Setenv("OUTBOUND_PROXY", proxy.URL)
+	defer os.Unsetenv("OUTBOUND_PROXY")
+
+	tr, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hc := &http.Client{Transport: tr}
+
+	res, err := hc.Get("http://snow.example/api/now/import")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res.Body.Close()
+	if seen != "http://snow.example/api/now/import" {
+		t.Errorf("expected request through the proxy, proxy saw %q", seen)
+	}
+
+	// the target is on 127.0.0.1, so listing it goes direct
+	os.Setenv("OUTBOUND_NO_PROXY", "127.0.0.0/8")
+	defer os.Unsetenv("OUTBOUND_NO_PROXY")
+
+	tr, _ = New()
+	seen = ""
+	res, err = (&http.Client{Transport: tr}).Get(target.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res.Body.Close()
+	if seen != "" {
+		t.Errorf("expected request to bypass the proxy, proxy saw %q", seen)
+	}
+}
+
+func TestCABundle(t *testing.T) {
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bundle := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600)
+
+	// untrusted without the bundle
+	tr, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = (&http.Client{Transport: tr}).Get(srv.URL)
+	if err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("expected certificate error, got: %v", err)
+	}
+
+	os.Setenv("OUTBOUND_CA_BUNDLE", bundle)
+	defer os.Unsetenv("OUTBOUND_CA_BUNDLE")
+
+	tr, err = New()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res, err := (&http.Client{Transport: tr}).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("expected bundle to be trusted, got: %v", err)
+	}
+	res.Body.Close()
+}